@@ -1,9 +1,11 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 
 	tea "github.com/charmbracelet/bubbletea"
 
@@ -12,6 +14,13 @@ import (
 )
 
 func main() {
+	sampleRate := flag.Int("sample-rate", 0, "audio output sample rate in Hz, e.g. 44100 or 48000 (saved)")
+	bufferMs := flag.Int("buffer", 0, "audio output buffer in milliseconds, lower means less latency (saved)")
+	scanBatch := flag.Int("scan-batch", 0, "number of scanned tracks saved per transaction (saved)")
+	scanFlushMs := flag.Int("scan-flush", 0, "longest time in milliseconds scanned tracks wait to be saved (saved)")
+	watch := flag.Bool("watch", false, "watch music sources and update the library as files change (saved)")
+	flag.Parse()
+
 	// Get config directory
 	configDir, err := os.UserConfigDir()
 	if err != nil {
@@ -34,9 +43,59 @@ func main() {
 	}
 	defer database.Close()
 
+	if flag.Arg(0) == "playlist" {
+		code := runPlaylist(database, flag.Args()[1:])
+		database.Close()
+		os.Exit(code)
+	}
+	if flag.Arg(0) == "scrobble" {
+		code := runScrobble(database, flag.Args()[1:])
+		database.Close()
+		os.Exit(code)
+	}
+
+	// Persist output settings given on the command line
+	if *sampleRate > 0 {
+		if err := database.SaveSetting(ui.SampleRateKey, strconv.Itoa(*sampleRate)); err != nil {
+			fmt.Printf("Error saving sample rate: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *bufferMs > 0 {
+		if err := database.SaveSetting(ui.BufferSizeKey, strconv.Itoa(*bufferMs)); err != nil {
+			fmt.Printf("Error saving buffer size: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *scanBatch > 0 {
+		if err := database.SaveSetting(ui.ScanBatchKey, strconv.Itoa(*scanBatch)); err != nil {
+			fmt.Printf("Error saving scan batch size: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *scanFlushMs > 0 {
+		if err := database.SaveSetting(ui.ScanFlushKey, strconv.Itoa(*scanFlushMs)); err != nil {
+			fmt.Printf("Error saving scan flush interval: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	watchSet := false
+	flag.Visit(func(f *flag.Flag) {
+		watchSet = watchSet || f.Name == "watch"
+	})
+	if watchSet {
+		if err := database.SaveSetting(ui.WatchKey, strconv.FormatBool(*watch)); err != nil {
+			fmt.Printf("Error saving watch mode: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	p := tea.NewProgram(
 		ui.NewModel(database),
 		tea.WithAltScreen(),
+		tea.WithMouseCellMotion(),
 	)
 
 	if _, err := p.Run(); err != nil {
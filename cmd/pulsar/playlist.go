@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/llehouerou/pulsar/pkg/db"
+	"github.com/llehouerou/pulsar/pkg/playlist"
+)
+
+const playlistUsage = `usage:
+  pulsar playlist import [-name NAME] FILE
+  pulsar playlist export NAME FILE
+
+FILE is an M3U/M3U8, PLS or XSPF playlist, picked by its extension.`
+
+// runPlaylist imports or exports a playlist and returns the exit code
+func runPlaylist(database *db.DB, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, playlistUsage)
+		return 2
+	}
+
+	switch args[0] {
+	case "import":
+		flags := flag.NewFlagSet("playlist import", flag.ContinueOnError)
+		name := flags.String("name", "", "name of the playlist, the file name by default")
+		if err := flags.Parse(args[1:]); err != nil || flags.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, playlistUsage)
+			return 2
+		}
+		result, err := playlist.Import(database, flags.Arg(0), *name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error importing playlist: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Imported %d tracks into %q\n", result.Tracks, result.Playlist.Name)
+		if len(result.Unresolved) > 0 {
+			fmt.Fprintf(os.Stderr, "%d entries not found in the library:\n", len(result.Unresolved))
+			for _, entry := range result.Unresolved {
+				fmt.Fprintf(os.Stderr, "  %s\n", entry)
+			}
+		}
+		return 0
+
+	case "export":
+		if len(args) != 3 {
+			fmt.Fprintln(os.Stderr, playlistUsage)
+			return 2
+		}
+		playlists, err := database.GetPlaylists()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading playlists: %v\n", err)
+			return 1
+		}
+		for _, p := range playlists {
+			if strings.EqualFold(p.Name, args[1]) {
+				if err := playlist.Export(database, p.ID, args[2]); err != nil {
+					fmt.Fprintf(os.Stderr, "Error exporting playlist: %v\n", err)
+					return 1
+				}
+				fmt.Printf("Exported %d tracks to %s\n", p.Tracks, args[2])
+				return 0
+			}
+		}
+		fmt.Fprintf(os.Stderr, "No playlist named %q\n", args[1])
+		return 1
+	}
+
+	fmt.Fprintln(os.Stderr, playlistUsage)
+	return 2
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/x/term"
+
+	"github.com/llehouerou/pulsar/pkg/db"
+	"github.com/llehouerou/pulsar/pkg/scrobble"
+)
+
+const scrobbleUsage = `usage:
+  pulsar scrobble listenbrainz [-url URL] TOKEN
+  pulsar scrobble lastfm [-url URL] -key KEY -secret SECRET USER
+  pulsar scrobble off listenbrainz|lastfm
+  pulsar scrobble status
+
+TOKEN is the user token of the ListenBrainz settings. KEY and SECRET are
+those of a Last.fm API account, the password of USER is asked to open a
+session. URL replaces the service endpoint, such as a self-hosted instance.`
+
+// scrobbleCredentials lists the settings enabling each service
+var scrobbleCredentials = map[string][]string{
+	"listenbrainz": {scrobble.ListenBrainzTokenKey},
+	"lastfm":       {scrobble.LastFMSessionKey},
+}
+
+// runScrobble configures the scrobbling services and returns the exit code
+func runScrobble(database *db.DB, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, scrobbleUsage)
+		return 2
+	}
+
+	switch args[0] {
+	case "listenbrainz":
+		flags := flag.NewFlagSet("scrobble listenbrainz", flag.ContinueOnError)
+		url := flags.String("url", "", "root of the ListenBrainz API")
+		if err := flags.Parse(args[1:]); err != nil || flags.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, scrobbleUsage)
+			return 2
+		}
+		err := saveSettings(database, map[string]string{
+			scrobble.ListenBrainzTokenKey: flags.Arg(0),
+			scrobble.ListenBrainzURLKey:   *url,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error saving settings: %v\n", err)
+			return 1
+		}
+		fmt.Println("Scrobbling to ListenBrainz")
+		return 0
+
+	case "lastfm":
+		flags := flag.NewFlagSet("scrobble lastfm", flag.ContinueOnError)
+		url := flags.String("url", "", "endpoint of the Last.fm API")
+		key := flags.String("key", "", "Last.fm API key")
+		secret := flags.String("secret", "", "Last.fm API shared secret")
+		if err := flags.Parse(args[1:]); err != nil || flags.NArg() != 1 || *key == "" || *secret == "" {
+			fmt.Fprintln(os.Stderr, scrobbleUsage)
+			return 2
+		}
+		password, err := readPassword("Last.fm password: ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading password: %v\n", err)
+			return 1
+		}
+		lastfm := &scrobble.LastFM{URL: *url, APIKey: *key, Secret: *secret}
+		session, err := lastfm.Login(context.Background(), flags.Arg(0), password)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error logging in: %v\n", err)
+			return 1
+		}
+		err = saveSettings(database, map[string]string{
+			scrobble.LastFMAPIKeyKey:  *key,
+			scrobble.LastFMSecretKey:  *secret,
+			scrobble.LastFMSessionKey: session,
+			scrobble.LastFMURLKey:     *url,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error saving settings: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Scrobbling to Last.fm as %s\n", flags.Arg(0))
+		return 0
+
+	case "off":
+		if len(args) != 2 || scrobbleCredentials[args[1]] == nil {
+			fmt.Fprintln(os.Stderr, scrobbleUsage)
+			return 2
+		}
+		settings := make(map[string]string)
+		for _, key := range scrobbleCredentials[args[1]] {
+			settings[key] = ""
+		}
+		if err := saveSettings(database, settings); err != nil {
+			fmt.Fprintf(os.Stderr, "Error saving settings: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Stopped scrobbling to %s, waiting plays are kept\n", args[1])
+		return 0
+
+	case "status":
+		if len(args) != 1 {
+			fmt.Fprintln(os.Stderr, scrobbleUsage)
+			return 2
+		}
+		return scrobbleStatus(database)
+	}
+
+	fmt.Fprintln(os.Stderr, scrobbleUsage)
+	return 2
+}
+
+// scrobbleStatus prints the state of each service and of its outbox
+func scrobbleStatus(database *db.DB) int {
+	for _, service := range []string{"listenbrainz", "lastfm"} {
+		enabled := "off"
+		for _, key := range scrobbleCredentials[service] {
+			value, err := database.GetSetting(key)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading settings: %v\n", err)
+				return 1
+			}
+			if value != "" {
+				enabled = "on"
+			}
+		}
+		waiting, err := database.CountScrobbles(service)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading the outbox: %v\n", err)
+			return 1
+		}
+		fmt.Printf("%s: %s, %d plays waiting\n", service, enabled, waiting)
+
+		oldest, err := database.GetScrobbles(service, 1)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading the outbox: %v\n", err)
+			return 1
+		}
+		if len(oldest) > 0 && oldest[0].LastError != "" {
+			fmt.Printf("  %d failed attempts, last error: %s\n", oldest[0].Attempts, oldest[0].LastError)
+		}
+	}
+	return 0
+}
+
+func saveSettings(database *db.DB, settings map[string]string) error {
+	for key, value := range settings {
+		if err := database.SaveSetting(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readPassword asks for a password without echoing it, or reads a line when
+// the input is not a terminal
+func readPassword(prompt string) (string, error) {
+	fd := os.Stdin.Fd()
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return string(password), err
+}
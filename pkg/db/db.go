@@ -3,60 +3,63 @@ package db
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 
 	"github.com/llehouerou/pulsar/pkg/media"
 )
 
 type DB struct {
 	db *sql.DB
+	// fts5 is set when searches use the FTS5 index
+	fts5 bool
 }
 
+// driverName is the SQLite driver registering the SQL functions of the
+// library on every connection
+const driverName = "sqlite3_pulsar"
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("album_folder", albumFolder, true)
+		},
+	})
+}
+
+// connectionPragmas are applied to every connection of the pool. WAL lets
+// the UI read while a scan writes, and makes NORMAL sync safe. Recursive
+// triggers make the rows replaced by INSERT OR REPLACE fire the delete
+// triggers keeping the search index in sync.
+const connectionPragmas = "_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=5000" +
+	"&_recursive_triggers=1"
+
 func New(path string) (*DB, error) {
-	db, err := sql.Open("sqlite3", path)
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	db, err := sql.Open(driverName, path+separator+connectionPragmas)
 	if err != nil {
 		return nil, err
 	}
 
-	// Create tables if they don't exist
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS settings (
-			key TEXT PRIMARY KEY,
-			value TEXT NOT NULL
-		);
-
-		CREATE TABLE IF NOT EXISTS sources (
-			id TEXT PRIMARY KEY,
-			type TEXT NOT NULL,
-			name TEXT NOT NULL,
-			config TEXT NOT NULL,
-			last_scanned DATETIME
-		);
-
-		CREATE TABLE IF NOT EXISTS tracks (
-			id TEXT PRIMARY KEY,
-			source_id TEXT NOT NULL,
-			source_type TEXT NOT NULL,
-			path TEXT NOT NULL,
-			title TEXT NOT NULL,
-			artist TEXT,
-			album TEXT,
-			duration INTEGER,
-			last_scanned DATETIME,
-			FOREIGN KEY(source_id) REFERENCES sources(id)
-		);
-
-		CREATE INDEX IF NOT EXISTS idx_tracks_source ON tracks(source_id);
-		CREATE INDEX IF NOT EXISTS idx_tracks_artist ON tracks(artist);
-		CREATE INDEX IF NOT EXISTS idx_tracks_album ON tracks(album);
-	`)
+	if err := migrate(db, path, migrations); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	fts5, err := ensureSearchIndex(db)
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	return &DB{db: db}, nil
+	return &DB{db: db, fts5: fts5}, nil
 }
 
 func (d *DB) SaveSource(source *media.SourceConfig) error {
@@ -72,6 +75,59 @@ func (d *DB) SaveSource(source *media.SourceConfig) error {
 	return err
 }
 
+// UpdateSource renames a source and replaces its configuration
+func (d *DB) UpdateSource(source *media.SourceConfig) error {
+	config, err := json.Marshal(source.Config)
+	if err != nil {
+		return err
+	}
+
+	result, err := d.db.Exec(`
+		UPDATE sources SET name = ?, config = ? WHERE id = ?
+	`, source.Name, string(config), source.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("source not found: %s", source.ID)
+	}
+	return nil
+}
+
+// RemoveSource deletes a source along with its tracks, their queue and
+// playlist entries and its scan report. Their plays stay in the history.
+func (d *DB) RemoveSource(sourceID string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		`DELETE FROM queue
+		WHERE track_id IN (SELECT id FROM tracks WHERE source_id = ?)`,
+		`DELETE FROM playlist_items
+		WHERE track_id IN (SELECT id FROM tracks WHERE source_id = ?)`,
+		`DELETE FROM tracks WHERE source_id = ?`,
+		`DELETE FROM scan_issues WHERE source_id = ?`,
+		`DELETE FROM scan_reports WHERE source_id = ?`,
+		`DELETE FROM sources WHERE id = ?`,
+	} {
+		if _, err := tx.Exec(query, sourceID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// MarkSourceScanned records when a source was last scanned
+func (d *DB) MarkSourceScanned(sourceID string, at time.Time) error {
+	_, err := d.db.Exec(`
+		UPDATE sources SET last_scanned = ? WHERE id = ?
+	`, at, sourceID)
+	return err
+}
+
 func (d *DB) GetSources() ([]media.SourceConfig, error) {
 	rows, err := d.db.Query(`
 		SELECT id, type, name, config, last_scanned
@@ -108,47 +164,417 @@ func (d *DB) GetSources() ([]media.SourceConfig, error) {
 	return sources, nil
 }
 
-func (d *DB) SaveTrack(track *media.Track) error {
-	_, err := d.db.Exec(`
-		INSERT OR REPLACE INTO tracks (
-			id, source_id, source_type, path, title, artist, album,
-			duration, last_scanned
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, track.ID, track.SourceID, track.SourceType, track.Path,
+// saveTrackQuery inserts a track, replacing the one with the same ID but
+// keeping the time it was added
+const saveTrackQuery = `
+	INSERT OR REPLACE INTO tracks (
+		id, source_id, source_type, path, title, artist, album,
+		album_artist, track_number, disc_number, year, genre,
+		composer, duration, rg_track_gain, rg_track_peak,
+		rg_album_gain, rg_album_peak, loudness_lufs, true_peak,
+		mtime, size, last_scanned, added_at
+	) VALUES (
+		?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?,
+		COALESCE((SELECT added_at FROM tracks WHERE id = ?), ?)
+	)`
+
+// trackArgs returns the saveTrackQuery arguments for a track
+func trackArgs(track *media.Track) []any {
+	addedAt := track.AddedAt
+	if addedAt.IsZero() {
+		addedAt = time.Now()
+	}
+	return []any{
+		track.ID, track.SourceID, track.SourceType, track.Path,
 		track.Title, track.Artist, track.Album,
-		track.Duration.Milliseconds(), track.LastScanned)
-	return err
+		track.AlbumArtist, track.TrackNumber, track.DiscNumber,
+		track.Year, track.Genre, track.Composer,
+		track.Duration.Milliseconds(),
+		nullFloat(track.ReplayGain.TrackGain, track.ReplayGain.HasTrack),
+		nullFloat(track.ReplayGain.TrackPeak, track.ReplayGain.HasTrack),
+		nullFloat(track.ReplayGain.AlbumGain, track.ReplayGain.HasAlbum),
+		nullFloat(track.ReplayGain.AlbumPeak, track.ReplayGain.HasAlbum),
+		nullFloat(track.Loudness.Integrated, track.Loudness.Analyzed),
+		nullFloat(track.Loudness.TruePeak, track.Loudness.Analyzed),
+		track.ModTime.UnixNano(), track.Size, track.LastScanned,
+		track.ID, addedAt.UnixNano(),
+	}
+}
+
+// SaveTracks saves a batch of tracks in a single transaction
+func (d *DB) SaveTracks(tracks []media.Track) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(saveTrackQuery)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for i := range tracks {
+		if _, err := stmt.Exec(trackArgs(&tracks[i])...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// albumOrder sorts tracks by album, then in the album's track order
+const albumOrder = `
+	COALESCE(NULLIF(t.album_artist, ''), t.artist), t.year, t.album,
+	t.disc_number, t.track_number, t.title`
+
+// Play statistics of the track t, listens that were skipped are not plays
+const (
+	playCountColumn = `(SELECT COUNT(*) FROM plays p
+		WHERE p.track_id = t.id AND p.skipped = 0)`
+	skipCountColumn = `(SELECT COUNT(*) FROM plays p
+		WHERE p.track_id = t.id AND p.skipped = 1)`
+	lastPlayedColumn = `(SELECT MAX(p.started_at) FROM plays p
+		WHERE p.track_id = t.id AND p.skipped = 0)`
+)
+
+// trackColumns lists the tracks table columns read by scanTracks
+const trackColumns = `
+	t.id, t.source_id, t.source_type, t.path, t.title, t.artist, t.album,
+	t.album_artist, t.track_number, t.disc_number, t.year, t.genre,
+	t.composer, t.duration, t.rg_track_gain, t.rg_track_peak, t.rg_album_gain,
+	t.rg_album_peak, t.loudness_lufs, t.true_peak, t.mtime, t.size,
+	t.last_scanned, t.added_at, ` + playCountColumn + `, ` + skipCountColumn + `,
+	` + lastPlayedColumn
+
+func scanTracks(rows *sql.Rows) ([]media.Track, error) {
+	var tracks []media.Track
+	for rows.Next() {
+		track, err := scanTrack(rows)
+		if err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, track)
+	}
+	return tracks, rows.Err()
+}
+
+// scanTrack reads the row of a track, after the given leading columns of the
+// row
+func scanTrack(rows *sql.Rows, leading ...any) (media.Track, error) {
+	var track media.Track
+	var durationMs, modTime, addedAt int64
+	var trackGain, trackPeak, albumGain, albumPeak sql.NullFloat64
+	var loudness, truePeak sql.NullFloat64
+	var lastPlayed sql.NullInt64
+	err := rows.Scan(append(leading,
+		&track.ID, &track.SourceID, &track.SourceType,
+		&track.Path, &track.Title, &track.Artist, &track.Album,
+		&track.AlbumArtist, &track.TrackNumber, &track.DiscNumber,
+		&track.Year, &track.Genre, &track.Composer, &durationMs,
+		&trackGain, &trackPeak, &albumGain, &albumPeak,
+		&loudness, &truePeak, &modTime, &track.Size, &track.LastScanned,
+		&addedAt, &track.PlayCount, &track.SkipCount, &lastPlayed,
+	)...)
+	if err != nil {
+		return media.Track{}, err
+	}
+	track.Duration = time.Duration(durationMs) * time.Millisecond
+	track.ModTime = time.Unix(0, modTime)
+	track.AddedAt = time.Unix(0, addedAt)
+	if lastPlayed.Valid {
+		track.LastPlayed = time.Unix(0, lastPlayed.Int64)
+	}
+	track.ReplayGain = media.ReplayGain{
+		TrackGain: trackGain.Float64,
+		TrackPeak: trackPeak.Float64,
+		AlbumGain: albumGain.Float64,
+		AlbumPeak: albumPeak.Float64,
+		HasTrack:  trackGain.Valid,
+		HasAlbum:  albumGain.Valid,
+	}
+	track.Loudness = media.Loudness{
+		Integrated: loudness.Float64,
+		TruePeak:   truePeak.Float64,
+		Analyzed:   loudness.Valid,
+	}
+	return track, nil
+}
+
+// nullFloat returns NULL when the value is not set
+func nullFloat(value float64, valid bool) sql.NullFloat64 {
+	return sql.NullFloat64{Float64: value, Valid: valid}
 }
 
 func (d *DB) GetTracks(sourceID string) ([]media.Track, error) {
 	rows, err := d.db.Query(`
-		SELECT id, source_id, source_type, path, title, artist, album,
-			duration, last_scanned
+		SELECT `+trackColumns+`
+		FROM tracks t
+		WHERE t.source_id = ?
+		ORDER BY `+albumOrder+`
+	`, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTracks(rows)
+}
+
+// GetFileStates returns the state of the files indexed for a source, keyed by
+// path
+func (d *DB) GetFileStates(sourceID string) (map[string]media.FileState, error) {
+	rows, err := d.db.Query(`
+		SELECT path, id, mtime, size
 		FROM tracks
 		WHERE source_id = ?
-		ORDER BY artist, album, title
 	`, sourceID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var tracks []media.Track
+	states := make(map[string]media.FileState)
 	for rows.Next() {
-		var track media.Track
-		var durationMs int64
-		err := rows.Scan(
-			&track.ID, &track.SourceID, &track.SourceType,
-			&track.Path, &track.Title, &track.Artist, &track.Album,
-			&durationMs, &track.LastScanned,
-		)
-		if err != nil {
+		var path string
+		var state media.FileState
+		var modTime int64
+		if err := rows.Scan(&path, &state.TrackID, &modTime, &state.Size); err != nil {
 			return nil, err
 		}
-		track.Duration = time.Duration(durationMs) * time.Millisecond
-		tracks = append(tracks, track)
+		state.ModTime = time.Unix(0, modTime)
+		states[path] = state
+	}
+	return states, rows.Err()
+}
+
+// RemoveTracks deletes tracks from the library
+func (d *DB) RemoveTracks(trackIDs []string) error {
+	if len(trackIDs) == 0 {
+		return nil
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Playlists drop the tracks along with the library, the history keeps
+	// them
+	for _, query := range []string{
+		`DELETE FROM playlist_items WHERE track_id = ?`,
+		`DELETE FROM tracks WHERE id = ?`,
+	} {
+		stmt, err := tx.Prepare(query)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, id := range trackIDs {
+			if _, err := stmt.Exec(id); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// MoveTrack gives the track newID, found at a new path, the identity of the
+// track oldID whose file moved there. The old entry is removed, the loudness
+// measured for it, the time it was added and its plays are kept.
+func (d *DB) MoveTrack(oldID, newID string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE tracks SET
+			loudness_lufs = (SELECT loudness_lufs FROM tracks WHERE id = ?1),
+			true_peak = (SELECT true_peak FROM tracks WHERE id = ?1),
+			added_at = (SELECT added_at FROM tracks WHERE id = ?1)
+		WHERE id = ?2
+	`, oldID, newID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM tracks WHERE id = ?`, oldID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE tracks SET id = ? WHERE id = ?`, oldID, newID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetUnanalyzedTracks returns the tracks whose loudness was never measured
+func (d *DB) GetUnanalyzedTracks() ([]media.Track, error) {
+	rows, err := d.db.Query(`
+		SELECT ` + trackColumns + `
+		FROM tracks t
+		WHERE t.loudness_lufs IS NULL
+		ORDER BY t.source_id, t.path
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTracks(rows)
+}
+
+// SaveLoudness stores the loudness measurement of a track
+func (d *DB) SaveLoudness(trackID string, loudness media.Loudness) error {
+	_, err := d.db.Exec(`
+		UPDATE tracks SET loudness_lufs = ?, true_peak = ?
+		WHERE id = ?
+	`, loudness.Integrated, loudness.TruePeak, trackID)
+	return err
+}
+
+// queueCurrentKey is the settings key holding the current queue index
+const queueCurrentKey = "queue.current"
+
+// GetScanReport returns the issues found by the scans of a source, an empty
+// report when it was never scanned
+func (d *DB) GetScanReport(sourceID string) (media.ScanReport, error) {
+	report := media.ScanReport{SourceID: sourceID}
+	err := d.db.QueryRow(`
+		SELECT scanned_at FROM scan_reports WHERE source_id = ?
+	`, sourceID).Scan(&report.ScannedAt)
+	if err == sql.ErrNoRows {
+		return report, nil
+	}
+	if err != nil {
+		return report, err
+	}
+
+	rows, err := d.db.Query(`
+		SELECT path, kind, message
+		FROM scan_issues
+		WHERE source_id = ?
+		ORDER BY path, kind
+	`, sourceID)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var issue media.ScanIssue
+		if err := rows.Scan(&issue.Path, &issue.Kind, &issue.Message); err != nil {
+			return report, err
+		}
+		report.Issues = append(report.Issues, issue)
+	}
+	return report, rows.Err()
+}
+
+// SaveScanReport replaces the scan report of a source
+func (d *DB) SaveScanReport(report media.ScanReport) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT OR REPLACE INTO scan_reports (source_id, scanned_at)
+		VALUES (?, ?)
+	`, report.SourceID, report.ScannedAt)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM scan_issues WHERE source_id = ?`, report.SourceID)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT OR REPLACE INTO scan_issues (source_id, path, kind, message)
+		VALUES (?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, issue := range report.Issues {
+		_, err := stmt.Exec(report.SourceID, issue.Path, issue.Kind, issue.Message)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SaveQueue replaces the persisted play queue
+func (d *DB) SaveQueue(trackIDs []string, current int) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM queue`); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO queue (position, track_id) VALUES (?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for i, id := range trackIDs {
+		if _, err := stmt.Exec(i, id); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`
+		INSERT OR REPLACE INTO settings (key, value)
+		VALUES (?, ?)
+	`, queueCurrentKey, strconv.Itoa(current))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// LoadQueue returns the persisted play queue and the current index
+func (d *DB) LoadQueue() ([]media.Track, int, error) {
+	rows, err := d.db.Query(`
+		SELECT ` + trackColumns + `
+		FROM queue q
+		JOIN tracks t ON t.id = q.track_id
+		ORDER BY q.position
+	`)
+	if err != nil {
+		return nil, -1, err
+	}
+	defer rows.Close()
+
+	tracks, err := scanTracks(rows)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	value, err := d.GetSetting(queueCurrentKey)
+	if err != nil {
+		return nil, -1, err
+	}
+	current := -1
+	if value != "" {
+		if current, err = strconv.Atoi(value); err != nil {
+			return nil, -1, err
+		}
 	}
-	return tracks, nil
+	return tracks, current, nil
 }
 
 func (d *DB) Close() error {
@@ -0,0 +1,253 @@
+package db
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+)
+
+// newTestDB opens a new database in a temporary directory, closed at the end
+// of the test
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	d, err := New(filepath.Join(t.TempDir(), "pulsar.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+// saveTestTracks saves tracks to the library. Unless set, a track belongs to
+// the source src-1, lives at /music/<id>.mp3, is titled by its ID and lasts
+// one minute.
+func saveTestTracks(t *testing.T, d *DB, tracks ...media.Track) []media.Track {
+	t.Helper()
+	for i := range tracks {
+		track := &tracks[i]
+		if track.SourceID == "" {
+			track.SourceID = "src-1"
+		}
+		if track.Path == "" {
+			track.Path = "/music/" + track.ID + ".mp3"
+		}
+		if track.Title == "" {
+			track.Title = track.ID
+		}
+		if track.Duration == 0 {
+			track.Duration = time.Minute
+		}
+	}
+	if err := d.SaveTracks(tracks); err != nil {
+		t.Fatal(err)
+	}
+	return tracks
+}
+
+// trackIDs returns the IDs of tracks in order
+func trackIDs(tracks []media.Track) []string {
+	var ids []string
+	for _, track := range tracks {
+		ids = append(ids, track.ID)
+	}
+	return ids
+}
+
+func TestSources(t *testing.T) {
+	d := newTestDB(t)
+
+	scanned := time.Date(2024, 5, 1, 20, 30, 0, 0, time.UTC)
+	sources := []media.SourceConfig{
+		{ID: "src-1", Type: "filesystem", Name: "Music", Config: map[string]string{"paths": "/music"}},
+		{ID: "src-2", Type: "filesystem", Name: "Podcasts", Config: map[string]string{"paths": "/podcasts"}},
+	}
+	for i := range sources {
+		if err := d.SaveSource(&sources[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.MarkSourceScanned("src-1", scanned); err != nil {
+		t.Fatal(err)
+	}
+	tracks := saveTestTracks(t, d,
+		media.Track{ID: "trk-1"},
+		media.Track{ID: "trk-2"},
+		media.Track{ID: "trk-3", SourceID: "src-2"},
+	)
+	if err := d.SaveQueue([]string{"trk-1", "trk-3", "trk-2"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	playlist, err := d.CreatePlaylist("Mixed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddPlaylistTracks(playlist.ID, []string{"trk-3", "trk-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.RecordPlay(media.Play{Track: tracks[0], StartedAt: scanned}); err != nil {
+		t.Fatal(err)
+	}
+	err = d.SaveScanReport(media.ScanReport{
+		SourceID:  "src-1",
+		ScannedAt: scanned,
+		Issues:    []media.ScanIssue{{Path: "/music/locked", Kind: media.IssueUnreadable}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Updating renames and reconfigures, the scan time stays
+	updated := media.SourceConfig{
+		ID:     "src-1",
+		Type:   "filesystem",
+		Name:   "Albums",
+		Config: map[string]string{"paths": "/albums"},
+	}
+	if err := d.UpdateSource(&updated); err != nil {
+		t.Fatal(err)
+	}
+	got, err := d.GetSources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Name != "Albums" || got[0].Config["paths"] != "/albums" ||
+		!got[0].LastScanned.Equal(scanned) {
+		t.Errorf("sources after update = %+v", got)
+	}
+	missing := media.SourceConfig{ID: "src-3", Name: "Gone"}
+	if err := d.UpdateSource(&missing); err == nil {
+		t.Error("UpdateSource of an unknown source succeeded")
+	}
+
+	// Removing a source takes its tracks out of the library, the queue and
+	// the playlists, not the history
+	if err := d.RemoveSource("src-1"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := d.GetSources(); err != nil || len(got) != 1 || got[0].ID != "src-2" {
+		t.Errorf("sources after removal = %+v, %v, want src-2", got, err)
+	}
+	if tracks, err := d.GetTracks("src-1"); err != nil || len(tracks) != 0 {
+		t.Errorf("tracks of the removed source = %v, %v, want none", trackIDs(tracks), err)
+	}
+	queue, _, err := d.LoadQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := trackIDs(queue); !reflect.DeepEqual(ids, []string{"trk-3"}) {
+		t.Errorf("queue = %v, want [trk-3]", ids)
+	}
+	items, err := d.GetPlaylistTracks(playlist.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := trackIDs(items); !reflect.DeepEqual(ids, []string{"trk-3"}) {
+		t.Errorf("playlist = %v, want [trk-3]", ids)
+	}
+	report, err := d.GetScanReport("src-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Issues) != 0 || !report.ScannedAt.IsZero() {
+		t.Errorf("scan report of the removed source = %+v, want none", report)
+	}
+	plays, err := d.GetPlays(10, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plays) != 1 || !plays[0].Removed || plays[0].Track.Title != "trk-1" {
+		t.Errorf("history after removal = %+v, want the removed play of trk-1", plays)
+	}
+}
+
+func TestMoveTrack(t *testing.T) {
+	d := newTestDB(t)
+
+	modTime := time.Unix(1700000000, 123456789)
+	saveTestTracks(t, d,
+		media.Track{ID: "trk-1", Path: "/music/old.mp3", ModTime: modTime, Size: 300},
+		media.Track{ID: "trk-2", ModTime: modTime, Size: 400},
+	)
+	loudness := media.Loudness{Integrated: -14, TruePeak: 0.9, Analyzed: true}
+	if err := d.SaveLoudness("trk-1", loudness); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SaveQueue([]string{"trk-2", "trk-1"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	playlist, err := d.CreatePlaylist("Moved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddPlaylistTracks(playlist.ID, []string{"trk-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.RecordPlay(media.Play{Track: media.Track{ID: "trk-1"}, StartedAt: modTime}); err != nil {
+		t.Fatal(err)
+	}
+	before, err := d.GetTracks("src-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The scan saves the moved file as a new track, then moves the old one
+	// onto it
+	saveTestTracks(t, d, media.Track{ID: "trk-3", Path: "/music/new.mp3", ModTime: modTime, Size: 300})
+	if err := d.MoveTrack("trk-1", "trk-3"); err != nil {
+		t.Fatal(err)
+	}
+
+	states, err := d.GetFileStates("src-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]media.FileState{
+		"/music/new.mp3":   {TrackID: "trk-1", ModTime: modTime, Size: 300},
+		"/music/trk-2.mp3": {TrackID: "trk-2", ModTime: modTime, Size: 400},
+	}
+	if len(states) != len(want) {
+		t.Errorf("file states = %+v, want %+v", states, want)
+	}
+	for path, w := range want {
+		if s := states[path]; s.TrackID != w.TrackID || !s.Unchanged(w.ModTime, w.Size) {
+			t.Errorf("state of %s = %+v, want %+v", path, s, w)
+		}
+	}
+
+	tracks, err := d.GetTracks("src-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The moved track takes the tags read from its new file
+	if ids := trackIDs(tracks); !reflect.DeepEqual(ids, []string{"trk-2", "trk-1"}) {
+		t.Fatalf("tracks = %v, want [trk-2 trk-1]", ids)
+	}
+	moved := tracks[1]
+	if moved.Title != "trk-3" {
+		t.Errorf("moved track title = %q, want the one of the new file", moved.Title)
+	}
+	if moved.Path != "/music/new.mp3" || moved.Loudness != loudness ||
+		!moved.AddedAt.Equal(before[0].AddedAt) || moved.PlayCount != 1 {
+		t.Errorf("moved track = %+v, want the new path with the loudness, added time and plays kept", moved)
+	}
+
+	// The queue and the playlists follow the track to its new path
+	queue, current, err := d.LoadQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := trackIDs(queue); !reflect.DeepEqual(ids, []string{"trk-2", "trk-1"}) || current != 1 ||
+		queue[1].Path != "/music/new.mp3" {
+		t.Errorf("queue = %+v at %d, want trk-2 then trk-1 at its new path", queue, current)
+	}
+	items, err := d.GetPlaylistTracks(playlist.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].ID != "trk-1" || items[0].Path != "/music/new.mp3" {
+		t.Errorf("playlist = %+v, want trk-1 at its new path", items)
+	}
+}
@@ -0,0 +1,143 @@
+package db
+
+import (
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+)
+
+// libraryTracks resolves the artist each track is filed under in the
+// library: its album artist, media.VariousArtists for albums without one
+// whose tracks have several artists, or else its own artist. An album is
+// its tracks sharing a title and that artist, and the same album_dir, see
+// albumFolder, so that unrelated albums with the same title stay apart. The
+// tracks without an album share an empty album_dir.
+const libraryTracks = `
+	WITH folders AS (
+		SELECT t.*, CASE
+			WHEN COALESCE(t.album, '') = '' THEN ''
+			ELSE album_folder(t.path)
+		END AS album_dir
+		FROM tracks t
+	),
+	library AS (
+		SELECT t.*, CASE
+			WHEN lower(t.album_artist) IN ('various artists', 'various', 'va')
+				THEN '` + media.VariousArtists + `'
+			WHEN t.album_artist != '' THEN t.album_artist
+			WHEN COALESCE(t.album, '') != '' AND
+				MIN(COALESCE(t.artist, '')) OVER album !=
+				MAX(COALESCE(t.artist, '')) OVER album
+				THEN '` + media.VariousArtists + `'
+			ELSE COALESCE(NULLIF(t.artist, ''), '` + media.UnknownArtist + `')
+		END AS library_artist
+		FROM folders t
+		WINDOW album AS (PARTITION BY t.album, t.album_dir)
+	)`
+
+// discFolder matches the folders holding a disc of an album, such as "CD1"
+// or "Disc 2 - Live"
+var discFolder = regexp.MustCompile(`(?i)^(cd|dis[ck])[ _-]*\d+\b`)
+
+// albumFolder returns the folder of an album track: its directory, or the
+// album directory above it when it sits in a disc folder, so that the discs
+// of an album stay together. It backs the album_folder SQL function.
+func albumFolder(path string) string {
+	dir := filepath.Dir(path)
+	if discFolder.MatchString(filepath.Base(dir)) {
+		return filepath.Dir(dir)
+	}
+	return dir
+}
+
+// GetLibraryArtists returns the artists of all sources with their album and
+// track counts, compilations and untagged tracks last
+func (d *DB) GetLibraryArtists() ([]media.ArtistSummary, error) {
+	rows, err := d.db.Query(libraryTracks + `
+		SELECT library_artist, COUNT(*), SUM(tracks), SUM(duration)
+		FROM (
+			SELECT
+				library_artist,
+				COUNT(*) AS tracks,
+				COALESCE(SUM(duration), 0) AS duration
+			FROM library
+			GROUP BY library_artist, COALESCE(album, ''), album_dir
+		)
+		GROUP BY library_artist
+		ORDER BY
+			library_artist IN (
+				'` + media.VariousArtists + `', '` + media.UnknownArtist + `'
+			),
+			library_artist COLLATE NOCASE
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var artists []media.ArtistSummary
+	for rows.Next() {
+		var artist media.ArtistSummary
+		var durationMs int64
+		err := rows.Scan(&artist.Name, &artist.Albums, &artist.Tracks, &durationMs)
+		if err != nil {
+			return nil, err
+		}
+		artist.Duration = time.Duration(durationMs) * time.Millisecond
+		artists = append(artists, artist)
+	}
+	return artists, rows.Err()
+}
+
+// GetLibraryAlbums returns the albums filed under an artist by year, the
+// ones without a year last
+func (d *DB) GetLibraryAlbums(artist string) ([]media.AlbumSummary, error) {
+	rows, err := d.db.Query(libraryTracks+`
+		SELECT
+			COALESCE(album, '') AS album_title,
+			album_dir,
+			MAX(year) AS album_year,
+			COUNT(*),
+			COALESCE(SUM(duration), 0)
+		FROM library
+		WHERE library_artist = ?
+		GROUP BY album_title, album_dir
+		ORDER BY album_year = 0, album_year, album_title COLLATE NOCASE, album_dir
+	`, artist)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var albums []media.AlbumSummary
+	for rows.Next() {
+		album := media.AlbumSummary{Artist: artist}
+		var durationMs int64
+		err := rows.Scan(&album.Title, &album.Dir, &album.Year, &album.Tracks, &durationMs)
+		if err != nil {
+			return nil, err
+		}
+		album.Duration = time.Duration(durationMs) * time.Millisecond
+		albums = append(albums, album)
+	}
+	return albums, rows.Err()
+}
+
+// GetLibraryTracks returns the tracks of an album in disc and track order
+func (d *DB) GetLibraryTracks(album media.AlbumSummary) ([]media.Track, error) {
+	rows, err := d.db.Query(libraryTracks+`
+		SELECT `+trackColumns+`
+		FROM library t
+		WHERE t.library_artist = ? AND COALESCE(t.album, '') = ?
+			AND t.album_dir = ?
+		ORDER BY t.disc_number, t.track_number, t.title
+	`, album.Artist, album.Title, album.Dir)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTracks(rows)
+}
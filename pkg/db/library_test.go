@@ -0,0 +1,144 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+)
+
+func TestLibrary(t *testing.T) {
+	d := newTestDB(t)
+
+	track := func(id, path, artist, albumArtist, album string, year int) media.Track {
+		return media.Track{
+			ID:          id,
+			Path:        path,
+			Artist:      artist,
+			AlbumArtist: albumArtist,
+			Album:       album,
+			Year:        year,
+		}
+	}
+	disc := func(track media.Track, disc, number int) media.Track {
+		track.DiscNumber = disc
+		track.TrackNumber = number
+		return track
+	}
+	saveTestTracks(t, d,
+		// Two albums of an artist with the same title
+		track("queen-1", "/music/queen/hits 1/01.mp3", "Queen", "", "Greatest Hits", 1981),
+		track("queen-2", "/music/queen/hits 1/02.mp3", "Queen", "", "Greatest Hits", 1981),
+		track("queen-3", "/music/queen/hits 2/01.mp3", "Queen", "", "Greatest Hits", 1991),
+		// The same title again, by another artist
+		track("abba-1", "/music/abba/hits/01.mp3", "ABBA", "", "Greatest Hits", 1992),
+		// An album in a folder per disc
+		disc(track("wall-1", "/music/floyd/the wall/CD1/01.mp3", "Pink Floyd", "", "The Wall", 1979), 1, 1),
+		disc(track("wall-2", "/music/floyd/the wall/CD1/02.mp3", "Pink Floyd", "", "The Wall", 1979), 1, 2),
+		disc(track("wall-3", "/music/floyd/the wall/Disc 2/01.mp3", "Pink Floyd", "", "The Wall", 1979), 2, 1),
+		// A compilation without album artist, and one tagged as such
+		track("now-1", "/music/now/01.mp3", "Queen", "", "Now 1", 1983),
+		track("now-2", "/music/now/02.mp3", "ABBA", "", "Now 1", 1983),
+		track("britpop-1", "/music/britpop/01.mp3", "Blur", "VA", "Britpop", 1995),
+		// Untagged tracks in different directories
+		track("loose-1", "/music/loose/a.mp3", "", "", "", 0),
+		track("loose-2", "/music/other/b.mp3", "", "", "", 0),
+	)
+
+	artists, err := d.GetLibraryArtists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantArtists := []media.ArtistSummary{
+		{Name: "ABBA", Albums: 1, Tracks: 1, Duration: time.Minute},
+		{Name: "Pink Floyd", Albums: 1, Tracks: 3, Duration: 3 * time.Minute},
+		{Name: "Queen", Albums: 2, Tracks: 3, Duration: 3 * time.Minute},
+		{Name: media.UnknownArtist, Albums: 1, Tracks: 2, Duration: 2 * time.Minute},
+		{Name: media.VariousArtists, Albums: 2, Tracks: 3, Duration: 3 * time.Minute},
+	}
+	if !reflect.DeepEqual(artists, wantArtists) {
+		t.Errorf("artists = %+v\nwant %+v", artists, wantArtists)
+	}
+
+	tests := []struct {
+		artist string
+		albums []media.AlbumSummary
+		// tracks lists the track IDs of each album
+		tracks [][]string
+	}{
+		{
+			"Queen",
+			[]media.AlbumSummary{
+				{Title: "Greatest Hits", Dir: "/music/queen/hits 1", Year: 1981, Tracks: 2},
+				{Title: "Greatest Hits", Dir: "/music/queen/hits 2", Year: 1991, Tracks: 1},
+			},
+			[][]string{{"queen-1", "queen-2"}, {"queen-3"}},
+		},
+		{
+			"Pink Floyd",
+			[]media.AlbumSummary{
+				{Title: "The Wall", Dir: "/music/floyd/the wall", Year: 1979, Tracks: 3},
+			},
+			[][]string{{"wall-1", "wall-2", "wall-3"}},
+		},
+		{
+			media.VariousArtists,
+			[]media.AlbumSummary{
+				{Title: "Now 1", Dir: "/music/now", Year: 1983, Tracks: 2},
+				{Title: "Britpop", Dir: "/music/britpop", Year: 1995, Tracks: 1},
+			},
+			[][]string{{"now-1", "now-2"}, {"britpop-1"}},
+		},
+		{
+			media.UnknownArtist,
+			[]media.AlbumSummary{{Tracks: 2}},
+			[][]string{{"loose-1", "loose-2"}},
+		},
+	}
+	for _, tt := range tests {
+		albums, err := d.GetLibraryAlbums(tt.artist)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range tt.albums {
+			tt.albums[i].Artist = tt.artist
+			tt.albums[i].Duration = time.Duration(tt.albums[i].Tracks) * time.Minute
+		}
+		if !reflect.DeepEqual(albums, tt.albums) {
+			t.Errorf("albums of %s = %+v\nwant %+v", tt.artist, albums, tt.albums)
+			continue
+		}
+		for i, album := range albums {
+			tracks, err := d.GetLibraryTracks(album)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ids := trackIDs(tracks); !reflect.DeepEqual(ids, tt.tracks[i]) {
+				t.Errorf("tracks of %s %q in %s = %v, want %v", tt.artist, album.Title, album.Dir, ids, tt.tracks[i])
+			}
+		}
+	}
+}
+
+func TestAlbumFolder(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/music/album/01.mp3", "/music/album"},
+		{"/music/album/CD1/01.mp3", "/music/album"},
+		{"/music/album/cd 2/01.mp3", "/music/album"},
+		{"/music/album/Disc 10 - Live/01.mp3", "/music/album"},
+		{"/music/album/disk_3/01.mp3", "/music/album"},
+		// Only disc numbers make disc folders
+		{"/music/CDs/01.mp3", "/music/CDs"},
+		{"/music/Discovery/01.mp3", "/music/Discovery"},
+		{"/music/cd1x/01.mp3", "/music/cd1x"},
+	}
+	for _, tt := range tests {
+		if got := albumFolder(tt.path); got != tt.want {
+			t.Errorf("albumFolder(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// migration upgrades the schema by one version
+type migration struct {
+	description string
+	// destructive migrations rewrite or delete existing data, the database
+	// file is backed up before they run
+	destructive bool
+	up          func(tx *sql.Tx) error
+}
+
+// migrations are applied in order, the schema version stored in PRAGMA
+// user_version is the number of migrations applied. Databases created
+// before versioning have version 0 and may already hold some of the columns,
+// so the early migrations only add what is missing.
+var migrations = []migration{
+	{
+		description: "create sources and tracks",
+		up: execMigration(`
+			CREATE TABLE IF NOT EXISTS settings (
+				key TEXT PRIMARY KEY,
+				value TEXT NOT NULL
+			);
+
+			CREATE TABLE IF NOT EXISTS sources (
+				id TEXT PRIMARY KEY,
+				type TEXT NOT NULL,
+				name TEXT NOT NULL,
+				config TEXT NOT NULL,
+				last_scanned DATETIME
+			);
+
+			CREATE TABLE IF NOT EXISTS tracks (
+				id TEXT PRIMARY KEY,
+				source_id TEXT NOT NULL,
+				source_type TEXT NOT NULL,
+				path TEXT NOT NULL,
+				title TEXT NOT NULL,
+				artist TEXT,
+				album TEXT,
+				duration INTEGER,
+				last_scanned DATETIME,
+				FOREIGN KEY(source_id) REFERENCES sources(id)
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_tracks_source ON tracks(source_id);
+			CREATE INDEX IF NOT EXISTS idx_tracks_artist ON tracks(artist);
+			CREATE INDEX IF NOT EXISTS idx_tracks_album ON tracks(album);
+		`),
+	},
+	{
+		description: "create play queue",
+		up: execMigration(`
+			CREATE TABLE IF NOT EXISTS queue (
+				position INTEGER PRIMARY KEY,
+				track_id TEXT NOT NULL,
+				FOREIGN KEY(track_id) REFERENCES tracks(id)
+			);
+		`),
+	},
+	{
+		description: "add ReplayGain columns",
+		up: addColumns("tracks", [][2]string{
+			{"rg_track_gain", "REAL"},
+			{"rg_track_peak", "REAL"},
+			{"rg_album_gain", "REAL"},
+			{"rg_album_peak", "REAL"},
+		}),
+	},
+	{
+		description: "add loudness columns",
+		up: addColumns("tracks", [][2]string{
+			{"loudness_lufs", "REAL"},
+			{"true_peak", "REAL"},
+		}),
+	},
+	{
+		description: "add tag metadata columns",
+		up: addColumns("tracks", [][2]string{
+			{"album_artist", "TEXT NOT NULL DEFAULT ''"},
+			{"track_number", "INTEGER NOT NULL DEFAULT 0"},
+			{"disc_number", "INTEGER NOT NULL DEFAULT 0"},
+			{"year", "INTEGER NOT NULL DEFAULT 0"},
+			{"genre", "TEXT NOT NULL DEFAULT ''"},
+			{"composer", "TEXT NOT NULL DEFAULT ''"},
+		}),
+	},
+	{
+		description: "key tracks by source and path",
+		destructive: true,
+		up: func(tx *sql.Tx) error {
+			// Rescans used to insert every file again under a new ID. Keep
+			// the latest copy of each file and move the queue over to it.
+			_, err := tx.Exec(`
+				CREATE TEMP TABLE track_keep AS
+				SELECT id, FIRST_VALUE(id) OVER (
+					PARTITION BY source_id, path
+					ORDER BY last_scanned DESC, rowid DESC
+				) AS keep_id
+				FROM tracks;
+
+				UPDATE queue SET track_id = (
+					SELECT keep_id FROM track_keep WHERE id = queue.track_id
+				)
+				WHERE track_id IN (SELECT id FROM track_keep);
+
+				DELETE FROM tracks
+				WHERE id IN (SELECT id FROM track_keep WHERE id != keep_id);
+
+				DROP TABLE track_keep;
+
+				CREATE UNIQUE INDEX IF NOT EXISTS idx_tracks_source_path
+				ON tracks(source_id, path);
+			`)
+			if err != nil {
+				return err
+			}
+			// Files are rescanned once to record their state
+			return addColumns("tracks", [][2]string{
+				{"mtime", "INTEGER NOT NULL DEFAULT 0"},
+				{"size", "INTEGER NOT NULL DEFAULT 0"},
+			})(tx)
+		},
+	},
+	{
+		description: "create scan reports",
+		up: execMigration(`
+			CREATE TABLE IF NOT EXISTS scan_reports (
+				source_id TEXT PRIMARY KEY,
+				scanned_at DATETIME NOT NULL,
+				FOREIGN KEY(source_id) REFERENCES sources(id)
+			);
+
+			CREATE TABLE IF NOT EXISTS scan_issues (
+				source_id TEXT NOT NULL,
+				path TEXT NOT NULL,
+				kind TEXT NOT NULL,
+				message TEXT NOT NULL,
+				PRIMARY KEY(source_id, path, kind),
+				FOREIGN KEY(source_id) REFERENCES sources(id)
+			);
+		`),
+	},
+	{
+		description: "create playlists",
+		up: execMigration(`
+			CREATE TABLE IF NOT EXISTS playlists (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				updated_at DATETIME NOT NULL
+			);
+
+			CREATE TABLE IF NOT EXISTS playlist_items (
+				playlist_id TEXT NOT NULL,
+				position INTEGER NOT NULL,
+				track_id TEXT NOT NULL,
+				PRIMARY KEY(playlist_id, position),
+				FOREIGN KEY(playlist_id) REFERENCES playlists(id),
+				FOREIGN KEY(track_id) REFERENCES tracks(id)
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_playlist_items_track
+			ON playlist_items(track_id);
+		`),
+	},
+	{
+		description: "index tracks by path",
+		up: execMigration(`
+			CREATE INDEX IF NOT EXISTS idx_tracks_path ON tracks(path);
+		`),
+	},
+	{
+		description: "add track added time",
+		up: func(tx *sql.Tx) error {
+			err := addColumns("tracks", [][2]string{
+				{"added_at", "INTEGER NOT NULL DEFAULT 0"},
+			})(tx)
+			if err != nil {
+				return err
+			}
+			// The files' modification time is the closest known to when
+			// they were added
+			_, err = tx.Exec(`UPDATE tracks SET added_at = mtime WHERE added_at = 0`)
+			return err
+		},
+	},
+	{
+		description: "add smart playlist rules",
+		up: addColumns("playlists", [][2]string{
+			{"rules", "TEXT"},
+		}),
+	},
+	{
+		description: "create plays",
+		up: execMigration(`
+			CREATE TABLE IF NOT EXISTS plays (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				track_id TEXT NOT NULL,
+				started_at INTEGER NOT NULL,
+				listened_ms INTEGER NOT NULL,
+				skipped INTEGER NOT NULL DEFAULT 0,
+				FOREIGN KEY(track_id) REFERENCES tracks(id)
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_plays_track ON plays(track_id, skipped);
+			CREATE INDEX IF NOT EXISTS idx_plays_started ON plays(started_at);
+		`),
+	},
+	{
+		description: "create scrobble outbox",
+		up: execMigration(`
+			CREATE TABLE IF NOT EXISTS scrobbles (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				service TEXT NOT NULL,
+				play_id INTEGER NOT NULL,
+				artist TEXT NOT NULL,
+				title TEXT NOT NULL,
+				album TEXT NOT NULL,
+				album_artist TEXT NOT NULL,
+				track_number INTEGER NOT NULL,
+				duration INTEGER NOT NULL,
+				played_at INTEGER NOT NULL,
+				attempts INTEGER NOT NULL DEFAULT 0,
+				last_error TEXT NOT NULL DEFAULT '',
+				UNIQUE(service, play_id)
+			);
+		`),
+	},
+	{
+		description: "create search index",
+		up:          createSearchIndex,
+	},
+	{
+		description: "keep the tracks of plays",
+		up: func(tx *sql.Tx) error {
+			err := addColumns("plays", [][2]string{
+				{"artist", "TEXT NOT NULL DEFAULT ''"},
+				{"title", "TEXT NOT NULL DEFAULT ''"},
+				{"album", "TEXT NOT NULL DEFAULT ''"},
+			})(tx)
+			if err != nil {
+				return err
+			}
+			// The plays of removed tracks were deleted along with them
+			_, err = tx.Exec(`
+				UPDATE plays SET
+					artist = COALESCE(t.artist, ''),
+					title = t.title,
+					album = COALESCE(t.album, '')
+				FROM tracks t
+				WHERE t.id = plays.track_id
+			`)
+			return err
+		},
+	},
+}
+
+// execMigration returns a migration running the given statements
+func execMigration(query string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(query)
+		return err
+	}
+}
+
+// addColumns returns a migration adding the given name and definition
+// columns to a table, skipping the ones that already exist
+func addColumns(table string, columns [][2]string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT name FROM pragma_table_info(?)`, table)
+		if err != nil {
+			return err
+		}
+		existing := make(map[string]bool)
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				rows.Close()
+				return err
+			}
+			existing[name] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, column := range columns {
+			if existing[column[0]] {
+				continue
+			}
+			_, err := tx.Exec(fmt.Sprintf(
+				`ALTER TABLE %s ADD COLUMN %s %s`, table, column[0], column[1],
+			))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// schemaVersion returns the number of migrations applied to the database
+func schemaVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow(`PRAGMA user_version`).Scan(&version)
+	return version, err
+}
+
+// migrate applies the migrations the database has not seen yet, each in its
+// own transaction. path is the database file, used to name backups.
+func migrate(db *sql.DB, path string, migrations []migration) error {
+	version, err := schemaVersion(db)
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if version > len(migrations) {
+		return fmt.Errorf(
+			"database schema version %d is newer than supported version %d",
+			version, len(migrations),
+		)
+	}
+
+	// A new database holds no data worth backing up
+	var tables int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master`).Scan(&tables); err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		m := migrations[i]
+		if m.destructive && tables > 0 {
+			if err := backup(db, path, i); err != nil {
+				return fmt.Errorf("failed to back up database: %w", err)
+			}
+		}
+		if err := applyMigration(db, i+1, m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", i+1, m.description, err)
+		}
+	}
+	return nil
+}
+
+// applyMigration runs a migration and records the new version atomically
+func applyMigration(db *sql.DB, version int, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.up(tx); err != nil {
+		return err
+	}
+	// PRAGMA arguments cannot be bound
+	if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// backupPath returns the file a database at the given version is backed up to
+func backupPath(path string, version int) string {
+	return fmt.Sprintf("%s.v%d.bak", path, version)
+}
+
+// backup writes a consistent copy of the database before a destructive
+// migration. In-memory databases have nothing to back up.
+func backup(db *sql.DB, path string, version int) error {
+	if path == "" || path == ":memory:" {
+		return nil
+	}
+	target := backupPath(path, version)
+	// VACUUM INTO refuses to overwrite, a backup left by an earlier failed
+	// attempt holds the same version
+	if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	_, err := db.Exec(`VACUUM INTO ?`, target)
+	return err
+}
@@ -0,0 +1,295 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fixtureDB creates a version 0 database from testdata/v0.sql and returns its
+// path
+func fixtureDB(t *testing.T) string {
+	t.Helper()
+
+	schema, err := os.ReadFile(filepath.Join("testdata", "v0.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "pulsar.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatalf("failed to load fixture: %v", err)
+	}
+	return path
+}
+
+func openRaw(t *testing.T, path string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func columns(t *testing.T, db *sql.DB, table string) map[string]bool {
+	t.Helper()
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	names := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names[name] = true
+	}
+	return names
+}
+
+func TestMigrateFromVersion0(t *testing.T) {
+	path := fixtureDB(t)
+
+	d, err := New(path)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer d.Close()
+
+	version, err := schemaVersion(d.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) {
+		t.Errorf("version = %d, want %d", version, len(migrations))
+	}
+
+	cols := columns(t, d.db, "tracks")
+	for _, name := range []string{
+		"rg_track_gain", "rg_album_peak", "loudness_lufs", "true_peak",
+		"album_artist", "track_number", "disc_number", "year", "genre",
+		"composer", "mtime", "size",
+	} {
+		if !cols[name] {
+			t.Errorf("tracks.%s is missing", name)
+		}
+	}
+
+	// Existing data survives and reads back through the current code
+	tracks, err := d.GetTracks("src-1")
+	if err != nil {
+		t.Fatalf("GetTracks: %v", err)
+	}
+	if len(tracks) != 2 {
+		t.Fatalf("got %d tracks, want 2", len(tracks))
+	}
+	if tracks[0].Title != "Song A" || tracks[0].Duration != 3*time.Minute {
+		t.Errorf("unexpected track %+v", tracks[0])
+	}
+	if tracks[0].ReplayGain.HasTrack || tracks[0].Loudness.Analyzed {
+		t.Errorf("migrated track has gain data: %+v", tracks[0])
+	}
+
+	volume, err := d.GetSetting("volume")
+	if err != nil || volume != "0.8" {
+		t.Errorf("GetSetting(volume) = %q, %v", volume, err)
+	}
+
+	// Only the latest copy of the duplicated file is kept
+	if tracks[0].ID != "trk-1" {
+		t.Errorf("kept duplicate %q, want trk-1", tracks[0].ID)
+	}
+
+	// Removing duplicates is destructive, the original file was backed up
+	matches, _ := filepath.Glob(path + ".*.bak")
+	if len(matches) != 1 {
+		t.Errorf("got backups %v, want one", matches)
+	}
+}
+
+func TestMigrateRemovesDuplicateTracks(t *testing.T) {
+	path := fixtureDB(t)
+	db := openRaw(t, path)
+
+	// Stop right before deduplication and queue the stale copy
+	dedupe := len(migrations) - 1
+	for migrations[dedupe].description != "key tracks by source and path" {
+		dedupe--
+	}
+	if err := migrate(db, path, migrations[:dedupe]); err != nil {
+		t.Fatal(err)
+	}
+	_, err := db.Exec(`INSERT INTO queue (position, track_id) VALUES (0, 'trk-0'), (1, 'trk-2')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrate(db, path, migrations); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	rows, err := db.Query(`SELECT track_id FROM queue ORDER BY position`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var queued []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		queued = append(queued, id)
+	}
+	if len(queued) != 2 || queued[0] != "trk-1" || queued[1] != "trk-2" {
+		t.Errorf("queue = %v, want [trk-1 trk-2]", queued)
+	}
+
+	// A second copy of a file can no longer be inserted
+	_, err = db.Exec(`
+		INSERT INTO tracks (id, source_id, source_type, path, title)
+		VALUES ('trk-3', 'src-1', 'filesystem', '/music/a.mp3', 'Song A')
+	`)
+	if err == nil {
+		t.Error("duplicate path was accepted")
+	}
+}
+
+func TestMigrateIsIdempotent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pulsar.db")
+	for i := 0; i < 2; i++ {
+		d, err := New(path)
+		if err != nil {
+			t.Fatalf("open %d: %v", i, err)
+		}
+		d.Close()
+	}
+
+	version, err := schemaVersion(openRaw(t, path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) {
+		t.Errorf("version = %d, want %d", version, len(migrations))
+	}
+}
+
+func TestMigrateBacksUpBeforeDestructiveStep(t *testing.T) {
+	path := fixtureDB(t)
+	db := openRaw(t, path)
+
+	steps := append(append([]migration(nil), migrations...), migration{
+		description: "drop tracks",
+		destructive: true,
+		up:          execMigration(`DELETE FROM tracks`),
+	})
+	if err := migrate(db, path, steps); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM tracks`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("tracks left after the destructive step: %d", count)
+	}
+
+	// The backup holds the data as it was right before the destructive step
+	backupFile := backupPath(path, len(migrations))
+	backup := openRaw(t, backupFile)
+	if err := backup.QueryRow(`SELECT COUNT(*) FROM tracks`).Scan(&count); err != nil {
+		t.Fatalf("read backup: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("backup has %d tracks, want 2", count)
+	}
+	version, err := schemaVersion(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) {
+		t.Errorf("backup version = %d, want %d", version, len(migrations))
+	}
+}
+
+func TestMigrateRollsBackFailedMigration(t *testing.T) {
+	path := fixtureDB(t)
+	db := openRaw(t, path)
+
+	failure := errors.New("boom")
+	steps := []migration{
+		migrations[0],
+		{
+			description: "half done",
+			up: func(tx *sql.Tx) error {
+				if _, err := tx.Exec(`CREATE TABLE partial (id INTEGER)`); err != nil {
+					return err
+				}
+				return failure
+			},
+		},
+	}
+	if err := migrate(db, path, steps); !errors.Is(err, failure) {
+		t.Fatalf("migrate error = %v, want %v", err, failure)
+	}
+
+	version, err := schemaVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Errorf("version = %d, want 1", version)
+	}
+	var tables int
+	err = db.QueryRow(
+		`SELECT COUNT(*) FROM sqlite_master WHERE name = 'partial'`,
+	).Scan(&tables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tables != 0 {
+		t.Error("failed migration was not rolled back")
+	}
+}
+
+func TestMigrateRejectsNewerSchema(t *testing.T) {
+	path := fixtureDB(t)
+	db := openRaw(t, path)
+	if _, err := db.Exec(`PRAGMA user_version = 1000`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrate(db, path, migrations); err == nil {
+		t.Error("migrating a newer schema succeeded")
+	}
+}
+
+func TestNewEnablesWAL(t *testing.T) {
+	d, err := New(filepath.Join(t.TempDir(), "pulsar.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	var mode string
+	if err := d.db.QueryRow(`PRAGMA journal_mode`).Scan(&mode); err != nil {
+		t.Fatal(err)
+	}
+	if mode != "wal" {
+		t.Errorf("journal_mode = %q, want wal", mode)
+	}
+}
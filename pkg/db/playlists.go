@@ -0,0 +1,368 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+)
+
+// errSmartPlaylist is returned when editing the tracks of a smart playlist
+var errSmartPlaylist = errors.New("the tracks of smart playlists follow their rules")
+
+// CreatePlaylist creates an empty playlist
+func (d *DB) CreatePlaylist(name string) (media.Playlist, error) {
+	return d.createPlaylist(name, nil)
+}
+
+// CreateSmartPlaylist creates a playlist whose tracks are selected by rules
+func (d *DB) CreateSmartPlaylist(name string, rules media.SmartRules) (media.Playlist, error) {
+	if err := rules.Validate(); err != nil {
+		return media.Playlist{}, err
+	}
+	return d.createPlaylist(name, &rules)
+}
+
+func (d *DB) createPlaylist(name string, rules *media.SmartRules) (media.Playlist, error) {
+	now := time.Now()
+	playlist := media.Playlist{
+		ID:        uuid.NewString(),
+		Name:      name,
+		Rules:     rules,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	encoded, err := encodeRules(rules)
+	if err != nil {
+		return media.Playlist{}, err
+	}
+	_, err = d.db.Exec(`
+		INSERT INTO playlists (id, name, rules, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, playlist.ID, playlist.Name, encoded, playlist.CreatedAt, playlist.UpdatedAt)
+	if err != nil {
+		return media.Playlist{}, err
+	}
+	return playlist, nil
+}
+
+// SetPlaylistRules replaces the rules of a smart playlist
+func (d *DB) SetPlaylistRules(playlistID string, rules media.SmartRules) error {
+	if err := rules.Validate(); err != nil {
+		return err
+	}
+	encoded, err := encodeRules(&rules)
+	if err != nil {
+		return err
+	}
+	result, err := d.db.Exec(`
+		UPDATE playlists SET rules = ?, updated_at = ?
+		WHERE id = ? AND rules IS NOT NULL
+	`, encoded, time.Now(), playlistID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("smart playlist not found: %s", playlistID)
+	}
+	return nil
+}
+
+// encodeRules returns the JSON stored for rules, NULL for static playlists
+func encodeRules(rules *media.SmartRules) (sql.NullString, error) {
+	if rules == nil {
+		return sql.NullString{}, nil
+	}
+	encoded, err := json.Marshal(rules)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(encoded), Valid: true}, nil
+}
+
+// decodeRules parses the stored rules, nil for static playlists
+func decodeRules(encoded sql.NullString) (*media.SmartRules, error) {
+	if !encoded.Valid {
+		return nil, nil
+	}
+	var rules media.SmartRules
+	if err := json.Unmarshal([]byte(encoded.String), &rules); err != nil {
+		return nil, fmt.Errorf("decode playlist rules: %w", err)
+	}
+	return &rules, nil
+}
+
+// GetPlaylists returns all playlists by name, with their track counts and
+// durations
+func (d *DB) GetPlaylists() ([]media.Playlist, error) {
+	rows, err := d.db.Query(`
+		SELECT
+			p.id, p.name, p.rules, p.created_at, p.updated_at,
+			COUNT(t.id), COALESCE(SUM(t.duration), 0)
+		FROM playlists p
+		LEFT JOIN playlist_items i ON i.playlist_id = p.id
+		LEFT JOIN tracks t ON t.id = i.track_id
+		GROUP BY p.id
+		ORDER BY p.name COLLATE NOCASE
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var playlists []media.Playlist
+	for rows.Next() {
+		var playlist media.Playlist
+		var rules sql.NullString
+		var durationMs int64
+		err := rows.Scan(
+			&playlist.ID,
+			&playlist.Name,
+			&rules,
+			&playlist.CreatedAt,
+			&playlist.UpdatedAt,
+			&playlist.Tracks,
+			&durationMs,
+		)
+		if err != nil {
+			return nil, err
+		}
+		playlist.Duration = time.Duration(durationMs) * time.Millisecond
+		if playlist.Rules, err = decodeRules(rules); err != nil {
+			return nil, err
+		}
+		playlists = append(playlists, playlist)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// The tracks of smart playlists depend on the library right now
+	for i, playlist := range playlists {
+		if !playlist.Smart() {
+			continue
+		}
+		query, args, err := compileRules(*playlist.Rules, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("playlist %s: %w", playlist.Name, err)
+		}
+		var durationMs int64
+		err = d.db.QueryRow(`
+			SELECT COUNT(*), COALESCE(SUM(duration), 0) FROM (`+query+`)
+		`, args...).Scan(&playlists[i].Tracks, &durationMs)
+		if err != nil {
+			return nil, err
+		}
+		playlists[i].Duration = time.Duration(durationMs) * time.Millisecond
+	}
+	return playlists, nil
+}
+
+// RenamePlaylist changes the name of a playlist
+func (d *DB) RenamePlaylist(playlistID, name string) error {
+	result, err := d.db.Exec(`
+		UPDATE playlists SET name = ?, updated_at = ? WHERE id = ?
+	`, name, time.Now(), playlistID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("playlist not found: %s", playlistID)
+	}
+	return nil
+}
+
+// DeletePlaylist deletes a playlist, the tracks stay in the library
+func (d *DB) DeletePlaylist(playlistID string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM playlist_items WHERE playlist_id = ?`, playlistID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM playlists WHERE id = ?`, playlistID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetPlaylistTracks returns the tracks of a playlist in order, the ones
+// matching the rules for smart playlists
+func (d *DB) GetPlaylistTracks(playlistID string) ([]media.Track, error) {
+	var encoded sql.NullString
+	err := d.db.QueryRow(`SELECT rules FROM playlists WHERE id = ?`, playlistID).Scan(&encoded)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("playlist not found: %s", playlistID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rules, err := decodeRules(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if rules != nil {
+		return d.smartTracks(*rules)
+	}
+
+	rows, err := d.db.Query(`
+		SELECT `+trackColumns+`
+		FROM playlist_items i
+		JOIN tracks t ON t.id = i.track_id
+		WHERE i.playlist_id = ?
+		ORDER BY i.position
+	`, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTracks(rows)
+}
+
+// AddPlaylistTracks appends tracks to a playlist
+func (d *DB) AddPlaylistTracks(playlistID string, trackIDs []string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var next int
+	err = tx.QueryRow(`
+		SELECT COALESCE(MAX(position) + 1, 0) FROM playlist_items WHERE playlist_id = ?
+	`, playlistID).Scan(&next)
+	if err != nil {
+		return err
+	}
+	if err := insertPlaylistItems(tx, playlistID, next, trackIDs); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetPlaylistTracks replaces the tracks of a playlist, to reorder or remove
+// them
+func (d *DB) SetPlaylistTracks(playlistID string, trackIDs []string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM playlist_items WHERE playlist_id = ?`, playlistID); err != nil {
+		return err
+	}
+	if err := insertPlaylistItems(tx, playlistID, 0, trackIDs); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertPlaylistItems inserts tracks into a playlist from a position and
+// marks the playlist updated
+func insertPlaylistItems(tx *sql.Tx, playlistID string, position int, trackIDs []string) error {
+	var smart bool
+	err := tx.QueryRow(`
+		SELECT rules IS NOT NULL FROM playlists WHERE id = ?
+	`, playlistID).Scan(&smart)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("playlist not found: %s", playlistID)
+	}
+	if err != nil {
+		return err
+	}
+	if smart {
+		return errSmartPlaylist
+	}
+	_, err = tx.Exec(`
+		UPDATE playlists SET updated_at = ? WHERE id = ?
+	`, time.Now(), playlistID)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO playlist_items (playlist_id, position, track_id)
+		VALUES (?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for i, id := range trackIDs {
+		if _, err := stmt.Exec(playlistID, position+i, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// smartTracks returns the tracks matching smart playlist rules
+func (d *DB) smartTracks(rules media.SmartRules) ([]media.Track, error) {
+	query, args, err := compileRules(rules, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTracks(rows)
+}
+
+// GetTrackByPath returns the track of a file, from any source
+func (d *DB) GetTrackByPath(path string) (media.Track, bool, error) {
+	rows, err := d.db.Query(`
+		SELECT `+trackColumns+`
+		FROM tracks t
+		WHERE t.path = ?
+		ORDER BY t.source_id
+		LIMIT 1
+	`, path)
+	if err != nil {
+		return media.Track{}, false, err
+	}
+	defer rows.Close()
+
+	return firstTrack(rows)
+}
+
+// FindTrack returns a track by its artist and title, ignoring case, for
+// playlists naming tracks the library holds under another path
+func (d *DB) FindTrack(artist, title string) (media.Track, bool, error) {
+	rows, err := d.db.Query(`
+		SELECT `+trackColumns+`
+		FROM tracks t
+		WHERE t.artist = ? COLLATE NOCASE AND t.title = ? COLLATE NOCASE
+		ORDER BY t.source_id, t.path
+		LIMIT 1
+	`, artist, title)
+	if err != nil {
+		return media.Track{}, false, err
+	}
+	defer rows.Close()
+
+	return firstTrack(rows)
+}
+
+// firstTrack returns the track of a query limited to one row, if any
+func firstTrack(rows *sql.Rows) (media.Track, bool, error) {
+	tracks, err := scanTracks(rows)
+	if err != nil || len(tracks) == 0 {
+		return media.Track{}, false, err
+	}
+	return tracks[0], true, nil
+}
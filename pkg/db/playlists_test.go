@@ -0,0 +1,71 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+)
+
+func TestPlaylists(t *testing.T) {
+	d := newTestDB(t)
+
+	saveTestTracks(t, d, media.Track{ID: "trk-1"}, media.Track{ID: "trk-2"}, media.Track{ID: "trk-3"})
+
+	playlist, err := d.CreatePlaylist("Road trip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Tracks can appear more than once
+	if err := d.AddPlaylistTracks(playlist.ID, []string{"trk-2", "trk-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddPlaylistTracks(playlist.ID, []string{"trk-2"}); err != nil {
+		t.Fatal(err)
+	}
+	playlistIDs := func() []string {
+		t.Helper()
+		got, err := d.GetPlaylistTracks(playlist.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return trackIDs(got)
+	}
+	if got, want := playlistIDs(), []string{"trk-2", "trk-1", "trk-2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tracks = %v, want %v", got, want)
+	}
+
+	if err := d.SetPlaylistTracks(playlist.ID, []string{"trk-1", "trk-2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RenamePlaylist(playlist.ID, "Commute"); err != nil {
+		t.Fatal(err)
+	}
+	playlists, err := d.GetPlaylists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(playlists) != 1 || playlists[0].Name != "Commute" ||
+		playlists[0].Tracks != 2 || playlists[0].Duration != 2*time.Minute {
+		t.Errorf("playlists = %+v, want Commute with 2 tracks of 2m", playlists)
+	}
+
+	// Removed tracks leave the playlists
+	if err := d.RemoveTracks([]string{"trk-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := playlistIDs(), []string{"trk-2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tracks after removal = %v, want %v", got, want)
+	}
+
+	if err := d.DeletePlaylist(playlist.ID); err != nil {
+		t.Fatal(err)
+	}
+	if playlists, err := d.GetPlaylists(); err != nil || len(playlists) != 0 {
+		t.Errorf("GetPlaylists() = %v, %v after delete, want none", playlists, err)
+	}
+	if err := d.RenamePlaylist(playlist.ID, "Gone"); err == nil {
+		t.Error("RenamePlaylist of a deleted playlist succeeded")
+	}
+}
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+)
+
+// RecordPlay saves a listen to a track and returns its ID. The artist,
+// title and album are kept with it, so the history outlives the track.
+func (d *DB) RecordPlay(play media.Play) (int64, error) {
+	result, err := d.db.Exec(`
+		INSERT INTO plays (
+			track_id, artist, title, album, started_at, listened_ms, skipped
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, play.Track.ID, play.Track.Artist, play.Track.Title, play.Track.Album,
+		play.StartedAt.UnixNano(), play.Listened.Milliseconds(), play.Skipped)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// UpdatePlayListened records how long a play was finally listened to, plays
+// being saved as soon as they reach the threshold
+func (d *DB) UpdatePlayListened(playID int64, listened time.Duration) error {
+	result, err := d.db.Exec(`
+		UPDATE plays SET listened_ms = ? WHERE id = ?
+	`, listened.Milliseconds(), playID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("play not found: %d", playID)
+	}
+	return nil
+}
+
+// GetPlays returns the latest listens first, up to limit, with the skipped
+// ones when withSkips is set. The listens of the tracks removed from the
+// library are marked as such.
+func (d *DB) GetPlays(limit int, withSkips bool) ([]media.Play, error) {
+	const latest = `
+		SELECT id, track_id, artist, title, album, started_at, listened_ms, skipped
+		FROM plays
+		WHERE skipped = 0 OR ?
+		ORDER BY started_at DESC, id DESC
+		LIMIT ?`
+	rows, err := d.db.Query(latest, withSkips, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var plays []media.Play
+	for rows.Next() {
+		var play media.Play
+		var startedAt, listenedMs int64
+		err := rows.Scan(
+			&play.ID, &play.Track.ID, &play.Track.Artist, &play.Track.Title,
+			&play.Track.Album, &startedAt, &listenedMs, &play.Skipped,
+		)
+		if err != nil {
+			return nil, err
+		}
+		play.StartedAt = time.Unix(0, startedAt)
+		play.Listened = time.Duration(listenedMs) * time.Millisecond
+		plays = append(plays, play)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Complete the tracks still in the library
+	rows, err = d.db.Query(`
+		SELECT `+trackColumns+`
+		FROM tracks t
+		WHERE t.id IN (SELECT track_id FROM (`+latest+`))
+	`, withSkips, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tracks, err := scanTracks(rows)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]media.Track, len(tracks))
+	for _, track := range tracks {
+		byID[track.ID] = track
+	}
+	for i := range plays {
+		track, ok := byID[plays[i].Track.ID]
+		if !ok {
+			plays[i].Removed = true
+			continue
+		}
+		plays[i].Track = track
+	}
+	return plays, nil
+}
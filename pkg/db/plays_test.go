@@ -0,0 +1,130 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+)
+
+func TestPlays(t *testing.T) {
+	d := newTestDB(t)
+
+	tracks := saveTestTracks(t, d,
+		media.Track{ID: "trk-1", Artist: "Nina Simone", Duration: 3 * time.Minute},
+		media.Track{ID: "trk-2", Artist: "Nina Simone", Duration: 3 * time.Minute},
+		media.Track{ID: "trk-3", Artist: "Nina Simone", Duration: 3 * time.Minute},
+	)
+
+	now := time.Now().Truncate(time.Millisecond)
+	record := func(track media.Track, started time.Time, skipped bool) int64 {
+		t.Helper()
+		id, err := d.RecordPlay(media.Play{
+			Track:     track,
+			StartedAt: started,
+			Listened:  time.Minute,
+			Skipped:   skipped,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	record(tracks[0], now.AddDate(0, 0, -40), false)
+	latest := record(tracks[0], now.Add(-time.Hour), false)
+	record(tracks[0], now.Add(-30*time.Minute), true)
+	record(tracks[1], now.AddDate(0, 0, -60), false)
+	record(tracks[1], now.Add(-10*time.Minute), true)
+
+	if err := d.UpdatePlayListened(latest, 3*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := d.GetTracks("src-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	type stats struct {
+		plays, skips int
+		last         time.Time
+	}
+	want := map[string]stats{
+		"trk-1": {2, 1, now.Add(-time.Hour)},
+		"trk-2": {1, 1, now.AddDate(0, 0, -60)},
+		"trk-3": {0, 0, time.Time{}},
+	}
+	for _, track := range got {
+		s := stats{track.PlayCount, track.SkipCount, track.LastPlayed}
+		if w := want[track.ID]; s.plays != w.plays || s.skips != w.skips || !s.last.Equal(w.last) {
+			t.Errorf("%s stats = %+v, want %+v", track.ID, s, w)
+		}
+	}
+
+	plays, err := d.GetPlays(10, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, play := range plays {
+		ids = append(ids, play.Track.ID)
+	}
+	if want := []string{"trk-1", "trk-1", "trk-2"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("plays = %v, want %v", ids, want)
+	}
+	if plays[0].ID != latest || plays[0].Listened != 3*time.Minute {
+		t.Errorf("latest play = %+v, want ID %d listened 3m", plays[0], latest)
+	}
+	all, err := d.GetPlays(2, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || !all[0].Skipped || all[0].Track.ID != "trk-2" {
+		t.Errorf("latest listens = %+v, want the skip of trk-2 first", all)
+	}
+
+	// Smart playlists select on the statistics
+	for rules, want := range map[string][]string{
+		"plays = 0":                      {"trk-3"},
+		"plays >= 1 and skips > 0":       {"trk-1", "trk-2"},
+		"lastplayed within 30d":          {"trk-1"},
+		"lastplayed !within 30d":         {"trk-2", "trk-3"},
+		"plays > 0 sort lastplayed desc": {"trk-1", "trk-2"},
+	} {
+		parsed, err := media.ParseSmartRules(rules)
+		if err != nil {
+			t.Fatalf("ParseSmartRules(%q): %v", rules, err)
+		}
+		tracks, err := d.smartTracks(parsed)
+		if err != nil {
+			t.Fatalf("%q: %v", rules, err)
+		}
+		if ids := trackIDs(tracks); !reflect.DeepEqual(ids, want) {
+			t.Errorf("%q = %v, want %v", rules, ids, want)
+		}
+	}
+
+	// The history keeps the listens of the tracks removed from the library
+	if err := d.RemoveTracks([]string{"trk-1"}); err != nil {
+		t.Fatal(err)
+	}
+	all, err = d.GetPlays(10, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 5 {
+		t.Fatalf("%d listens after removing trk-1, want 5", len(all))
+	}
+	for _, play := range all {
+		removed := play.Track.ID == "trk-1"
+		if play.Removed != removed {
+			t.Errorf("play %d of %s removed = %t, want %t", play.ID, play.Track.ID, play.Removed, removed)
+		}
+		if removed && (play.Track.Title != "trk-1" || play.Track.Artist != "Nina Simone") {
+			t.Errorf("removed play %d kept %+v, want its title and artist", play.ID, play.Track)
+		}
+		if !removed && play.Track.Duration != 3*time.Minute {
+			t.Errorf("play %d track = %+v, want the library track", play.ID, play.Track)
+		}
+	}
+}
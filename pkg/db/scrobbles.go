@@ -0,0 +1,122 @@
+package db
+
+import (
+	"time"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+)
+
+// QueueScrobble adds a play to the outbox of each scrobbling service, a play
+// already queued for a service is left as is
+func (d *DB) QueueScrobble(play media.Play, services []string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT OR IGNORE INTO scrobbles (
+			service, play_id, artist, title, album, album_artist,
+			track_number, duration, played_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	track := play.Track
+	for _, service := range services {
+		_, err := stmt.Exec(
+			service, play.ID, track.Artist, track.Title, track.Album,
+			track.AlbumArtist, track.TrackNumber, track.Duration.Milliseconds(),
+			play.StartedAt.UnixNano(),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetScrobbles returns the oldest plays waiting for a service, up to limit
+func (d *DB) GetScrobbles(service string, limit int) ([]media.Scrobble, error) {
+	rows, err := d.db.Query(`
+		SELECT
+			id, service, play_id, artist, title, album, album_artist,
+			track_number, duration, played_at, attempts, last_error
+		FROM scrobbles
+		WHERE service = ?
+		ORDER BY played_at, id
+		LIMIT ?
+	`, service, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var scrobbles []media.Scrobble
+	for rows.Next() {
+		var s media.Scrobble
+		var durationMs, playedAt int64
+		err := rows.Scan(
+			&s.ID, &s.Service, &s.PlayID, &s.Artist, &s.Title, &s.Album,
+			&s.AlbumArtist, &s.TrackNumber, &durationMs, &playedAt,
+			&s.Attempts, &s.LastError,
+		)
+		if err != nil {
+			return nil, err
+		}
+		s.Duration = time.Duration(durationMs) * time.Millisecond
+		s.PlayedAt = time.Unix(0, playedAt)
+		scrobbles = append(scrobbles, s)
+	}
+	return scrobbles, rows.Err()
+}
+
+// CountScrobbles returns the number of plays waiting for a service
+func (d *DB) CountScrobbles(service string) (int, error) {
+	var count int
+	err := d.db.QueryRow(`
+		SELECT COUNT(*) FROM scrobbles WHERE service = ?
+	`, service).Scan(&count)
+	return count, err
+}
+
+// RemoveScrobbles takes plays out of the outbox, once submitted or rejected
+func (d *DB) RemoveScrobbles(ids []int64) error {
+	return d.execScrobbles(`DELETE FROM scrobbles WHERE id = ?`, ids)
+}
+
+// FailScrobbles records a failed submission of plays, kept in the outbox to
+// be submitted again
+func (d *DB) FailScrobbles(ids []int64, reason string) error {
+	return d.execScrobbles(`
+		UPDATE scrobbles SET attempts = attempts + 1, last_error = ?2
+		WHERE id = ?1
+	`, ids, reason)
+}
+
+// execScrobbles runs a statement for each outbox entry, the entry ID being
+// its first argument
+func (d *DB) execScrobbles(query string, ids []int64, args ...any) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, id := range ids {
+		if _, err := stmt.Exec(append([]any{id}, args...)...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+)
+
+func TestScrobbleOutbox(t *testing.T) {
+	d := newTestDB(t)
+
+	started := time.Date(2024, 5, 1, 20, 30, 0, 0, time.UTC)
+	services := []string{"listenbrainz", "lastfm"}
+	for i, title := range []string{"second", "first"} {
+		play := media.Play{
+			ID: int64(i + 1),
+			Track: media.Track{
+				Artist:   "Artist",
+				Title:    title,
+				Album:    "Album",
+				Duration: 3 * time.Minute,
+			},
+			StartedAt: started.Add(-time.Duration(i) * time.Hour),
+		}
+		if err := d.QueueScrobble(play, services); err != nil {
+			t.Fatal(err)
+		}
+		// Queuing a play again changes nothing
+		if err := d.QueueScrobble(play, services); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scrobbles, err := d.GetScrobbles("lastfm", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scrobbles) != 2 || scrobbles[0].Title != "first" || scrobbles[1].Title != "second" {
+		t.Fatalf("scrobbles = %+v, want first then second", scrobbles)
+	}
+	if s := scrobbles[1]; !s.PlayedAt.Equal(started) || s.Duration != 3*time.Minute || s.PlayID != 1 {
+		t.Errorf("second scrobble = %+v", s)
+	}
+
+	if err := d.FailScrobbles([]int64{scrobbles[0].ID}, "offline"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.FailScrobbles([]int64{scrobbles[0].ID}, "still offline"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RemoveScrobbles([]int64{scrobbles[1].ID}); err != nil {
+		t.Fatal(err)
+	}
+	scrobbles, err = d.GetScrobbles("lastfm", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scrobbles) != 1 || scrobbles[0].Attempts != 2 || scrobbles[0].LastError != "still offline" {
+		t.Errorf("scrobbles = %+v, want first failed twice", scrobbles)
+	}
+
+	// Each service has its own outbox
+	if n, err := d.CountScrobbles("listenbrainz"); err != nil || n != 2 {
+		t.Errorf("listenbrainz count = %d, %v, want 2", n, err)
+	}
+}
@@ -0,0 +1,277 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+)
+
+// searchColumns are the track columns indexed for full-text search
+var searchColumns = []string{"title", "artist", "album", "album_artist", "genre", "path"}
+
+// searchFields maps the field names accepted in queries to columns
+var searchFields = map[string]string{
+	"title":        "title",
+	"artist":       "artist",
+	"album":        "album",
+	"albumartist":  "album_artist",
+	"album_artist": "album_artist",
+	"genre":        "genre",
+	"path":         "path",
+	"year":         "year",
+}
+
+// hasFTS5 reports whether SQLite was built with FTS5, which takes the
+// sqlite_fts5 build tag
+func hasFTS5(tx *sql.Tx) (bool, error) {
+	var fts5 bool
+	err := tx.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts5)
+	return fts5, err
+}
+
+// createSearchIndex creates the FTS5 index of the tracks, kept in sync by
+// triggers, and fills it with the tracks already there. Without FTS5 it does
+// nothing, and searching falls back to plain matching.
+func createSearchIndex(tx *sql.Tx) error {
+	fts5, err := hasFTS5(tx)
+	if err != nil || !fts5 {
+		return err
+	}
+
+	columns := strings.Join(searchColumns, ", ")
+	values := func(row string) string {
+		return row + "." + strings.Join(searchColumns, ", "+row+".")
+	}
+	_, err = tx.Exec(`
+		CREATE VIRTUAL TABLE IF NOT EXISTS tracks_fts USING fts5(
+			` + columns + `,
+			content = 'tracks',
+			tokenize = 'unicode61 remove_diacritics 2'
+		);
+
+		CREATE TRIGGER IF NOT EXISTS tracks_fts_insert AFTER INSERT ON tracks BEGIN
+			INSERT INTO tracks_fts (rowid, ` + columns + `)
+			VALUES (new.rowid, ` + values("new") + `);
+		END;
+
+		CREATE TRIGGER IF NOT EXISTS tracks_fts_delete AFTER DELETE ON tracks BEGIN
+			INSERT INTO tracks_fts (tracks_fts, rowid, ` + columns + `)
+			VALUES ('delete', old.rowid, ` + values("old") + `);
+		END;
+
+		CREATE TRIGGER IF NOT EXISTS tracks_fts_update AFTER UPDATE ON tracks BEGIN
+			INSERT INTO tracks_fts (tracks_fts, rowid, ` + columns + `)
+			VALUES ('delete', old.rowid, ` + values("old") + `);
+			INSERT INTO tracks_fts (rowid, ` + columns + `)
+			VALUES (new.rowid, ` + values("new") + `);
+		END;
+
+		INSERT INTO tracks_fts (tracks_fts) VALUES ('rebuild');
+	`)
+	return err
+}
+
+// ensureSearchIndex matches the search index to the SQLite build, since a
+// database may be opened by builds with and without FTS5. Without FTS5 the
+// index triggers are dropped so tracks can still be written. With it, an
+// index skipped or left stale by a build without it is created again. It
+// reports whether searches use FTS5.
+func ensureSearchIndex(db *sql.DB) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	fts5, err := hasFTS5(tx)
+	if err != nil {
+		return false, err
+	}
+	if !fts5 {
+		for _, trigger := range []string{"insert", "delete", "update"} {
+			if _, err := tx.Exec(`DROP TRIGGER IF EXISTS tracks_fts_` + trigger); err != nil {
+				return false, err
+			}
+		}
+		return false, tx.Commit()
+	}
+
+	// The index is complete as long as its triggers exist
+	var triggers int
+	err = tx.QueryRow(`
+		SELECT COUNT(*) FROM sqlite_master
+		WHERE type = 'trigger' AND name LIKE 'tracks_fts_%'
+	`).Scan(&triggers)
+	if err != nil {
+		return false, err
+	}
+	if triggers == 3 {
+		return true, nil
+	}
+	if err := createSearchIndex(tx); err != nil {
+		return false, fmt.Errorf("failed to create search index: %w", err)
+	}
+	return true, tx.Commit()
+}
+
+// searchTerm is a word or quoted phrase of a search query, limited to a
+// column when it has one
+type searchTerm struct {
+	column string
+	value  string
+}
+
+// searchQuery is a parsed search query
+type searchQuery struct {
+	terms []searchTerm
+	// yearFrom and yearTo bound the year when set
+	yearFrom, yearTo int
+}
+
+// parseSearchQuery splits a query into terms. Words are separated by spaces,
+// double quotes group words into a phrase, and a field:value prefix such as
+// artist:radiohead limits a term to one field. year:1997 and
+// year:1990-1999 filter on the year. Unknown fields are searched as words.
+func parseSearchQuery(query string) searchQuery {
+	var q searchQuery
+	for {
+		query = strings.TrimLeft(query, " \t")
+		if query == "" {
+			return q
+		}
+
+		var column string
+		if i := strings.IndexAny(query, ": \t\""); i > 0 && query[i] == ':' {
+			if c, ok := searchFields[strings.ToLower(query[:i])]; ok {
+				column = c
+				query = query[i+1:]
+			}
+		}
+
+		var value string
+		if strings.HasPrefix(query, `"`) {
+			end := strings.IndexByte(query[1:], '"')
+			if end < 0 {
+				value, query = query[1:], ""
+			} else {
+				value, query = query[1:end+1], query[end+2:]
+			}
+		} else {
+			end := strings.IndexAny(query, " \t")
+			if end < 0 {
+				end = len(query)
+			}
+			value, query = query[:end], query[end:]
+		}
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		if column == "year" {
+			from, to, ok := parseYears(value)
+			if ok {
+				q.yearFrom, q.yearTo = from, to
+			}
+			continue
+		}
+		q.terms = append(q.terms, searchTerm{column: column, value: value})
+	}
+}
+
+// parseYears parses a year or a range of years
+func parseYears(value string) (int, int, bool) {
+	first, last, isRange := strings.Cut(value, "-")
+	from, err := strconv.Atoi(first)
+	if err != nil {
+		return 0, 0, false
+	}
+	if !isRange {
+		return from, from, true
+	}
+	to, err := strconv.Atoi(last)
+	if err != nil || to < from {
+		return 0, 0, false
+	}
+	return from, to, true
+}
+
+// ftsMatch returns the FTS5 expression matching all the terms, each one as a
+// prefix so results show up while typing
+func (q searchQuery) ftsMatch() string {
+	parts := make([]string, len(q.terms))
+	for i, term := range q.terms {
+		phrase := `"` + strings.ReplaceAll(term.value, `"`, `""`) + `"*`
+		if term.column != "" {
+			phrase = term.column + " : " + phrase
+		}
+		parts[i] = phrase
+	}
+	return strings.Join(parts, " ")
+}
+
+// likeFilters returns the conditions matching all the terms without FTS5,
+// each term appearing anywhere in its columns
+func (q searchQuery) likeFilters() ([]string, []any) {
+	var filters []string
+	var args []any
+	escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	for _, term := range q.terms {
+		pattern := "%" + escaper.Replace(term.value) + "%"
+		columns := searchColumns
+		if term.column != "" {
+			columns = []string{term.column}
+		}
+		conditions := make([]string, len(columns))
+		for i, column := range columns {
+			conditions[i] = "t." + column + ` LIKE ? ESCAPE '\'`
+			args = append(args, pattern)
+		}
+		filters = append(filters, "("+strings.Join(conditions, " OR ")+")")
+	}
+	return filters, args
+}
+
+// Search returns up to limit tracks of all sources matching a query, best
+// matches first. See parseSearchQuery for the query syntax.
+func (d *DB) Search(query string, limit int) ([]media.Track, error) {
+	q := parseSearchQuery(query)
+	if len(q.terms) == 0 && q.yearFrom == 0 {
+		return nil, nil
+	}
+
+	var filters []string
+	var args []any
+	from := "tracks t"
+	order := albumOrder
+	if len(q.terms) > 0 && d.fts5 {
+		from = "tracks_fts f JOIN tracks t ON t.rowid = f.rowid"
+		filters = append(filters, "tracks_fts MATCH ?")
+		args = append(args, q.ftsMatch())
+		order = "f.rank"
+	} else {
+		filters, args = q.likeFilters()
+	}
+	if q.yearFrom > 0 {
+		filters = append(filters, "t.year BETWEEN ? AND ?")
+		args = append(args, q.yearFrom, q.yearTo)
+	}
+	args = append(args, limit)
+
+	rows, err := d.db.Query(`
+		SELECT `+trackColumns+`
+		FROM `+from+`
+		WHERE `+strings.Join(filters, " AND ")+`
+		ORDER BY `+order+`
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTracks(rows)
+}
@@ -0,0 +1,119 @@
+package db
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  searchQuery
+	}{
+		{"", searchQuery{}},
+		{"ok computer", searchQuery{terms: []searchTerm{{"", "ok"}, {"", "computer"}}}},
+		{`"ok computer"`, searchQuery{terms: []searchTerm{{"", "ok computer"}}}},
+		{
+			`Artist:radiohead album:"ok comp`,
+			searchQuery{terms: []searchTerm{{"artist", "radiohead"}, {"album", "ok comp"}}},
+		},
+		{"albumartist:va", searchQuery{terms: []searchTerm{{"album_artist", "va"}}}},
+		{"year:1997 karma", searchQuery{terms: []searchTerm{{"", "karma"}}, yearFrom: 1997, yearTo: 1997}},
+		{"year:1990-1999", searchQuery{yearFrom: 1990, yearTo: 1999}},
+		{"year:soon", searchQuery{}},
+		{"mood:happy", searchQuery{terms: []searchTerm{{"", "mood:happy"}}}},
+	}
+	for _, tt := range tests {
+		if got := parseSearchQuery(tt.query); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSearchQuery(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	d := newTestDB(t)
+
+	track := func(id, title, artist, album string, year int) media.Track {
+		return media.Track{ID: id, Title: title, Artist: artist, Album: album, Year: year}
+	}
+	saveTestTracks(t, d,
+		track("trk-1", "Karma Police", "Radiohead", "OK Computer", 1997),
+		track("trk-2", "Creep", "Radiohead", "Pablo Honey", 1993),
+		track("trk-3", "Radio Ga Ga", "Queen", "The Works", 1984),
+	)
+	// Replacing a track must not leave its old words in the index
+	saveTestTracks(t, d, track("trk-3", "Bohemian Rhapsody", "Queen", "A Night at the Opera", 1975))
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"radio", []string{"trk-1", "trk-2"}},
+		{"artist:radiohead year:1997", []string{"trk-1"}},
+		{"karma pol", []string{"trk-1"}},
+		{"year:1970-1980", []string{"trk-3"}},
+		{"ga ga", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		tracks, err := d.Search(tt.query, 10)
+		if err != nil {
+			t.Fatalf("Search(%q): %v", tt.query, err)
+		}
+		ids := trackIDs(tracks)
+		if len(ids) != len(tt.want) || !sameIDs(ids, tt.want) {
+			t.Errorf("Search(%q) = %v, want %v", tt.query, ids, tt.want)
+		}
+	}
+}
+
+func TestSearchIndexRebuilt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pulsar.db")
+	d, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.fts5 {
+		d.Close()
+		t.Skip("SQLite was built without FTS5, run the tests with -tags sqlite_fts5")
+	}
+
+	// A build without FTS5 drops the triggers, leaving the index stale
+	for _, trigger := range []string{"insert", "delete", "update"} {
+		if _, err := d.db.Exec(`DROP TRIGGER tracks_fts_` + trigger); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saveTestTracks(t, d, media.Track{ID: "trk-1", Title: "Karma Police"})
+	d.Close()
+
+	d, err = New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	tracks, err := d.Search("karma", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := trackIDs(tracks); !reflect.DeepEqual(ids, []string{"trk-1"}) {
+		t.Errorf("Search(%q) = %v, want [trk-1]", "karma", ids)
+	}
+}
+
+// sameIDs reports whether two lists hold the same IDs in any order
+func sameIDs(got, want []string) bool {
+	seen := make(map[string]bool, len(got))
+	for _, id := range got {
+		seen[id] = true
+	}
+	for _, id := range want {
+		if !seen[id] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,171 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+)
+
+// ruleColumns maps the fields of smart playlist rules to track columns
+var ruleColumns = map[media.RuleField]string{
+	media.FieldTitle:       "t.title",
+	media.FieldArtist:      "t.artist",
+	media.FieldAlbum:       "t.album",
+	media.FieldAlbumArtist: "t.album_artist",
+	media.FieldGenre:       "t.genre",
+	media.FieldComposer:    "t.composer",
+	media.FieldPath:        "t.path",
+	media.FieldYear:        "t.year",
+	media.FieldDuration:    "t.duration",
+	media.FieldAdded:       "t.added_at",
+	media.FieldModified:    "t.mtime",
+	media.FieldPlays:       playCountColumn,
+	media.FieldSkips:       skipCountColumn,
+	// Never played tracks sort first and match no recent period
+	media.FieldLastPlayed: "COALESCE(" + lastPlayedColumn + ", 0)",
+}
+
+// compileRules returns the query selecting the tracks of smart playlist
+// rules, evaluated at now, and its arguments. Values are always passed as
+// arguments, only column names and operators are written into the query.
+func compileRules(rules media.SmartRules, now time.Time) (string, []any, error) {
+	if err := rules.Validate(); err != nil {
+		return "", nil, err
+	}
+	where, args, err := compileGroup(rules.Match, now)
+	if err != nil {
+		return "", nil, err
+	}
+
+	order := albumOrder
+	switch rules.Sort {
+	case "":
+	case media.FieldRandom:
+		order = "RANDOM()"
+	default:
+		direction := "ASC"
+		if rules.Descending {
+			direction = "DESC"
+		}
+		column := ruleColumns[rules.Sort]
+		if kind, _ := rules.Sort.Kind(); kind == media.TextField {
+			column += " COLLATE NOCASE"
+		}
+		order = column + " " + direction + ", " + albumOrder
+	}
+
+	query := `
+		SELECT ` + trackColumns + `
+		FROM tracks t
+		WHERE ` + where + `
+		ORDER BY ` + order
+	if rules.Limit > 0 {
+		query += `
+		LIMIT ?`
+		args = append(args, rules.Limit)
+	}
+	return query, args, nil
+}
+
+// compileGroup returns the condition matching a group of rules
+func compileGroup(group media.RuleGroup, now time.Time) (string, []any, error) {
+	if len(group.Rules) == 0 {
+		return "1", nil, nil
+	}
+	conditions := make([]string, len(group.Rules))
+	var args []any
+	for i, rule := range group.Rules {
+		condition, ruleArgs, err := compileRule(rule, now)
+		if err != nil {
+			return "", nil, err
+		}
+		conditions[i] = condition
+		args = append(args, ruleArgs...)
+	}
+	join := " AND "
+	if group.Any {
+		join = " OR "
+	}
+	return "(" + strings.Join(conditions, join) + ")", args, nil
+}
+
+// compileRule returns the condition matching a rule
+func compileRule(rule media.Rule, now time.Time) (string, []any, error) {
+	if rule.Group != nil {
+		return compileGroup(*rule.Group, now)
+	}
+	column, ok := ruleColumns[rule.Field]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown field: %q", rule.Field)
+	}
+	kind, _ := rule.Field.Kind()
+	switch kind {
+	case media.NumberField:
+		value, err := rule.Number()
+		if err != nil {
+			return "", nil, err
+		}
+		if rule.Field == media.FieldDuration {
+			value *= 1000 // Stored in milliseconds
+		}
+		condition := fmt.Sprintf("%s %s ?", column, rule.Operator)
+		if rule.Field == media.FieldYear && rule.Operator != media.OpNotEqual {
+			// An unknown year is stored as 0, like NULL text it matches no
+			// value
+			condition = "(" + column + " != 0 AND " + condition + ")"
+		}
+		return condition, []any{value}, nil
+	case media.DateField:
+		return compileDateRule(rule, column, now)
+	}
+	return compileTextRule(rule, column)
+}
+
+// compileTextRule matches text ignoring case, NULL matching no value
+func compileTextRule(rule media.Rule, column string) (string, []any, error) {
+	escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	value := escaper.Replace(rule.Value)
+	like := column + ` LIKE ? ESCAPE '\'`
+	switch rule.Operator {
+	case media.OpEqual:
+		return column + " = ? COLLATE NOCASE", []any{rule.Value}, nil
+	case media.OpNotEqual:
+		return "COALESCE(" + column + ", '') != ? COLLATE NOCASE", []any{rule.Value}, nil
+	case media.OpContains:
+		return like, []any{"%" + value + "%"}, nil
+	case media.OpNotContains:
+		return "COALESCE(" + column + `, '') NOT LIKE ? ESCAPE '\'`, []any{"%" + value + "%"}, nil
+	case media.OpStartsWith:
+		return like, []any{value + "%"}, nil
+	case media.OpEndsWith:
+		return like, []any{"%" + value}, nil
+	}
+	return "", nil, fmt.Errorf("%s does not support %q", rule.Field, rule.Operator)
+}
+
+// compileDateRule compares the times stored in nanoseconds, a date matching
+// the whole day
+func compileDateRule(rule media.Rule, column string, now time.Time) (string, []any, error) {
+	start, err := rule.Time(now)
+	if err != nil {
+		return "", nil, err
+	}
+	dayEnd := start.AddDate(0, 0, 1).UnixNano()
+	switch rule.Operator {
+	case media.OpWithin, media.OpGreaterEqual:
+		return column + " >= ?", []any{start.UnixNano()}, nil
+	case media.OpNotWithin, media.OpLess:
+		return column + " < ?", []any{start.UnixNano()}, nil
+	case media.OpLessEqual:
+		return column + " < ?", []any{dayEnd}, nil
+	case media.OpGreater:
+		return column + " >= ?", []any{dayEnd}, nil
+	case media.OpEqual:
+		return "(" + column + " >= ? AND " + column + " < ?)", []any{start.UnixNano(), dayEnd}, nil
+	case media.OpNotEqual:
+		return "(" + column + " < ? OR " + column + " >= ?)", []any{start.UnixNano(), dayEnd}, nil
+	}
+	return "", nil, fmt.Errorf("%s does not support %q", rule.Field, rule.Operator)
+}
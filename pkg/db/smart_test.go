@@ -0,0 +1,75 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+)
+
+func TestSmartPlaylists(t *testing.T) {
+	d := newTestDB(t)
+
+	now := time.Now()
+	track := func(id, genre string, year int, added time.Time) media.Track {
+		return media.Track{ID: id, Artist: "Artist " + id, Genre: genre, Year: year, AddedAt: added}
+	}
+	saveTestTracks(t, d,
+		track("trk-1", "Jazz", 1959, now.AddDate(-1, 0, 0)),
+		track("trk-2", "jazz", 1975, now.AddDate(0, 0, -3)),
+		track("trk-3", "Rock", 1969, now.AddDate(0, 0, -10)),
+		track("trk-4", "Acid Jazz", 1994, now.AddDate(0, -2, 0)),
+		// Without a year
+		track("trk-5", "Jazz", 0, now.AddDate(-2, 0, 0)),
+	)
+	// Saving a track again keeps the time it was added
+	saveTestTracks(t, d, track("trk-1", "Jazz", 1959, time.Time{}))
+
+	tests := []struct {
+		rules string
+		want  []string
+	}{
+		{"genre = jazz and year < 1970", []string{"trk-1"}},
+		{"genre = jazz and year != 1959", []string{"trk-2", "trk-5"}},
+		{"added within 30d sort added desc", []string{"trk-2", "trk-3"}},
+		{"genre contains jazz sort year desc limit 2", []string{"trk-4", "trk-2"}},
+		{"(genre = rock or year >= 1990) and added !within 1w sort year", []string{"trk-3", "trk-4"}},
+		{`title = "trk_1" or genre !contains jazz`, []string{"trk-3"}},
+		{"duration = 1m0s and genre starts acid", []string{"trk-4"}},
+		{"sort title desc", []string{"trk-5", "trk-4", "trk-3", "trk-2", "trk-1"}},
+	}
+	for _, tt := range tests {
+		rules, err := media.ParseSmartRules(tt.rules)
+		if err != nil {
+			t.Fatalf("ParseSmartRules(%q): %v", tt.rules, err)
+		}
+		playlist, err := d.CreateSmartPlaylist(tt.rules, rules)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tracks, err := d.GetPlaylistTracks(playlist.ID)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.rules, err)
+		}
+		if ids := trackIDs(tracks); !reflect.DeepEqual(ids, tt.want) {
+			t.Errorf("%q = %v, want %v", tt.rules, ids, tt.want)
+		}
+	}
+
+	playlists, err := d.GetPlaylists()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, playlist := range playlists {
+		if playlist.Name == "genre contains jazz sort year desc limit 2" &&
+			(playlist.Tracks != 2 || playlist.Duration != 2*time.Minute || !playlist.Smart()) {
+			t.Errorf("playlist = %+v, want 2 smart tracks of 2m", playlist)
+		}
+	}
+
+	// The tracks of smart playlists are not edited by hand
+	if err := d.AddPlaylistTracks(playlists[0].ID, []string{"trk-1"}); err == nil {
+		t.Error("AddPlaylistTracks to a smart playlist succeeded")
+	}
+}
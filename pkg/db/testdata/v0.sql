@@ -0,0 +1,44 @@
+-- Database as created by the first release, before schema versioning
+CREATE TABLE settings (
+	key TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+
+CREATE TABLE sources (
+	id TEXT PRIMARY KEY,
+	type TEXT NOT NULL,
+	name TEXT NOT NULL,
+	config TEXT NOT NULL,
+	last_scanned DATETIME
+);
+
+CREATE TABLE tracks (
+	id TEXT PRIMARY KEY,
+	source_id TEXT NOT NULL,
+	source_type TEXT NOT NULL,
+	path TEXT NOT NULL,
+	title TEXT NOT NULL,
+	artist TEXT,
+	album TEXT,
+	duration INTEGER,
+	last_scanned DATETIME,
+	FOREIGN KEY(source_id) REFERENCES sources(id)
+);
+
+CREATE INDEX idx_tracks_source ON tracks(source_id);
+CREATE INDEX idx_tracks_artist ON tracks(artist);
+CREATE INDEX idx_tracks_album ON tracks(album);
+
+INSERT INTO settings (key, value) VALUES ('volume', '0.8');
+
+INSERT INTO sources (id, type, name, config, last_scanned)
+VALUES ('src-1', 'filesystem', 'Music', '{"paths":"/music"}', '2024-01-01 10:00:00');
+
+INSERT INTO tracks (
+	id, source_id, source_type, path, title, artist, album, duration,
+	last_scanned
+) VALUES
+	-- Scanned twice under different IDs, as rescans used to do
+	('trk-0', 'src-1', 'filesystem', '/music/a.mp3', 'Song A', 'Artist', 'Album', 180000, '2023-12-01 10:00:00'),
+	('trk-1', 'src-1', 'filesystem', '/music/a.mp3', 'Song A', 'Artist', 'Album', 180000, '2024-01-01 10:00:00'),
+	('trk-2', 'src-1', 'filesystem', '/music/b.mp3', 'Song B', 'Artist', 'Album', 240000, '2024-01-01 10:00:00');
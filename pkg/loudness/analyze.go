@@ -0,0 +1,50 @@
+package loudness
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+	"github.com/llehouerou/pulsar/pkg/player"
+)
+
+// analyzeChunk is the number of samples decoded between cancellation checks
+const analyzeChunk = 8192
+
+// Analyze decodes an audio file and measures its integrated loudness and true
+// peak. It matches the media.LoudnessAnalyzer signature.
+func Analyze(ctx context.Context, path string) (media.Loudness, error) {
+	streamer, format, err := player.Decode(path)
+	if err != nil {
+		return media.Loudness{}, err
+	}
+	defer streamer.Close()
+
+	// Decoders duplicate mono audio on both sides, measure it only once
+	channels := min(max(format.NumChannels, 1), 2)
+	meter := NewMeter(float64(format.SampleRate), channels)
+
+	buf := make([][2]float64, analyzeChunk)
+	for {
+		select {
+		case <-ctx.Done():
+			return media.Loudness{}, ctx.Err()
+		default:
+		}
+
+		n, ok := streamer.Stream(buf)
+		meter.Add(buf[:n])
+		if !ok {
+			break
+		}
+	}
+	if err := streamer.Err(); err != nil {
+		return media.Loudness{}, fmt.Errorf("failed to decode: %w", err)
+	}
+
+	return media.Loudness{
+		Integrated: meter.Integrated(),
+		TruePeak:   meter.TruePeak(),
+		Analyzed:   true,
+	}, nil
+}
@@ -0,0 +1,219 @@
+package loudness
+
+import (
+	"math"
+)
+
+const (
+	// stepsPerBlock makes 400ms gating blocks out of 100ms steps, so that
+	// consecutive blocks overlap by 75%
+	stepsPerBlock = 4
+	// absoluteGate is the absolute gating threshold in LUFS
+	absoluteGate = -70.0
+	// relativeGate is the relative gating threshold in LU
+	relativeGate = -10.0
+	// oversampling is the true peak oversampling factor
+	oversampling = 4
+	// tapsPerPhase is the length of each polyphase interpolation filter
+	tapsPerPhase = 12
+)
+
+// biquad is a second order IIR filter in direct form I
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+
+// kWeighting returns the two filter stages of the BS.1770 K-weighting curve,
+// computed for any sample rate. The analog parameters are those that
+// reproduce the 48 kHz coefficients published in the recommendation.
+func kWeighting(sampleRate float64) (shelf, highPass biquad) {
+	// High shelf modelling the acoustic effect of the head
+	f0 := 1681.974450955533
+	gain := 3.999843853973347
+	q := 0.7071752369554196
+	k := math.Tan(math.Pi * f0 / sampleRate)
+	vh := math.Pow(10, gain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf = biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	// RLB high-pass filter
+	f0 = 38.13547087602444
+	q = 0.5003270373238773
+	k = math.Tan(math.Pi * f0 / sampleRate)
+	a0 = 1 + k/q + k*k
+	highPass = biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+	return shelf, highPass
+}
+
+// Meter measures integrated loudness and true peak as defined by EBU R128
+// and ITU-R BS.1770
+type Meter struct {
+	channels int
+	filters  [][2]biquad
+
+	stepSize  int
+	stepCount int
+	stepSum   float64
+	steps     []float64
+	blocks    []float64
+
+	interpolator [][]float64
+	history      [][]float64
+	peak         float64
+}
+
+// NewMeter creates a meter for audio with the given sample rate and number of
+// channels (1 or 2)
+func NewMeter(sampleRate float64, channels int) *Meter {
+	m := &Meter{
+		channels: channels,
+		filters:  make([][2]biquad, channels),
+		stepSize: int(math.Round(sampleRate / 10)),
+		history:  make([][]float64, channels),
+	}
+	for c := range m.filters {
+		shelf, highPass := kWeighting(sampleRate)
+		m.filters[c] = [2]biquad{shelf, highPass}
+		m.history[c] = make([]float64, tapsPerPhase)
+	}
+	m.interpolator = interpolationFilter()
+	return m
+}
+
+// interpolationFilter builds the polyphase windowed-sinc low-pass used to
+// oversample the signal when looking for inter-sample peaks
+func interpolationFilter() [][]float64 {
+	length := oversampling * tapsPerPhase
+	phases := make([][]float64, oversampling)
+	for p := range phases {
+		phases[p] = make([]float64, tapsPerPhase)
+	}
+	center := float64(length-1) / 2
+	for i := 0; i < length; i++ {
+		x := (float64(i) - center) / oversampling
+		sinc := 1.0
+		if x != 0 {
+			sinc = math.Sin(math.Pi*x) / (math.Pi * x)
+		}
+		window := 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(length-1))
+		phases[i%oversampling][i/oversampling] = sinc * window
+	}
+	return phases
+}
+
+// Add feeds stereo samples to the meter. With a single channel only the left
+// side is measured.
+func (m *Meter) Add(samples [][2]float64) {
+	for _, frame := range samples {
+		var sum float64
+		for c := 0; c < m.channels; c++ {
+			x := frame[c]
+			m.truePeak(c, x)
+			y := m.filters[c][1].process(m.filters[c][0].process(x))
+			sum += y * y
+		}
+
+		m.stepSum += sum
+		m.stepCount++
+		if m.stepCount == m.stepSize {
+			m.endStep()
+		}
+	}
+}
+
+// truePeak updates the peak with the oversampled signal of a channel
+func (m *Meter) truePeak(c int, x float64) {
+	history := m.history[c]
+	copy(history[1:], history[:len(history)-1])
+	history[0] = x
+
+	for _, phase := range m.interpolator {
+		var y float64
+		for j, h := range phase {
+			y += h * history[j]
+		}
+		m.peak = max(m.peak, math.Abs(y))
+	}
+	m.peak = max(m.peak, math.Abs(x))
+}
+
+// endStep closes a 100ms step and the 400ms block ending with it
+func (m *Meter) endStep() {
+	m.steps = append(m.steps, m.stepSum/float64(m.stepSize))
+	m.stepSum = 0
+	m.stepCount = 0
+
+	if len(m.steps) < stepsPerBlock {
+		return
+	}
+	var block float64
+	for _, s := range m.steps[len(m.steps)-stepsPerBlock:] {
+		block += s
+	}
+	m.blocks = append(m.blocks, block/stepsPerBlock)
+	m.steps = m.steps[len(m.steps)-stepsPerBlock+1:]
+}
+
+// Integrated returns the gated integrated loudness in LUFS, or -70 for
+// silence and audio shorter than one block
+func (m *Meter) Integrated() float64 {
+	gated := func(threshold float64) (float64, int) {
+		var sum float64
+		var count int
+		for _, block := range m.blocks {
+			if blockLoudness(block) > threshold {
+				sum += block
+				count++
+			}
+		}
+		return sum, count
+	}
+
+	sum, count := gated(absoluteGate)
+	if count == 0 {
+		return absoluteGate
+	}
+	threshold := blockLoudness(sum/float64(count)) + relativeGate
+
+	sum, count = gated(threshold)
+	if count == 0 {
+		return absoluteGate
+	}
+	return blockLoudness(sum / float64(count))
+}
+
+// TruePeak returns the true peak as a linear amplitude
+func (m *Meter) TruePeak() float64 {
+	return m.peak
+}
+
+// blockLoudness converts the summed channel power of a block to LUFS. The
+// -0.691 offset cancels the K-weighting gain at 997 Hz, so that a full scale
+// sine on both channels reads 0 LUFS.
+func blockLoudness(meanSquare float64) float64 {
+	if meanSquare <= 0 {
+		return math.Inf(-1)
+	}
+	return -0.691 + 10*math.Log10(meanSquare)
+}
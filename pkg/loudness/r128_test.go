@@ -0,0 +1,83 @@
+package loudness
+
+import (
+	"math"
+	"testing"
+)
+
+// segment is a sine played for a while at a level in dBFS
+type segment struct {
+	level   float64
+	seconds float64
+}
+
+// sine returns the samples of a sine wave going through segments of
+// different levels, on both channels
+func sine(sampleRate, frequency, phase float64, segments ...segment) [][2]float64 {
+	var samples [][2]float64
+	for _, s := range segments {
+		amplitude := math.Pow(10, s.level/20)
+		for i := 0; i < int(s.seconds*sampleRate); i++ {
+			t := float64(len(samples)) / sampleRate
+			x := amplitude * math.Sin(2*math.Pi*frequency*t+phase)
+			samples = append(samples, [2]float64{x, x})
+		}
+	}
+	return samples
+}
+
+func TestIntegrated(t *testing.T) {
+	// Cases from EBU Tech 3341, the reference signals of EBU R128 meters
+	tests := []struct {
+		name       string
+		sampleRate float64
+		channels   int
+		segments   []segment
+		want       float64
+	}{
+		{"stereo -23 dBFS", 48000, 2, []segment{{-23, 20}}, -23},
+		{"stereo -33 dBFS", 48000, 2, []segment{{-33, 20}}, -33},
+		{"stereo at 44.1 kHz", 44100, 2, []segment{{-23, 20}}, -23},
+		// A single channel carries half the power of two
+		{"mono -20 dBFS", 48000, 1, []segment{{-20, 20}}, -23.01},
+		// The quiet parts fall under the relative gate
+		{"relative gate", 48000, 2, []segment{{-36, 10}, {-23, 60}, {-36, 10}}, -23},
+		// Silence falls under the absolute gate
+		{"absolute gate", 48000, 2, []segment{{-72, 10}, {-26, 20}, {-72, 10}}, -26},
+		{"silence", 48000, 2, []segment{{-200, 5}}, -70},
+		{"shorter than a block", 48000, 2, []segment{{-23, 0.3}}, -70},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meter := NewMeter(tt.sampleRate, tt.channels)
+			meter.Add(sine(tt.sampleRate, 997, 0, tt.segments...))
+			if got := meter.Integrated(); math.Abs(got-tt.want) > 0.1 {
+				t.Errorf("integrated = %.2f LUFS, want %.2f", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruePeak(t *testing.T) {
+	const sampleRate = 48000
+	tests := []struct {
+		name      string
+		frequency float64
+		phase     float64
+	}{
+		{"low frequency", 997, 0},
+		// Every sample misses the crests, which sit between samples at
+		// 0.707 of the peak
+		{"between samples", sampleRate / 4, math.Pi / 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meter := NewMeter(sampleRate, 2)
+			meter.Add(sine(sampleRate, tt.frequency, tt.phase, segment{-6, 1}))
+			want := math.Pow(10, -6.0/20)
+			if got := meter.TruePeak(); math.Abs(20*math.Log10(got/want)) > 0.5 {
+				t.Errorf("true peak = %.3f, want %.3f", got, want)
+			}
+		})
+	}
+}
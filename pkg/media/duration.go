@@ -0,0 +1,293 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jfreymuth/oggvorbis"
+	"github.com/mewkiz/flac"
+)
+
+// errNoDuration is returned when a file holds no usable length information
+var errNoDuration = errors.New("duration not found")
+
+// ReadDuration returns the playing time of an audio file from its headers,
+// without decoding the audio
+func ReadDuration(path string) (time.Duration, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".flac":
+		return readFLACDuration(path)
+	case ".ogg", ".oga":
+		return readOggDuration(path)
+	case ".wav":
+		return readWAVDuration(path)
+	default:
+		return readMP3Duration(path)
+	}
+}
+
+// samplesDuration converts a number of samples at a sample rate to a duration
+func samplesDuration(samples int64, sampleRate int) time.Duration {
+	if sampleRate <= 0 {
+		return 0
+	}
+	return time.Duration(samples) * time.Second / time.Duration(sampleRate)
+}
+
+func readFLACDuration(path string) (time.Duration, error) {
+	stream, err := flac.ParseFile(path)
+	if err != nil {
+		return 0, err
+	}
+	defer stream.Close()
+
+	if stream.Info.NSamples == 0 {
+		return 0, errNoDuration
+	}
+	return samplesDuration(int64(stream.Info.NSamples), int(stream.Info.SampleRate)), nil
+}
+
+func readOggDuration(path string) (time.Duration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	length, format, err := oggvorbis.GetLength(f)
+	if err != nil {
+		return 0, err
+	}
+	return samplesDuration(length, format.SampleRate), nil
+}
+
+// readWAVDuration walks the RIFF chunks to find the byte rate and the size of
+// the audio data
+func readWAVDuration(path string) (time.Duration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var riff [12]byte
+	if _, err := io.ReadFull(f, riff[:]); err != nil {
+		return 0, err
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return 0, errors.New("not a WAVE file")
+	}
+
+	var byteRate uint32
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(f, header[:]); err != nil {
+			return 0, errNoDuration
+		}
+		id := string(header[0:4])
+		size := int64(binary.LittleEndian.Uint32(header[4:8]))
+
+		switch id {
+		case "fmt ":
+			var format [16]byte
+			if size < int64(len(format)) {
+				return 0, errors.New("invalid fmt chunk")
+			}
+			if _, err := io.ReadFull(f, format[:]); err != nil {
+				return 0, err
+			}
+			byteRate = binary.LittleEndian.Uint32(format[8:12])
+			size -= int64(len(format))
+		case "data":
+			if byteRate == 0 {
+				return 0, errNoDuration
+			}
+			return time.Duration(size) * time.Second / time.Duration(byteRate), nil
+		}
+
+		// Chunks are padded to an even size
+		if _, err := f.Seek(size+size%2, io.SeekCurrent); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// mp3ProbeSize is how far past the ID3 tag the first MPEG frame is searched
+const mp3ProbeSize = 64 * 1024
+
+var (
+	mp3SampleRates = [4][3]int{
+		{11025, 12000, 8000},  // MPEG 2.5
+		{},                    // reserved
+		{22050, 24000, 16000}, // MPEG 2
+		{44100, 48000, 32000}, // MPEG 1
+	}
+	// mp3Bitrates are in kbit/s, indexed by [MPEG 1][layer I, II, III]
+	mp3Bitrates = [2][3][16]int{
+		{
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		},
+		{
+			{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+			{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+		},
+	}
+)
+
+// mp3Frame is a parsed MPEG audio frame header
+type mp3Frame struct {
+	mpeg1           bool
+	layer           int // 1, 2 or 3
+	mono            bool
+	bitrate         int // kbit/s
+	sampleRate      int
+	samplesPerFrame int
+}
+
+// parseMP3Frame parses the 4 byte frame header at the start of b
+func parseMP3Frame(b []byte) (mp3Frame, bool) {
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return mp3Frame{}, false
+	}
+	version := int(b[1]>>3) & 3
+	layerBits := int(b[1]>>1) & 3
+	bitrateIndex := int(b[2] >> 4)
+	rateIndex := int(b[2]>>2) & 3
+	if version == 1 || layerBits == 0 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return mp3Frame{}, false
+	}
+
+	frame := mp3Frame{
+		mpeg1:      version == 3,
+		layer:      4 - layerBits,
+		mono:       b[3]>>6 == 3,
+		sampleRate: mp3SampleRates[version][rateIndex],
+	}
+	mpeg := 0
+	if frame.mpeg1 {
+		mpeg = 1
+	}
+	frame.bitrate = mp3Bitrates[mpeg][frame.layer-1][bitrateIndex]
+
+	switch {
+	case frame.layer == 1:
+		frame.samplesPerFrame = 384
+	case frame.layer == 3 && !frame.mpeg1:
+		frame.samplesPerFrame = 576
+	default:
+		frame.samplesPerFrame = 1152
+	}
+	return frame, true
+}
+
+// xingOffset returns where the Xing/Info header sits in a Layer III frame
+func (f mp3Frame) xingOffset() int {
+	switch {
+	case f.mpeg1 && !f.mono:
+		return 4 + 32
+	case f.mpeg1, !f.mono:
+		return 4 + 17
+	default:
+		return 4 + 9
+	}
+}
+
+// frameCount reads the number of frames from a Xing/Info or VBRI header in
+// the first frame, as written by VBR encoders
+func (f mp3Frame) frameCount(b []byte) (int64, bool) {
+	if f.layer == 3 {
+		if off := f.xingOffset(); len(b) >= off+12 {
+			tag := string(b[off : off+4])
+			flags := binary.BigEndian.Uint32(b[off+4 : off+8])
+			if (tag == "Xing" || tag == "Info") && flags&1 != 0 {
+				return int64(binary.BigEndian.Uint32(b[off+8 : off+12])), true
+			}
+		}
+	}
+	// VBRI always follows the 32 bytes of side information
+	if off := 4 + 32; len(b) >= off+18 && string(b[off:off+4]) == "VBRI" {
+		return int64(binary.BigEndian.Uint32(b[off+14 : off+18])), true
+	}
+	return 0, false
+}
+
+// readMP3Duration uses the frame count of VBR headers, or estimates the
+// duration from the bitrate of the first frame for constant bitrate files
+func readMP3Duration(path string) (time.Duration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	start, err := id3v2Size(f)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, mp3ProbeSize)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, err
+	}
+	buf = buf[:n]
+
+	for i := 0; i+4 <= len(buf); i++ {
+		sync := bytes.IndexByte(buf[i:], 0xFF)
+		if sync < 0 {
+			break
+		}
+		i += sync
+		frame, ok := parseMP3Frame(buf[i:])
+		if !ok {
+			continue
+		}
+
+		if frames, ok := frame.frameCount(buf[i:]); ok {
+			return samplesDuration(frames*int64(frame.samplesPerFrame), frame.sampleRate), nil
+		}
+		audioBytes := info.Size() - start - int64(i)
+		return time.Duration(audioBytes*8) * time.Millisecond / time.Duration(frame.bitrate), nil
+	}
+	return 0, errNoDuration
+}
+
+// id3v2Size returns the size of the ID3v2 tag at the start of a file, zero
+// when there is none
+func id3v2Size(r io.Reader) (int64, error) {
+	var header [10]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if string(header[0:3]) != "ID3" {
+		return 0, nil
+	}
+
+	// The size is a 28 bit syncsafe integer excluding the header
+	size := int64(header[6])<<21 | int64(header[7])<<14 | int64(header[8])<<7 | int64(header[9])
+	size += int64(len(header))
+	if header[5]&0x10 != 0 {
+		// Footer present
+		size += 10
+	}
+	return size, nil
+}
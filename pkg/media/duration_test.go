@@ -0,0 +1,120 @@
+package media
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestParseMP3Frame(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		want   mp3Frame
+		ok     bool
+	}{
+		{
+			"MPEG 1 layer III",
+			[]byte{0xFF, 0xFB, 0x90, 0x00},
+			mp3Frame{mpeg1: true, layer: 3, bitrate: 128, sampleRate: 44100, samplesPerFrame: 1152},
+			true,
+		},
+		{
+			"MPEG 1 layer III mono",
+			[]byte{0xFF, 0xFB, 0x94, 0xC0},
+			mp3Frame{mpeg1: true, layer: 3, mono: true, bitrate: 128, sampleRate: 48000, samplesPerFrame: 1152},
+			true,
+		},
+		{
+			"MPEG 1 layer II",
+			[]byte{0xFF, 0xFD, 0x98, 0x00},
+			mp3Frame{mpeg1: true, layer: 2, bitrate: 160, sampleRate: 32000, samplesPerFrame: 1152},
+			true,
+		},
+		{
+			"MPEG 1 layer I",
+			[]byte{0xFF, 0xFF, 0x90, 0x00},
+			mp3Frame{mpeg1: true, layer: 1, bitrate: 288, sampleRate: 44100, samplesPerFrame: 384},
+			true,
+		},
+		{
+			"MPEG 2 layer III",
+			[]byte{0xFF, 0xF3, 0x80, 0x00},
+			mp3Frame{layer: 3, bitrate: 64, sampleRate: 22050, samplesPerFrame: 576},
+			true,
+		},
+		{
+			"MPEG 2.5 layer III",
+			[]byte{0xFF, 0xE3, 0x88, 0x00},
+			mp3Frame{layer: 3, bitrate: 64, sampleRate: 8000, samplesPerFrame: 576},
+			true,
+		},
+		{"no sync", []byte{0x49, 0x44, 0x33, 0x04}, mp3Frame{}, false},
+		{"short", []byte{0xFF, 0xFB, 0x90}, mp3Frame{}, false},
+		{"reserved version", []byte{0xFF, 0xEB, 0x90, 0x00}, mp3Frame{}, false},
+		{"reserved layer", []byte{0xFF, 0xF9, 0x90, 0x00}, mp3Frame{}, false},
+		{"free bitrate", []byte{0xFF, 0xFB, 0x00, 0x00}, mp3Frame{}, false},
+		{"bad bitrate", []byte{0xFF, 0xFB, 0xF0, 0x00}, mp3Frame{}, false},
+		{"reserved sample rate", []byte{0xFF, 0xFB, 0x9C, 0x00}, mp3Frame{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseMP3Frame(tt.header)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("parseMP3Frame(% X) = %+v, %v, want %+v, %v", tt.header, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+// vbrFrame returns a frame starting with header, with a VBR header tag at
+// offset holding the frame count
+func vbrFrame(header []byte, offset int, tag string, flags uint32, frames uint32) []byte {
+	b := make([]byte, 256)
+	copy(b, header)
+	copy(b[offset:], tag)
+	switch tag {
+	case "VBRI":
+		binary.BigEndian.PutUint32(b[offset+14:], frames)
+	default:
+		binary.BigEndian.PutUint32(b[offset+4:], flags)
+		binary.BigEndian.PutUint32(b[offset+8:], frames)
+	}
+	return b
+}
+
+func TestFrameCount(t *testing.T) {
+	stereo := []byte{0xFF, 0xFB, 0x90, 0x00}
+	mono := []byte{0xFF, 0xFB, 0x90, 0xC0}
+	mpeg2 := []byte{0xFF, 0xF3, 0x80, 0x00}
+	mpeg2Mono := []byte{0xFF, 0xF3, 0x80, 0xC0}
+	layer2 := []byte{0xFF, 0xFD, 0x90, 0x00}
+	tests := []struct {
+		name  string
+		frame []byte
+		want  int64
+		ok    bool
+	}{
+		{"Xing stereo", vbrFrame(stereo, 36, "Xing", 1, 9000), 9000, true},
+		{"Info mono", vbrFrame(mono, 21, "Info", 0xF, 1234), 1234, true},
+		{"Xing MPEG 2", vbrFrame(mpeg2, 21, "Xing", 1, 500), 500, true},
+		{"Xing MPEG 2 mono", vbrFrame(mpeg2Mono, 13, "Xing", 1, 42), 42, true},
+		{"Xing without frame count", vbrFrame(stereo, 36, "Xing", 0xE, 9000), 0, false},
+		{"Xing at the wrong offset", vbrFrame(mono, 36, "Xing", 1, 9000), 0, false},
+		{"Xing in layer II", vbrFrame(layer2, 36, "Xing", 1, 9000), 0, false},
+		{"VBRI", vbrFrame(stereo, 36, "VBRI", 0, 7777), 7777, true},
+		{"no header", vbrFrame(stereo, 36, "", 0, 0), 0, false},
+		{"truncated", stereo, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, ok := parseMP3Frame(tt.frame)
+			if !ok {
+				t.Fatalf("parseMP3Frame(% X) failed", tt.frame[:4])
+			}
+			got, ok := frame.frameCount(tt.frame)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("frameCount = %d, %v, want %d, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
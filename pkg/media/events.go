@@ -0,0 +1,101 @@
+package media
+
+import "sync"
+
+// subscriber delivers progress events to one listener. Events for a source
+// that the listener has not received yet are replaced by newer ones, so a
+// slow listener never blocks scans and always gets the final state.
+type subscriber struct {
+	events  chan ScanProgress
+	wake    chan struct{}
+	done    chan struct{}
+	mu      sync.Mutex
+	pending map[string]ScanProgress
+	order   []string
+}
+
+// Subscribe returns a channel receiving the progress of every scan as it
+// changes, ending with an event where Done is set. The returned function
+// stops the subscription and closes the channel.
+func (m *SourceManager) Subscribe() (<-chan ScanProgress, func()) {
+	s := &subscriber{
+		events:  make(chan ScanProgress),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		pending: make(map[string]ScanProgress),
+	}
+
+	m.mu.Lock()
+	m.subscribers[s] = struct{}{}
+	m.mu.Unlock()
+
+	go s.run()
+
+	var once sync.Once
+	return s.events, func() {
+		once.Do(func() {
+			m.mu.Lock()
+			delete(m.subscribers, s)
+			m.mu.Unlock()
+			close(s.done)
+		})
+	}
+}
+
+// publish queues an event for every subscriber, the caller holds m.mu
+func (m *SourceManager) publish(progress ScanProgress) {
+	for s := range m.subscribers {
+		s.push(progress)
+	}
+}
+
+func (s *subscriber) push(progress ScanProgress) {
+	s.mu.Lock()
+	if _, ok := s.pending[progress.SourceID]; !ok {
+		s.order = append(s.order, progress.SourceID)
+	}
+	s.pending[progress.SourceID] = progress
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next pops the oldest pending event
+func (s *subscriber) next() (ScanProgress, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.order) == 0 {
+		return ScanProgress{}, false
+	}
+	id := s.order[0]
+	s.order = s.order[1:]
+	progress := s.pending[id]
+	delete(s.pending, id)
+	return progress, true
+}
+
+func (s *subscriber) run() {
+	defer close(s.events)
+	for {
+		select {
+		case <-s.wake:
+		case <-s.done:
+			return
+		}
+
+		for {
+			progress, ok := s.next()
+			if !ok {
+				break
+			}
+			select {
+			case s.events <- progress:
+			case <-s.done:
+				return
+			}
+		}
+	}
+}
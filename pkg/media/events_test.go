@@ -0,0 +1,228 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scanFiles returns a scan finding a track for each of count files
+func scanFiles(count int) scanFunc {
+	return func(
+		ctx context.Context,
+		_ map[string]FileState,
+		tracks chan<- Track,
+		onFile func(path string, unchanged bool),
+		_ func(issue ScanIssue),
+	) error {
+		defer close(tracks)
+		for i := 0; i < count; i++ {
+			path := fmt.Sprintf("/music/%04d.mp3", i)
+			onFile(path, false)
+			select {
+			case tracks <- Track{ID: path, Path: path}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	}
+}
+
+// receive reads the events of a subscription until one per source is done
+func receive(t *testing.T, events <-chan ScanProgress, sources int) []ScanProgress {
+	t.Helper()
+	var received []ScanProgress
+	timeout := time.After(5 * time.Second)
+	for done := 0; done < sources; {
+		select {
+		case progress, ok := <-events:
+			if !ok {
+				t.Fatal("events closed before the scans were done")
+			}
+			received = append(received, progress)
+			if progress.Done {
+				done++
+			}
+		case <-timeout:
+			t.Fatalf("no final event after %d events", len(received))
+		}
+	}
+	return received
+}
+
+func TestSubscribeFanOut(t *testing.T) {
+	m := newTestManager(&memStore{}, map[string]scanFunc{"src": scanFiles(50)})
+
+	const listeners = 3
+	var subscriptions [listeners]<-chan ScanProgress
+	for i := range subscriptions {
+		events, unsubscribe := m.Subscribe()
+		defer unsubscribe()
+		subscriptions[i] = events
+	}
+
+	if err := m.ScanSource(context.Background(), "src"); err != nil {
+		t.Fatal(err)
+	}
+	for i, events := range subscriptions {
+		received := receive(t, events, 1)
+		current := 0
+		for _, progress := range received {
+			if progress.Current < current {
+				t.Errorf("listener %d: progress went back from %d to %d", i, current, progress.Current)
+			}
+			current = progress.Current
+		}
+		last := received[len(received)-1]
+		if last.Status != "Done" || last.Total != 50 || last.Current != 50 || last.Added != 50 {
+			t.Errorf("listener %d: final event %+v", i, last)
+		}
+	}
+}
+
+func TestSubscribeSlowListener(t *testing.T) {
+	m := newTestManager(&memStore{}, map[string]scanFunc{"src": scanFiles(2000)})
+	events, unsubscribe := m.Subscribe()
+	defer unsubscribe()
+
+	// Nobody reads the events while the scan runs
+	if err := m.ScanSource(context.Background(), "src"); err != nil {
+		t.Fatal(err)
+	}
+
+	// At most one stale event was already on its way, the rest were replaced
+	// by the final one
+	received := receive(t, events, 1)
+	if len(received) > 2 {
+		t.Errorf("received %d events, want the final one after at most one stale", len(received))
+	}
+	if last := received[len(received)-1]; last.Current != 2000 {
+		t.Errorf("final event %+v", last)
+	}
+}
+
+func TestSubscribeSources(t *testing.T) {
+	m := newTestManager(&memStore{}, map[string]scanFunc{
+		"a": scanFiles(100),
+		"b": scanFiles(200),
+		"c": scanFiles(300),
+	})
+	events, unsubscribe := m.Subscribe()
+	defer unsubscribe()
+
+	if err := m.ScanAll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	final := make(map[string]int)
+	for _, progress := range receive(t, events, 3) {
+		if progress.Done {
+			final[progress.SourceID] = progress.Current
+		}
+	}
+	want := map[string]int{"a": 100, "b": 200, "c": 300}
+	for id, count := range want {
+		if final[id] != count {
+			t.Errorf("source %s: final count %d, want %d", id, final[id], count)
+		}
+	}
+}
+
+func TestSubscribeFailedScan(t *testing.T) {
+	failure := errors.New("disk gone")
+	m := newTestManager(&memStore{}, map[string]scanFunc{
+		"failing": func(
+			_ context.Context,
+			_ map[string]FileState,
+			tracks chan<- Track,
+			_ func(path string, unchanged bool),
+			_ func(issue ScanIssue),
+		) error {
+			close(tracks)
+			return failure
+		},
+		"cancelled": scanFiles(1000),
+	})
+	events, unsubscribe := m.Subscribe()
+	defer unsubscribe()
+
+	if err := m.ScanSource(context.Background(), "failing"); !errors.Is(err, failure) {
+		t.Errorf("ScanSource = %v, want %v", err, failure)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := m.ScanSource(ctx, "cancelled"); !errors.Is(err, context.Canceled) {
+		t.Errorf("ScanSource = %v, want %v", err, context.Canceled)
+	}
+
+	status := make(map[string]string)
+	for _, progress := range receive(t, events, 2) {
+		if progress.Done {
+			if progress.Err == nil {
+				t.Errorf("source %s: final event without error", progress.SourceID)
+			}
+			status[progress.SourceID] = progress.Status
+		}
+	}
+	if status["failing"] != "Error: scan failed: disk gone" || status["cancelled"] != "Cancelled" {
+		t.Errorf("final status %v", status)
+	}
+	if m.Scanning() {
+		t.Error("failed scans still running")
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	// The scan publishes until it is cancelled
+	m := newTestManager(&memStore{}, map[string]scanFunc{"src": scanFiles(math.MaxInt)})
+	scanned := make(chan error, 1)
+	go func() {
+		scanned <- m.ScanSource(context.Background(), "src")
+	}()
+
+	// Listeners come and go while the scan publishes, some of them before
+	// reading anything
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			events, unsubscribe := m.Subscribe()
+			for j := 0; j < i%4; j++ {
+				<-events
+			}
+			unsubscribe()
+			unsubscribe()
+			// The channel is closed once the pending events are dropped
+			timeout := time.After(5 * time.Second)
+			for {
+				select {
+				case _, ok := <-events:
+					if !ok {
+						return
+					}
+				case <-timeout:
+					t.Error("events not closed after unsubscribing")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for !m.CancelScan("src") {
+		time.Sleep(time.Millisecond)
+	}
+	if err := <-scanned; !errors.Is(err, context.Canceled) {
+		t.Fatalf("ScanSource = %v, want %v", err, context.Canceled)
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.subscribers) != 0 {
+		t.Errorf("%d subscribers left", len(m.subscribers))
+	}
+}
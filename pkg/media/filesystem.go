@@ -2,27 +2,45 @@ package media
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/bogem/id3v2/v2"
+	"github.com/fsnotify/fsnotify"
 	"github.com/google/uuid"
+	"golang.org/x/sync/errgroup"
 )
 
 type FilesystemSource struct {
 	id        string
 	name      string
 	rootPaths []string
+	// extensions holds the lowercase extensions of the files indexed,
+	// including the dot
+	extensions map[string]bool
+	// workers is the number of files read in parallel, zero uses one per CPU
+	workers int
 }
 
-func NewFilesystemSource(id, name string, paths []string) *FilesystemSource {
+// NewFilesystemSource creates a source indexing the files with the given
+// extensions, the ones the player can decode
+func NewFilesystemSource(id, name string, paths, extensions []string) *FilesystemSource {
+	exts := make(map[string]bool, len(extensions))
+	for _, ext := range extensions {
+		exts[strings.ToLower(ext)] = true
+	}
 	return &FilesystemSource{
-		id:        id,
-		name:      name,
-		rootPaths: paths,
+		id:         id,
+		name:       name,
+		rootPaths:  paths,
+		extensions: exts,
 	}
 }
 
@@ -36,83 +54,382 @@ func (fs *FilesystemSource) Name() string {
 
 func (fs *FilesystemSource) Scan(
 	ctx context.Context,
+	known map[string]FileState,
 	tracks chan<- Track,
-	onFile func(path string),
+	onFile func(path string, unchanged bool),
+	onIssue func(issue ScanIssue),
 ) error {
 	defer close(tracks)
+	return fs.walk(ctx, fs.rootPaths, false, known, tracks, onFile, onIssue)
+}
+
+// ScanPaths works like Scan, limited to the given files and directories.
+// Paths that no longer exist are skipped.
+func (fs *FilesystemSource) ScanPaths(
+	ctx context.Context,
+	paths []string,
+	known map[string]FileState,
+	tracks chan<- Track,
+	onFile func(path string, unchanged bool),
+	onIssue func(issue ScanIssue),
+) error {
+	defer close(tracks)
+	return fs.walk(ctx, paths, true, known, tracks, onFile, onIssue)
+}
+
+// scanJob is a track file waiting for its tags to be read
+type scanJob struct {
+	path    string
+	info    os.FileInfo
+	state   FileState
+	indexed bool
+}
+
+// walk sends the new and changed track files found under the roots. The walk
+// only lists files, reading their tags is spread over a pool of workers.
+// Unreadable files and directories are reported and skipped.
+func (fs *FilesystemSource) walk(
+	ctx context.Context,
+	roots []string,
+	skipMissing bool,
+	known map[string]FileState,
+	tracks chan<- Track,
+	onFile func(path string, unchanged bool),
+	onIssue func(issue ScanIssue),
+) error {
+	g, ctx := errgroup.WithContext(ctx)
+	workers := fs.workerCount()
+	jobs := make(chan scanJob, workers*4)
+
+	// Callers see onFile calls one at a time
+	var reportMu sync.Mutex
+	report := func(path string, unchanged bool) {
+		if onFile == nil {
+			return
+		}
+		reportMu.Lock()
+		defer reportMu.Unlock()
+		onFile(path, unchanged)
+	}
+	reportIssue := func(issue ScanIssue) {
+		if onIssue == nil {
+			return
+		}
+		reportMu.Lock()
+		defer reportMu.Unlock()
+		onIssue(issue)
+	}
+
+	g.Go(func() error {
+		defer close(jobs)
+		for _, rootPath := range roots {
+			// A missing root, such as an unmounted drive, keeps its tracks
+			if _, err := os.Stat(rootPath); err != nil {
+				if !skipMissing || !errors.Is(err, os.ErrNotExist) {
+					reportIssue(newScanIssue(rootPath, IssueUnreadable, err))
+				}
+				continue
+			}
 
-	for _, rootPath := range fs.rootPaths {
-		err := filepath.Walk(
-			rootPath,
-			func(path string, info os.FileInfo, err error) error {
+			err := filepath.Walk(
+				rootPath,
+				func(path string, info os.FileInfo, err error) error {
+					if err != nil {
+						// Files removed during the scan are simply gone
+						if !errors.Is(err, os.ErrNotExist) {
+							reportIssue(newScanIssue(path, IssueUnreadable, err))
+						}
+						if info != nil && info.IsDir() {
+							return filepath.SkipDir
+						}
+						return nil
+					}
+					if err := ctx.Err(); err != nil {
+						return err
+					}
+					if info.IsDir() || !fs.isAudioFile(path) {
+						return nil
+					}
+
+					state, indexed := known[path]
+					if indexed && state.Unchanged(info.ModTime(), info.Size()) {
+						report(path, true)
+						return nil
+					}
+
+					select {
+					case jobs <- scanJob{path, info, state, indexed}:
+						return nil
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				},
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	for i := 0; i < workers; i++ {
+		g.Go(func() error {
+			for job := range jobs {
+				track, issues, err := fs.scanFile(job.path)
 				if err != nil {
-					return err
+					if !errors.Is(err, os.ErrNotExist) {
+						reportIssue(newScanIssue(job.path, IssueUnreadable, err))
+					}
+					continue
+				}
+				for _, issue := range issues {
+					reportIssue(issue)
 				}
 
+				// Changed files keep their ID so the queue keeps pointing at them
+				track.ID = uuid.NewString()
+				if job.indexed {
+					track.ID = job.state.TrackID
+				}
+				track.ModTime = job.info.ModTime()
+				track.Size = job.info.Size()
+				track.SourceID = fs.id
+				track.SourceType = fs.Type()
+				track.LastScanned = time.Now()
+
+				report(job.path, false)
 				select {
+				case tracks <- track:
 				case <-ctx.Done():
 					return ctx.Err()
-				default:
 				}
+			}
+			return nil
+		})
+	}
 
-				if !info.IsDir() && onFile != nil {
-					onFile(path)
-				}
+	return g.Wait()
+}
 
-				if info.IsDir() || !isAudioFile(path) {
-					return nil
-				}
+// workerCount returns the number of files read in parallel
+func (fs *FilesystemSource) workerCount() int {
+	if fs.workers > 0 {
+		return fs.workers
+	}
+	return runtime.NumCPU()
+}
 
-				track, err := fs.scanFile(path)
-				if err != nil {
-					return err
+// Watch reports the files and directories changed under the root paths,
+// watching every directory recursively. Bursts of events, such as an album
+// being copied, are sent as a single batch once things settle down.
+func (fs *FilesystemSource) Watch(ctx context.Context, changes chan<- []string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	for _, root := range fs.rootPaths {
+		if err := watchTree(watcher, root); err != nil {
+			return fmt.Errorf("failed to watch %s: %w", root, err)
+		}
+	}
+
+	batcher := changeBatcher{
+		roots:       fs.rootPaths,
+		debounce:    watchDebounce,
+		maxDelay:    watchMaxDelay,
+		isAudioFile: fs.isAudioFile,
+		watchDir: func(dir string) error {
+			return watchTree(watcher, dir)
+		},
+	}
+	return batcher.run(ctx, watcher.Events, watcher.Errors, changes)
+}
+
+// changeBatcher turns the events of a watcher into batches of changed paths
+type changeBatcher struct {
+	roots []string
+	// debounce is the quiet time before sending a batch, maxDelay bounds the
+	// wait during a continuous burst
+	debounce time.Duration
+	maxDelay time.Duration
+	// isAudioFile tells the files worth rescanning
+	isAudioFile func(path string) bool
+	// watchDir starts watching a new directory and its subdirectories
+	watchDir func(dir string) error
+}
+
+// run batches the events until the context is cancelled or the watcher
+// stops
+func (b changeBatcher) run(
+	ctx context.Context,
+	events <-chan fsnotify.Event,
+	errs <-chan error,
+	changes chan<- []string,
+) error {
+	pending := make(map[string]bool)
+	var first time.Time
+	var flush <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			info, statErr := os.Stat(event.Name)
+			isDir := statErr == nil && info.IsDir()
+			if isDir && event.Has(fsnotify.Create) {
+				// Directories created or moved in need watching too
+				if err := b.watchDir(event.Name); err != nil {
+					continue
 				}
+			}
+			// Removed paths may have been directories
+			if !isDir && statErr == nil && !b.isAudioFile(event.Name) {
+				continue
+			}
 
-				track.SourceID = fs.id
-				track.SourceType = fs.Type()
-				track.LastScanned = time.Now()
+			if len(pending) == 0 {
+				first = time.Now()
+			}
+			pending[event.Name] = true
+			wait := min(b.debounce, b.maxDelay-time.Since(first))
+			flush = time.After(max(0, wait))
+
+		case err, ok := <-errs:
+			if !ok {
+				return nil
+			}
+			// Events were dropped, only a full scan can catch up
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				for _, root := range b.roots {
+					pending[root] = true
+				}
+				flush = time.After(b.debounce)
+			}
 
-				tracks <- track
+		case <-flush:
+			flush = nil
+			paths := collapsePaths(pending)
+			clear(pending)
+			select {
+			case changes <- paths:
+			case <-ctx.Done():
 				return nil
-			},
-		)
+			}
+		}
+	}
+}
 
+const (
+	// watchDebounce is the quiet time after the last change before a batch
+	// of changes is sent
+	watchDebounce = 2 * time.Second
+	// watchMaxDelay bounds how long changes wait during a continuous burst
+	watchMaxDelay = 30 * time.Second
+)
+
+// watchTree adds a directory and all its subdirectories to the watcher
+func watchTree(watcher *fsnotify.Watcher, root string) error {
+	return filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
+		if d.IsDir() {
+			return watcher.Add(path)
+		}
+		return nil
+	})
+}
+
+// collapsePaths returns the paths sorted, without the ones inside another
+// path of the set
+func collapsePaths(set map[string]bool) []string {
+	paths := make([]string, 0, len(set))
+	for path := range set {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var collapsed []string
+	for _, path := range paths {
+		if !isUnderAny(path, collapsed) {
+			collapsed = append(collapsed, path)
+		}
 	}
+	return collapsed
+}
 
-	return nil
+// isUnderAny reports whether path is one of dirs or inside one of them
+func isUnderAny(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		prefix := strings.TrimSuffix(dir, string(filepath.Separator)) + string(filepath.Separator)
+		if path == dir || strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
 }
 
-func (fs *FilesystemSource) scanFile(path string) (Track, error) {
-	tag, err := id3v2.Open(path, id3v2.Options{Parse: true})
+// scanFile reads the metadata of a track file. It fails when the file cannot
+// be opened, broken tags and audio headers are returned as issues and the
+// track is indexed with what could be read.
+func (fs *FilesystemSource) scanFile(path string) (Track, []ScanIssue, error) {
+	track := Track{
+		Path: path,
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return Track{}, nil, err
+	}
+	f.Close()
+
+	var issues []ScanIssue
+	tags, err := ReadTags(path)
 	if err == nil {
-		defer tag.Close()
-		return Track{
-			ID:     uuid.NewString(),
-			Path:   path,
-			Title:  tag.Title(),
-			Artist: tag.Artist(),
-			Album:  tag.Album(),
-		}, nil
+		track.Title = tags.Title
+		track.Artist = tags.Artist
+		track.Album = tags.Album
+		track.AlbumArtist = tags.AlbumArtist
+		track.TrackNumber = tags.TrackNumber
+		track.DiscNumber = tags.DiscNumber
+		track.Year = tags.Year
+		track.Genre = tags.Genre
+		track.Composer = tags.Composer
+		track.ReplayGain = tags.ReplayGain
+	} else {
+		issues = append(issues, newScanIssue(path, IssueCorruptTags, err))
+	}
+
+	if duration, err := ReadDuration(path); err == nil {
+		track.Duration = duration
+	} else {
+		issues = append(issues, newScanIssue(path, IssueUndecodable, err))
 	}
 
 	// If we can't read tags, use filename as title
-	return Track{
-		ID:    uuid.NewString(),
-		Path:  path,
-		Title: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
-	}, nil
+	if track.Title == "" {
+		track.Title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	return track, issues, nil
 }
 
-func isAudioFile(path string) bool {
-	ext := strings.ToLower(filepath.Ext(path))
-	return ext == ".mp3"
+func (fs *FilesystemSource) isAudioFile(path string) bool {
+	return fs.extensions[strings.ToLower(filepath.Ext(path))]
 }
 
 // NewFilesystemSourceFactory creates a factory for filesystem sources
-func NewFilesystemSourceFactory() SourceFactory {
+// indexing the files with the given extensions
+func NewFilesystemSourceFactory(extensions []string) SourceFactory {
 	return func(config SourceConfig) (Source, error) {
 		paths, ok := config.Config["paths"]
 		if !ok {
@@ -121,6 +438,15 @@ func NewFilesystemSourceFactory() SourceFactory {
 
 		// Split paths by semicolon
 		pathList := strings.Split(paths, ";")
-		return NewFilesystemSource(config.ID, config.Name, pathList), nil
+		source := NewFilesystemSource(config.ID, config.Name, pathList, extensions)
+
+		if workers, ok := config.Config["workers"]; ok {
+			n, err := strconv.Atoi(workers)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid workers in config: %q", workers)
+			}
+			source.workers = n
+		}
+		return source, nil
 	}
 }
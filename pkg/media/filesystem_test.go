@@ -0,0 +1,113 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+const (
+	fixtureAlbums         = 50
+	fixtureTracksPerAlbum = 20
+	// fixtureAudioSize is the size of the fake audio data after the tags
+	fixtureAudioSize = 64 * 1024
+)
+
+// writeFixtureTree generates a library of tagged MP3 files laid out as
+// artist/album/track, and returns the number of files
+func writeFixtureTree(tb testing.TB, root string) int {
+	tb.Helper()
+
+	// A constant bitrate frame header followed by silence
+	audio := make([]byte, fixtureAudioSize)
+	copy(audio, []byte{0xFF, 0xFB, 0x90, 0x00})
+
+	count := 0
+	for a := 0; a < fixtureAlbums; a++ {
+		dir := filepath.Join(root, fmt.Sprintf("Artist %d", a%10), fmt.Sprintf("Album %d", a))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			tb.Fatal(err)
+		}
+		// Covers and playlists are walked but not read
+		if err := os.WriteFile(filepath.Join(dir, "cover.jpg"), audio[:1024], 0o644); err != nil {
+			tb.Fatal(err)
+		}
+
+		for t := 1; t <= fixtureTracksPerAlbum; t++ {
+			path := filepath.Join(dir, fmt.Sprintf("%02d.mp3", t))
+			if err := os.WriteFile(path, audio, 0o644); err != nil {
+				tb.Fatal(err)
+			}
+
+			tag, err := id3v2.Open(path, id3v2.Options{Parse: false})
+			if err != nil {
+				tb.Fatal(err)
+			}
+			tag.SetDefaultEncoding(id3v2.EncodingUTF8)
+			tag.SetTitle(fmt.Sprintf("Track %d", t))
+			tag.SetArtist(fmt.Sprintf("Artist %d", a%10))
+			tag.SetAlbum(fmt.Sprintf("Album %d", a))
+			tag.AddTextFrame("TRCK", id3v2.EncodingUTF8, fmt.Sprintf("%d/%d", t, fixtureTracksPerAlbum))
+			tag.AddUserDefinedTextFrame(id3v2.UserDefinedTextFrame{
+				Encoding:    id3v2.EncodingUTF8,
+				Description: "REPLAYGAIN_TRACK_GAIN",
+				Value:       "-6.50 dB",
+			})
+			if err := tag.Save(); err != nil {
+				tb.Fatal(err)
+			}
+			tag.Close()
+			count++
+		}
+	}
+	return count
+}
+
+// scanAll runs a full scan and returns the number of tracks received
+func scanAll(tb testing.TB, source *FilesystemSource) int {
+	tb.Helper()
+
+	tracks := make(chan Track, 100)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- source.Scan(context.Background(), nil, tracks, nil, nil)
+	}()
+
+	count := 0
+	for range tracks {
+		count++
+	}
+	if err := <-errc; err != nil {
+		tb.Fatal(err)
+	}
+	return count
+}
+
+func BenchmarkFilesystemScan(b *testing.B) {
+	root := b.TempDir()
+	files := writeFixtureTree(b, root)
+
+	counts := []int{1, 4}
+	if cpus := runtime.NumCPU(); cpus != 1 && cpus != 4 {
+		counts = append(counts, cpus)
+	}
+	for _, workers := range counts {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			source := NewFilesystemSource("bench", "bench", []string{root}, []string{".mp3"})
+			source.workers = workers
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if n := scanAll(b, source); n != files {
+					b.Fatalf("scanned %d tracks, want %d", n, files)
+				}
+			}
+			b.ReportMetric(float64(files*b.N)/b.Elapsed().Seconds(), "files/s")
+		})
+	}
+}
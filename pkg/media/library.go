@@ -0,0 +1,54 @@
+package media
+
+import "time"
+
+const (
+	// VariousArtists files compilations, albums whose tracks have several
+	// artists and no album artist
+	VariousArtists = "Various Artists"
+	// UnknownArtist files the tracks without any artist tag
+	UnknownArtist = "Unknown Artist"
+)
+
+// ArtistSummary is an artist of the library across all sources
+type ArtistSummary struct {
+	Name     string
+	Albums   int
+	Tracks   int
+	Duration time.Duration
+}
+
+// AlbumSummary is an album of the library, filed under its artist. Tracks
+// without an album tag share the album with an empty title.
+type AlbumSummary struct {
+	Artist string
+	Title  string
+	// Dir is the directory holding the tracks, above their disc folders,
+	// telling apart the albums with the same title. It is empty for the
+	// tracks without an album.
+	Dir      string
+	Year     int
+	Tracks   int
+	Duration time.Duration
+}
+
+// GetArtists returns the artists of the library across all sources
+func (m *SourceManager) GetArtists() ([]ArtistSummary, error) {
+	return m.db.GetLibraryArtists()
+}
+
+// GetAlbums returns the albums filed under an artist, by year
+func (m *SourceManager) GetAlbums(artist string) ([]AlbumSummary, error) {
+	return m.db.GetLibraryAlbums(artist)
+}
+
+// GetAlbumTracks returns the tracks of an album in play order
+func (m *SourceManager) GetAlbumTracks(album AlbumSummary) ([]Track, error) {
+	return m.db.GetLibraryTracks(album)
+}
+
+// Search returns up to limit tracks of all sources matching a query, such as
+// "karma pol" or "artist:radiohead year:1997"
+func (m *SourceManager) Search(query string, limit int) ([]Track, error) {
+	return m.db.Search(query, limit)
+}
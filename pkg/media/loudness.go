@@ -0,0 +1,106 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// analysisPause is the idle time between two analyzed tracks, keeping the
+	// background job from competing with playback for the CPU
+	analysisPause = 200 * time.Millisecond
+	// analysisScanWait is how often a paused analysis checks whether the scan
+	// it is waiting for is over
+	analysisScanWait = time.Second
+)
+
+// LoudnessAnalyzer measures the loudness of an audio file
+type LoudnessAnalyzer func(ctx context.Context, path string) (Loudness, error)
+
+// AnalysisProgress represents the loudness analysis progress
+type AnalysisProgress struct {
+	Total   int
+	Current int
+	Failed  int
+}
+
+// GetAnalysisProgress returns the loudness analysis progress, nil when no
+// analysis is running
+func (m *SourceManager) GetAnalysisProgress() *AnalysisProgress {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.analysisProgress == nil {
+		return nil
+	}
+	progress := *m.analysisProgress
+	return &progress
+}
+
+// AnalyzeLoudness measures every track whose loudness is unknown and stores
+// the result. Tracks are analyzed one at a time, pausing between tracks and
+// while a scan is running. Cancelling the context stops the job; since
+// measured tracks are saved as they go, the next run picks up the remaining
+// ones. Tracks that fail to decode are skipped until the next run.
+func (m *SourceManager) AnalyzeLoudness(ctx context.Context, analyze LoudnessAnalyzer) error {
+	tracks, err := m.db.GetUnanalyzedTracks()
+	if err != nil {
+		return fmt.Errorf("failed to get tracks: %w", err)
+	}
+
+	m.mu.Lock()
+	if m.analysisProgress != nil {
+		m.mu.Unlock()
+		return errors.New("loudness analysis already running")
+	}
+	m.analysisProgress = &AnalysisProgress{Total: len(tracks)}
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		m.analysisProgress = nil
+		m.mu.Unlock()
+	}()
+
+	for _, track := range tracks {
+		if err := m.waitForScan(ctx); err != nil {
+			return err
+		}
+
+		loudness, err := analyze(ctx, track.Path)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil {
+			err = m.db.SaveLoudness(track.ID, loudness)
+		}
+
+		m.mu.Lock()
+		m.analysisProgress.Current++
+		if err != nil {
+			m.analysisProgress.Failed++
+		}
+		m.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(analysisPause):
+		}
+	}
+	return nil
+}
+
+// waitForScan blocks while a scan is running, so the analysis never slows it
+// down or reads tracks it is about to replace
+func (m *SourceManager) waitForScan(ctx context.Context) error {
+	for m.Scanning() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(analysisScanWait):
+		}
+	}
+	return nil
+}
@@ -2,40 +2,104 @@ package media
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"maps"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// Store persists sources, tracks and their loudness measurements
+type Store interface {
+	SaveSource(source *SourceConfig) error
+	UpdateSource(source *SourceConfig) error
+	RemoveSource(sourceID string) error
+	GetSources() ([]SourceConfig, error)
+	SaveTracks(tracks []Track) error
+	GetTracks(sourceID string) ([]Track, error)
+	GetLibraryArtists() ([]ArtistSummary, error)
+	GetLibraryAlbums(artist string) ([]AlbumSummary, error)
+	GetLibraryTracks(album AlbumSummary) ([]Track, error)
+	Search(query string, limit int) ([]Track, error)
+	GetFileStates(sourceID string) (map[string]FileState, error)
+	RemoveTracks(trackIDs []string) error
+	MoveTrack(oldID, newID string) error
+	MarkSourceScanned(sourceID string, at time.Time) error
+	GetUnanalyzedTracks() ([]Track, error)
+	SaveLoudness(trackID string, loudness Loudness) error
+	GetScanReport(sourceID string) (ScanReport, error)
+	SaveScanReport(report ScanReport) error
+}
+
 // SourceManager handles media source registration and scanning
 type SourceManager struct {
-	db interface {
-		SaveSource(source *SourceConfig) error
-		GetSources() ([]SourceConfig, error)
-		SaveTrack(track *Track) error
-		GetTracks(sourceID string) ([]Track, error)
-	}
+	db              Store
 	sources         map[string]Source
 	sourceFactories map[string]SourceFactory
-	scanProgress    *ScanProgress
-	mu              sync.RWMutex
+	// scans holds the progress of the running and failed scans, lastScans
+	// the final progress of the last successful scan, by source ID
+	scans       map[string]*ScanProgress
+	scanCancels map[string]context.CancelFunc
+	lastScans   map[string]ScanProgress
+	// scanLocks keeps scans of the same source from overlapping
+	scanLocks        map[string]*sync.Mutex
+	subscribers      map[*subscriber]struct{}
+	analysisProgress *AnalysisProgress
+	batchSize        int
+	flushInterval    time.Duration
+	// watchCtx is set while WatchSources runs, watchCancels stops watching
+	// each source
+	watchCtx     context.Context
+	watchCancels map[string]context.CancelFunc
+	watchers     sync.WaitGroup
+	changes      chan string
+	mu           sync.RWMutex
 }
 
 // SourceFactory creates a Source from a SourceConfig
 type SourceFactory func(config SourceConfig) (Source, error)
 
-func NewSourceManager(db interface {
-	SaveSource(source *SourceConfig) error
-	GetSources() ([]SourceConfig, error)
-	SaveTrack(track *Track) error
-	GetTracks(sourceID string) ([]Track, error)
-}) *SourceManager {
+func NewSourceManager(db Store) *SourceManager {
 	return &SourceManager{
 		db:              db,
 		sources:         make(map[string]Source),
 		sourceFactories: make(map[string]SourceFactory),
+		scans:           make(map[string]*ScanProgress),
+		scanCancels:     make(map[string]context.CancelFunc),
+		lastScans:       make(map[string]ScanProgress),
+		scanLocks:       make(map[string]*sync.Mutex),
+		subscribers:     make(map[*subscriber]struct{}),
+		watchCancels:    make(map[string]context.CancelFunc),
+		changes:         make(chan string, 16),
+		batchSize:       DefaultBatchSize,
+		flushInterval:   DefaultFlushInterval,
+	}
+}
+
+const (
+	// DefaultBatchSize is the default number of tracks saved per transaction
+	// during a scan
+	DefaultBatchSize = 500
+	// DefaultFlushInterval is the default longest time scanned tracks wait to
+	// be saved
+	DefaultFlushInterval = 500 * time.Millisecond
+)
+
+// SetScanBatching sets how many scanned tracks are saved per transaction and
+// how long they may wait for the batch to fill up. Zero values keep the
+// defaults.
+func (m *SourceManager) SetScanBatching(size int, interval time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.batchSize = DefaultBatchSize
+	if size > 0 {
+		m.batchSize = size
+	}
+	m.flushInterval = DefaultFlushInterval
+	if interval > 0 {
+		m.flushInterval = interval
 	}
 }
 
@@ -80,12 +144,110 @@ func (m *SourceManager) AddSource(
 	}
 
 	m.sources[sourceConfig.ID] = source
+	watchCtx := m.watchCtx
 	m.mu.Unlock()
 
+	if watchCtx != nil {
+		m.watchSource(watchCtx, sourceConfig.ID)
+	}
+
 	// Perform initial scan
 	return m.ScanSource(context.Background(), sourceConfig.ID)
 }
 
+// UpdateSource renames a source and changes its configuration. When the
+// configuration changed the source is rescanned, so the tracks of the files
+// it no longer covers are removed.
+func (m *SourceManager) UpdateSource(
+	sourceID, name string,
+	config map[string]string,
+) error {
+	current, err := m.getSourceConfig(sourceID)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	factory, ok := m.sourceFactories[current.Type]
+	if !ok {
+		m.mu.Unlock()
+		return fmt.Errorf("unknown source type: %s", current.Type)
+	}
+
+	updated := current
+	updated.Name = name
+	updated.Config = config
+	source, err := factory(updated)
+	if err != nil {
+		m.mu.Unlock()
+		return fmt.Errorf("failed to create source: %w", err)
+	}
+
+	if err := m.db.UpdateSource(&updated); err != nil {
+		m.mu.Unlock()
+		return fmt.Errorf("failed to save source: %w", err)
+	}
+
+	m.sources[sourceID] = source
+	m.unwatchSource(sourceID)
+	watchCtx := m.watchCtx
+	m.mu.Unlock()
+
+	if watchCtx != nil {
+		m.watchSource(watchCtx, sourceID)
+	}
+
+	if maps.Equal(current.Config, config) {
+		return nil
+	}
+	// A scan of the old configuration would be outdated
+	m.CancelScan(sourceID)
+	return m.ScanSource(context.Background(), sourceID)
+}
+
+// RemoveSource stops scanning and watching a source, then deletes it along
+// with its tracks
+func (m *SourceManager) RemoveSource(sourceID string) error {
+	m.mu.Lock()
+	if _, ok := m.sources[sourceID]; !ok {
+		m.mu.Unlock()
+		return fmt.Errorf("source not found: %s", sourceID)
+	}
+	delete(m.sources, sourceID)
+	m.unwatchSource(sourceID)
+	m.mu.Unlock()
+
+	// Wait for the running scan to stop before deleting what it saved
+	m.CancelScan(sourceID)
+	lock := m.scanLock(sourceID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := m.db.RemoveSource(sourceID); err != nil {
+		return fmt.Errorf("failed to remove source: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.scans, sourceID)
+	delete(m.lastScans, sourceID)
+	return nil
+}
+
+// getSourceConfig returns the stored configuration of a source
+func (m *SourceManager) getSourceConfig(sourceID string) (SourceConfig, error) {
+	sources, err := m.db.GetSources()
+	if err != nil {
+		return SourceConfig{}, fmt.Errorf("failed to get sources: %w", err)
+	}
+	for _, source := range sources {
+		if source.ID == sourceID {
+			return source, nil
+		}
+	}
+	return SourceConfig{}, fmt.Errorf("source not found: %s", sourceID)
+}
+
 // LoadSources loads all sources from the database
 func (m *SourceManager) LoadSources() error {
 	m.mu.Lock()
@@ -116,19 +278,62 @@ func (m *SourceManager) LoadSources() error {
 // ScanProgress represents the scanning progress
 type ScanProgress struct {
 	SourceID string
-	Total    int
-	Current  int
-	Status   string
+	// Total counts the track files found so far, Current the ones processed
+	Total   int
+	Current int
+	// Added, Updated and Unchanged classify the processed files, Removed
+	// counts the indexed tracks whose file is gone
+	Added     int
+	Updated   int
+	Unchanged int
+	Moved     int
+	Removed   int
+	// Issues counts the files and directories that could not be read
+	// properly, listed in the scan report
+	Issues int
+	Status string
+	// Done is set once the scan is over, Err when it failed or was cancelled
+	Done bool
+	Err  error
 }
 
-// GetScanProgress returns the current scanning progress
-func (m *SourceManager) GetScanProgress() *ScanProgress {
+// GetScanProgress returns the progress of the running or failed scan of a
+// source, nil when there is none
+func (m *SourceManager) GetScanProgress(sourceID string) *ScanProgress {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	return m.scanProgress
+	progress, ok := m.scans[sourceID]
+	if !ok {
+		return nil
+	}
+	p := *progress
+	return &p
+}
+
+// Scanning reports whether any scan is running
+func (m *SourceManager) Scanning() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, progress := range m.scans {
+		if !progress.Done {
+			return true
+		}
+	}
+	return false
+}
+
+// updateProgress applies a change to the progress of a source's scan and
+// publishes it
+func (m *SourceManager) updateProgress(sourceID string, update func(p *ScanProgress)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	progress := m.scans[sourceID]
+	update(progress)
+	m.publish(*progress)
 }
 
-// ScanSource scans a single source and updates the database
+// ScanSource scans a single source and updates the database. Only new and
+// changed files are read, and tracks whose file is gone are removed.
 func (m *SourceManager) ScanSource(ctx context.Context, sourceID string) error {
 	m.mu.RLock()
 	source, ok := m.sources[sourceID]
@@ -138,69 +343,340 @@ func (m *SourceManager) ScanSource(ctx context.Context, sourceID string) error {
 		return fmt.Errorf("source not found: %s", sourceID)
 	}
 
+	_, err := m.runScan(ctx, sourceID, "Scanning...", source.Scan, nil)
+	return err
+}
+
+// ScanAll scans every source concurrently and waits for all of them
+func (m *SourceManager) ScanAll(ctx context.Context) error {
+	m.mu.RLock()
+	ids := make([]string, 0, len(m.sources))
+	for id := range m.sources {
+		ids = append(ids, id)
+	}
+	m.mu.RUnlock()
+
+	errs := make([]error, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = m.ScanSource(ctx, id)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// CancelScan stops the running scan of a source, and reports whether there
+// was one
+func (m *SourceManager) CancelScan(sourceID string) bool {
+	m.mu.RLock()
+	cancel, ok := m.scanCancels[sourceID]
+	m.mu.RUnlock()
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// scanFunc scans a source, Source.Scan or a partial scan
+type scanFunc func(
+	ctx context.Context,
+	known map[string]FileState,
+	tracks chan<- Track,
+	onFile func(path string, unchanged bool),
+	onIssue func(issue ScanIssue),
+) error
+
+// runScan runs a scan with progress reporting. Scans of different sources
+// run concurrently, scans of the same source wait for each other. within
+// limits the scan to some paths, so only the missing files under them are
+// removed, nil covers the whole source.
+func (m *SourceManager) runScan(
+	ctx context.Context,
+	sourceID, status string,
+	run scanFunc,
+	within []string,
+) (ScanProgress, error) {
+	lock := m.scanLock(sourceID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// The source may have been removed while waiting
+	m.mu.RLock()
+	_, ok := m.sources[sourceID]
+	m.mu.RUnlock()
+	if !ok {
+		return ScanProgress{}, fmt.Errorf("source not found: %s", sourceID)
+	}
+
 	// Initialize progress
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	m.mu.Lock()
-	m.scanProgress = &ScanProgress{
+	m.scans[sourceID] = &ScanProgress{
 		SourceID: sourceID,
-		Status:   "Scanning...",
+		Status:   status,
 	}
+	m.scanCancels[sourceID] = cancel
+	m.publish(*m.scans[sourceID])
 	m.mu.Unlock()
 
-	// Create buffered channels to avoid blocking
-	tracks := make(chan Track, 100)
-	errChan := make(chan error, 1)
-	doneChan := make(chan struct{})
+	err := m.scan(ctx, sourceID, run, within)
 
-	// Start track consumer
-	go func() {
-		defer close(doneChan)
-		for track := range tracks {
-			if err := m.db.SaveTrack(&track); err != nil {
-				errChan <- fmt.Errorf("failed to save track: %w", err)
-				return
-			}
-			m.mu.Lock()
-			m.scanProgress.Current++
-			m.mu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.scanCancels, sourceID)
+	progress := m.scans[sourceID]
+	progress.Done = true
+	if err != nil {
+		progress.Status = fmt.Sprintf("Error: %v", err)
+		if errors.Is(err, context.Canceled) {
+			progress.Status = "Cancelled"
 		}
-	}()
+		progress.Err = err
+		m.publish(*progress)
+		return ScanProgress{}, err
+	}
+
+	// Clear progress, keeping the counts of the finished scan
+	progress.Status = "Done"
+	m.lastScans[sourceID] = *progress
+	delete(m.scans, sourceID)
+	m.publish(*progress)
+	return *progress, nil
+}
+
+// scanLock returns the lock held while scanning a source
+func (m *SourceManager) scanLock(sourceID string) *sync.Mutex {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	lock, ok := m.scanLocks[sourceID]
+	if !ok {
+		lock = &sync.Mutex{}
+		m.scanLocks[sourceID] = lock
+	}
+	return lock
+}
+
+// GetLastScan returns the final progress of the last successful scan of a
+// source, nil before the first one
+func (m *SourceManager) GetLastScan(sourceID string) *ScanProgress {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	progress, ok := m.lastScans[sourceID]
+	if !ok {
+		return nil
+	}
+	return &progress
+}
 
-	// Start source scanner
+// fileKey identifies a file's content by its modification time and size
+type fileKey struct {
+	modTime int64
+	size    int64
+}
+
+func (m *SourceManager) scan(
+	ctx context.Context,
+	sourceID string,
+	run scanFunc,
+	within []string,
+) error {
+	known, err := m.db.GetFileStates(sourceID)
+	if err != nil {
+		return fmt.Errorf("failed to get indexed files: %w", err)
+	}
+
+	// Stop the scanner if saving fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Only touched by the scanner goroutine until it returns
+	seen := make(map[string]bool, len(known))
+	var issues []ScanIssue
+	tracks := make(chan Track, 100)
+	scanErr := make(chan error, 1)
 	go func() {
-		if err := source.Scan(ctx, tracks, func(path string) {
-			m.mu.Lock()
-			m.scanProgress.Total++
-			m.mu.Unlock()
-		}); err != nil {
-			errChan <- fmt.Errorf("scan failed: %w", err)
+		onFile := func(path string, unchanged bool) {
+			seen[path] = true
+			m.updateProgress(sourceID, func(p *ScanProgress) {
+				p.Total++
+				if unchanged {
+					p.Current++
+					p.Unchanged++
+				}
+			})
+		}
+		onIssue := func(issue ScanIssue) {
+			issues = append(issues, issue)
+			m.updateProgress(sourceID, func(p *ScanProgress) {
+				p.Issues++
+			})
 		}
+		scanErr <- run(ctx, known, tracks, onFile, onIssue)
 	}()
 
-	// Wait for completion or error
-	select {
-	case err := <-errChan:
-		m.mu.Lock()
-		m.scanProgress.Status = fmt.Sprintf("Error: %v", err)
-		m.mu.Unlock()
-		return err
-	case <-doneChan:
-		// Update last scanned time
-		config := SourceConfig{
-			ID:          sourceID,
-			Type:        source.Type(),
-			Name:        source.Name(),
-			LastScanned: time.Now(),
+	// New files by content state, to recognize the ones that were moved
+	added := make(map[fileKey][]string)
+
+	// Tracks are saved in batches, flushed when full or after a while so
+	// the progress keeps moving on slow sources
+	m.mu.RLock()
+	batchSize, flushInterval := m.batchSize, m.flushInterval
+	m.mu.RUnlock()
+	batch := make([]Track, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
 		}
-		if err := m.db.SaveSource(&config); err != nil {
-			return fmt.Errorf("failed to update source: %w", err)
+		if err := m.db.SaveTracks(batch); err != nil {
+			return fmt.Errorf("failed to save tracks: %w", err)
 		}
 
-		// Clear progress
-		m.mu.Lock()
-		m.scanProgress = nil
-		m.mu.Unlock()
+		var addedCount, updatedCount int
+		for _, track := range batch {
+			if _, existed := known[track.Path]; existed {
+				updatedCount++
+				continue
+			}
+			addedCount++
+			key := fileKey{track.ModTime.UnixNano(), track.Size}
+			added[key] = append(added[key], track.ID)
+		}
+		m.updateProgress(sourceID, func(p *ScanProgress) {
+			p.Current += len(batch)
+			p.Added += addedCount
+			p.Updated += updatedCount
+		})
+		batch = batch[:0]
 		return nil
 	}
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var saveErr error
+	for open := true; open; {
+		select {
+		case track, ok := <-tracks:
+			if !ok {
+				open = false
+				break
+			}
+			if saveErr != nil {
+				continue // Drain until the scanner stops
+			}
+			batch = append(batch, track)
+			if len(batch) >= batchSize {
+				saveErr = flush()
+			}
+		case <-ticker.C:
+			if saveErr == nil {
+				saveErr = flush()
+			}
+		}
+		if saveErr != nil {
+			cancel()
+		}
+	}
+	if saveErr == nil {
+		saveErr = flush()
+	}
+
+	if err := <-scanErr; err != nil && saveErr == nil {
+		return fmt.Errorf("scan failed: %w", err)
+	}
+	if saveErr != nil {
+		return saveErr
+	}
+
+	// The scan completed, so any indexed file it did not see is gone, unless
+	// it could not be read. A new file with the same modification time and
+	// size is the same file moved, it takes over the old track ID. Only a
+	// unique match counts as a move: when several gone or new files share a
+	// state, the gone ones are removed and the new ones stay added.
+	var unreadable []string
+	for _, issue := range issues {
+		if issue.Kind == IssueUnreadable {
+			unreadable = append(unreadable, issue.Path)
+		}
+	}
+	gone := make(map[fileKey][]string)
+	for path, state := range known {
+		if seen[path] || (within != nil && !isUnderAny(path, within)) {
+			continue
+		}
+		if isUnderAny(path, unreadable) {
+			continue
+		}
+		key := fileKey{state.ModTime.UnixNano(), state.Size}
+		gone[key] = append(gone[key], state.TrackID)
+	}
+	var removed []string
+	moved := 0
+	for key, ids := range gone {
+		if len(ids) == 1 && len(added[key]) == 1 {
+			if err := m.db.MoveTrack(ids[0], added[key][0]); err != nil {
+				return fmt.Errorf("failed to move track: %w", err)
+			}
+			moved++
+			continue
+		}
+		removed = append(removed, ids...)
+	}
+	if err := m.db.RemoveTracks(removed); err != nil {
+		return fmt.Errorf("failed to remove deleted tracks: %w", err)
+	}
+	m.updateProgress(sourceID, func(p *ScanProgress) {
+		p.Added -= moved
+		p.Moved = moved
+		p.Removed = len(removed)
+	})
+
+	now := time.Now()
+	if err := m.saveScanReport(sourceID, now, within, issues); err != nil {
+		return err
+	}
+	if err := m.db.MarkSourceScanned(sourceID, now); err != nil {
+		return fmt.Errorf("failed to update source: %w", err)
+	}
+	return nil
+}
+
+// saveScanReport stores the issues found by a scan. A scan limited to some
+// paths only replaces the issues found under them.
+func (m *SourceManager) saveScanReport(
+	sourceID string,
+	at time.Time,
+	within []string,
+	issues []ScanIssue,
+) error {
+	var old []ScanIssue
+	if within != nil {
+		report, err := m.db.GetScanReport(sourceID)
+		if err != nil {
+			return fmt.Errorf("failed to get scan report: %w", err)
+		}
+		old = report.Issues
+	}
+
+	report := ScanReport{
+		SourceID:  sourceID,
+		ScannedAt: at,
+		Issues:    mergeIssues(old, within, issues),
+	}
+	if err := m.db.SaveScanReport(report); err != nil {
+		return fmt.Errorf("failed to save scan report: %w", err)
+	}
+	return nil
+}
+
+// GetScanReport returns the issues found by the scans of a source
+func (m *SourceManager) GetScanReport(sourceID string) (ScanReport, error) {
+	return m.db.GetScanReport(sourceID)
 }
 
 // GetSources returns all registered sources
@@ -0,0 +1,250 @@
+package media
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStore keeps the indexed files of scans in memory, the methods scans do
+// not use are left unimplemented
+type memStore struct {
+	Store
+	mu      sync.Mutex
+	states  map[string]FileState
+	saved   []Track
+	removed []string
+	moves   [][2]string
+	report  ScanReport
+}
+
+func (s *memStore) GetFileStates(string) (map[string]FileState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	states := make(map[string]FileState, len(s.states))
+	for path, state := range s.states {
+		states[path] = state
+	}
+	return states, nil
+}
+
+func (s *memStore) SaveTracks(tracks []Track) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.states == nil {
+		s.states = make(map[string]FileState)
+	}
+	for _, track := range tracks {
+		s.states[track.Path] = FileState{TrackID: track.ID, ModTime: track.ModTime, Size: track.Size}
+	}
+	s.saved = append(s.saved, tracks...)
+	return nil
+}
+
+func (s *memStore) RemoveTracks(trackIDs []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for path, state := range s.states {
+		if slices.Contains(trackIDs, state.TrackID) {
+			delete(s.states, path)
+		}
+	}
+	s.removed = append(s.removed, trackIDs...)
+	return nil
+}
+
+func (s *memStore) MoveTrack(oldID, newID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for path, state := range s.states {
+		switch state.TrackID {
+		case oldID:
+			delete(s.states, path)
+		case newID:
+			state.TrackID = oldID
+			s.states[path] = state
+		}
+	}
+	s.moves = append(s.moves, [2]string{oldID, newID})
+	return nil
+}
+
+func (s *memStore) MarkSourceScanned(string, time.Time) error { return nil }
+
+func (s *memStore) GetScanReport(string) (ScanReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.report, nil
+}
+
+func (s *memStore) SaveScanReport(report ScanReport) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.report = report
+	return nil
+}
+
+// fakeSource scans with a function given by the test
+type fakeSource struct {
+	scan scanFunc
+}
+
+func (fakeSource) Type() string { return "fake" }
+func (fakeSource) Name() string { return "fake" }
+
+func (s fakeSource) Scan(
+	ctx context.Context,
+	known map[string]FileState,
+	tracks chan<- Track,
+	onFile func(path string, unchanged bool),
+	onIssue func(issue ScanIssue),
+) error {
+	return s.scan(ctx, known, tracks, onFile, onIssue)
+}
+
+// newTestManager returns a manager with sources scanned by the given
+// functions, keyed by source ID
+func newTestManager(store Store, scans map[string]scanFunc) *SourceManager {
+	m := NewSourceManager(store)
+	for id, scan := range scans {
+		m.sources[id] = fakeSource{scan: scan}
+	}
+	return m
+}
+
+// fakeFile is the state of a file of a fake tree
+type fakeFile struct {
+	modTime time.Time
+	size    int64
+}
+
+// scanTree returns a scan of a fake tree of files, as it is when the scan
+// runs. The directories listed as unreadable are reported instead of their
+// files, and a track is identified by its path like on a filesystem source.
+func scanTree(files map[string]fakeFile, unreadable *[]string) scanFunc {
+	return func(
+		ctx context.Context,
+		known map[string]FileState,
+		tracks chan<- Track,
+		onFile func(path string, unchanged bool),
+		onIssue func(issue ScanIssue),
+	) error {
+		defer close(tracks)
+		for _, dir := range *unreadable {
+			onIssue(ScanIssue{Path: dir, Kind: IssueUnreadable, Message: "permission denied"})
+		}
+		for path, file := range files {
+			if isUnderAny(path, *unreadable) {
+				continue
+			}
+			state, ok := known[path]
+			unchanged := ok && state.Unchanged(file.modTime, file.size)
+			onFile(path, unchanged)
+			if unchanged {
+				continue
+			}
+			track := Track{ID: "id:" + path, Path: path, ModTime: file.modTime, Size: file.size}
+			select {
+			case tracks <- track:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	}
+}
+
+func TestScanChanges(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	files := map[string]fakeFile{
+		"/music/same.mp3":        {day(1), 100},
+		"/music/changed.mp3":     {day(1), 200},
+		"/music/moved.mp3":       {day(1), 300},
+		"/music/deleted.mp3":     {day(1), 400},
+		"/music/locked/kept.mp3": {day(1), 500},
+		"/music/twin-1.mp3":      {day(2), 600},
+		"/music/twin-2.mp3":      {day(2), 600},
+		"/music/copied.mp3":      {day(3), 700},
+	}
+	var unreadable []string
+	store := &memStore{}
+	m := newTestManager(store, map[string]scanFunc{"src": scanTree(files, &unreadable)})
+
+	if err := m.ScanSource(context.Background(), "src"); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.GetLastScan("src"); got.Added != len(files) {
+		t.Fatalf("first scan added %d files, want %d", got.Added, len(files))
+	}
+
+	files["/music/changed.mp3"] = fakeFile{day(4), 210}
+	// A file renamed keeps its modification time and size
+	files["/elsewhere/moved.mp3"] = files["/music/moved.mp3"]
+	delete(files, "/music/moved.mp3")
+	delete(files, "/music/deleted.mp3")
+	unreadable = []string{"/music/locked"}
+	// Neither of two gone files with the same state is moved to a new one
+	delete(files, "/music/twin-1.mp3")
+	delete(files, "/music/twin-2.mp3")
+	files["/music/twin-3.mp3"] = fakeFile{day(2), 600}
+	// Nor is a gone file to one of two new files with its state
+	delete(files, "/music/copied.mp3")
+	files["/music/copy-1.mp3"] = fakeFile{day(3), 700}
+	files["/music/copy-2.mp3"] = fakeFile{day(3), 700}
+	files["/music/new.mp3"] = fakeFile{day(4), 800}
+
+	if err := m.ScanSource(context.Background(), "src"); err != nil {
+		t.Fatal(err)
+	}
+	got := *m.GetLastScan("src")
+	want := ScanProgress{
+		SourceID:  "src",
+		Total:     7,
+		Current:   7,
+		Added:     4,
+		Updated:   1,
+		Unchanged: 1,
+		Moved:     1,
+		Removed:   4,
+		Issues:    1,
+		Status:    "Done",
+		Done:      true,
+	}
+	if got != want {
+		t.Errorf("second scan = %+v, want %+v", got, want)
+	}
+
+	if want := [][2]string{{"id:/music/moved.mp3", "id:/elsewhere/moved.mp3"}}; !reflect.DeepEqual(store.moves, want) {
+		t.Errorf("moves = %v, want %v", store.moves, want)
+	}
+	slices.Sort(store.removed)
+	wantRemoved := []string{
+		"id:/music/copied.mp3", "id:/music/deleted.mp3", "id:/music/twin-1.mp3", "id:/music/twin-2.mp3",
+	}
+	if !reflect.DeepEqual(store.removed, wantRemoved) {
+		t.Errorf("removed = %v, want %v", store.removed, wantRemoved)
+	}
+
+	// The tracks under the unreadable directory are kept
+	states, _ := store.GetFileStates("src")
+	wantStates := map[string]string{
+		"/music/same.mp3":        "id:/music/same.mp3",
+		"/music/changed.mp3":     "id:/music/changed.mp3",
+		"/elsewhere/moved.mp3":   "id:/music/moved.mp3",
+		"/music/locked/kept.mp3": "id:/music/locked/kept.mp3",
+		"/music/twin-3.mp3":      "id:/music/twin-3.mp3",
+		"/music/copy-1.mp3":      "id:/music/copy-1.mp3",
+		"/music/copy-2.mp3":      "id:/music/copy-2.mp3",
+		"/music/new.mp3":         "id:/music/new.mp3",
+	}
+	gotStates := make(map[string]string, len(states))
+	for path, state := range states {
+		gotStates[path] = state.TrackID
+	}
+	if !reflect.DeepEqual(gotStates, wantStates) {
+		t.Errorf("indexed files = %v, want %v", gotStates, wantStates)
+	}
+}
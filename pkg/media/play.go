@@ -0,0 +1,50 @@
+package media
+
+import "time"
+
+// Play is one listen to a track. Listens reaching the threshold count as
+// plays of the track, the ones left before it as skips.
+type Play struct {
+	ID        int64
+	Track     Track
+	StartedAt time.Time
+	// Listened is the time actually heard, pauses and seeks excluded
+	Listened time.Duration
+	// Skipped is set when the listener moved on before the threshold
+	Skipped bool
+	// Removed is set when the track left the library since, Track then
+	// only holds its ID, artist, title and album
+	Removed bool
+}
+
+// MaxPlayThreshold is the listening time counting as a play whatever the
+// length of the track, as in the rule Last.fm uses for scrobbles
+const MaxPlayThreshold = 4 * time.Minute
+
+// PlayThreshold returns how long a track must be listened to for the listen
+// to count as a play: half of it, or four minutes for long tracks
+func PlayThreshold(duration time.Duration) time.Duration {
+	if duration <= 0 {
+		return MaxPlayThreshold
+	}
+	return min(duration/2, MaxPlayThreshold)
+}
+
+// Scrobble is a play waiting in the outbox to be submitted to a scrobbling
+// service, with the track as it was when played
+type Scrobble struct {
+	ID          int64
+	Service     string
+	PlayID      int64
+	Artist      string
+	Title       string
+	Album       string
+	AlbumArtist string
+	TrackNumber int
+	Duration    time.Duration
+	PlayedAt    time.Time
+	// Attempts counts the failed submissions, LastError tells why the last
+	// one failed
+	Attempts  int
+	LastError string
+}
@@ -0,0 +1,26 @@
+package media
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPlayThreshold(t *testing.T) {
+	tests := []struct {
+		duration time.Duration
+		want     time.Duration
+	}{
+		{30 * time.Second, 15 * time.Second},
+		{3 * time.Minute, 90 * time.Second},
+		{8 * time.Minute, 4 * time.Minute},
+		{20 * time.Minute, 4 * time.Minute},
+		// Unknown lengths
+		{0, 4 * time.Minute},
+		{-time.Second, 4 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := PlayThreshold(tt.duration); got != tt.want {
+			t.Errorf("PlayThreshold(%v) = %v, want %v", tt.duration, got, tt.want)
+		}
+	}
+}
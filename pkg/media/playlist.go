@@ -0,0 +1,21 @@
+package media
+
+import "time"
+
+// Playlist is an ordered list of tracks from any source, a track can appear
+// more than once. Smart playlists have rules selecting their tracks instead.
+type Playlist struct {
+	ID   string
+	Name string
+	// Rules are nil for playlists whose tracks are added by hand
+	Rules     *SmartRules
+	Tracks    int
+	Duration  time.Duration
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Smart reports whether the tracks of the playlist are selected by rules
+func (p Playlist) Smart() bool {
+	return p.Rules != nil
+}
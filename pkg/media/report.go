@@ -0,0 +1,50 @@
+package media
+
+import "time"
+
+// IssueKind tells why a file could not be indexed properly
+type IssueKind string
+
+const (
+	// IssueUnreadable files and directories could not be opened or listed,
+	// they are left out of the library
+	IssueUnreadable IssueKind = "unreadable"
+	// IssueCorruptTags files are indexed under their file name
+	IssueCorruptTags IssueKind = "corrupt tags"
+	// IssueUndecodable files have audio headers that could not be parsed,
+	// they are indexed without a duration
+	IssueUndecodable IssueKind = "undecodable"
+)
+
+// ScanIssue is a problem found with a file or directory during a scan
+type ScanIssue struct {
+	Path    string
+	Kind    IssueKind
+	Message string
+}
+
+func newScanIssue(path string, kind IssueKind, err error) ScanIssue {
+	return ScanIssue{Path: path, Kind: kind, Message: err.Error()}
+}
+
+// ScanReport lists the issues found by the last scan of a source
+type ScanReport struct {
+	SourceID  string
+	ScannedAt time.Time
+	Issues    []ScanIssue
+}
+
+// mergeIssues returns the issues of a report updated by a scan limited to
+// some paths: the old issues under those paths are replaced by the new ones
+func mergeIssues(old []ScanIssue, within []string, issues []ScanIssue) []ScanIssue {
+	if within == nil {
+		return issues
+	}
+	merged := make([]ScanIssue, 0, len(old)+len(issues))
+	for _, issue := range old {
+		if !isUnderAny(issue.Path, within) {
+			merged = append(merged, issue)
+		}
+	}
+	return append(merged, issues...)
+}
@@ -0,0 +1,104 @@
+package media
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestMergeIssues(t *testing.T) {
+	unreadable := ScanIssue{Path: "/music/a", Kind: IssueUnreadable}
+	corrupt := ScanIssue{Path: "/music/a/1.mp3", Kind: IssueCorruptTags}
+	undecodable := ScanIssue{Path: "/music/b/1.mp3", Kind: IssueUndecodable}
+	fresh := ScanIssue{Path: "/music/a/2.mp3", Kind: IssueCorruptTags}
+	tests := []struct {
+		name   string
+		old    []ScanIssue
+		within []string
+		issues []ScanIssue
+		want   []ScanIssue
+	}{
+		{"full scan", []ScanIssue{unreadable, undecodable}, nil, []ScanIssue{fresh}, []ScanIssue{fresh}},
+		{"full scan without issues", []ScanIssue{unreadable}, nil, nil, nil},
+		{
+			"issues under the paths are replaced",
+			[]ScanIssue{unreadable, corrupt, undecodable},
+			[]string{"/music/a"},
+			[]ScanIssue{fresh},
+			[]ScanIssue{undecodable, fresh},
+		},
+		{
+			"issues of other paths are kept",
+			[]ScanIssue{corrupt, undecodable},
+			[]string{"/music/b/2.mp3", "/music/ab"},
+			nil,
+			[]ScanIssue{corrupt, undecodable},
+		},
+		{"single file", []ScanIssue{corrupt}, []string{"/music/a/1.mp3"}, nil, []ScanIssue{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeIssues(tt.old, tt.within, tt.issues); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeIssues = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanReport(t *testing.T) {
+	store := &memStore{states: map[string]FileState{
+		"/music/kept.mp3":        {TrackID: "kept", Size: 1},
+		"/music/locked/song.mp3": {TrackID: "locked", Size: 2},
+		"/music/gone.mp3":        {TrackID: "gone", Size: 3},
+	}}
+	unreadable := ScanIssue{Path: "/music/locked", Kind: IssueUnreadable, Message: "permission denied"}
+	corrupt := ScanIssue{Path: "/music/new.mp3", Kind: IssueCorruptTags, Message: "bad frame"}
+	m := newTestManager(store, map[string]scanFunc{"src": func(
+		_ context.Context,
+		_ map[string]FileState,
+		tracks chan<- Track,
+		onFile func(path string, unchanged bool),
+		onIssue func(issue ScanIssue),
+	) error {
+		defer close(tracks)
+		onFile("/music/kept.mp3", true)
+		onIssue(unreadable)
+		onFile("/music/new.mp3", false)
+		onIssue(corrupt)
+		tracks <- Track{ID: "new", Path: "/music/new.mp3", Size: 4}
+		return nil
+	}})
+
+	if err := m.ScanSource(context.Background(), "src"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []ScanIssue{unreadable, corrupt}; !reflect.DeepEqual(store.report.Issues, want) {
+		t.Errorf("report issues = %v, want %v", store.report.Issues, want)
+	}
+	if last := m.GetLastScan("src"); last == nil || last.Issues != 2 {
+		t.Errorf("last scan = %+v, want 2 issues", last)
+	}
+	// The tracks of the unreadable directory may still be there
+	if want := []string{"gone"}; !reflect.DeepEqual(store.removed, want) {
+		t.Errorf("removed %v, want %v", store.removed, want)
+	}
+
+	// Rescanning the directory once it can be read clears its issue only
+	_, err := m.runScan(context.Background(), "src", "Updating...", func(
+		_ context.Context,
+		_ map[string]FileState,
+		tracks chan<- Track,
+		onFile func(path string, unchanged bool),
+		_ func(issue ScanIssue),
+	) error {
+		defer close(tracks)
+		onFile("/music/locked/song.mp3", true)
+		return nil
+	}, []string{"/music/locked"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []ScanIssue{corrupt}; !reflect.DeepEqual(store.report.Issues, want) {
+		t.Errorf("report issues after rescan = %v, want %v", store.report.Issues, want)
+	}
+}
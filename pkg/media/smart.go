@@ -0,0 +1,232 @@
+package media
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// RuleField is a track attribute smart playlist rules test and sort on
+type RuleField string
+
+const (
+	FieldTitle       RuleField = "title"
+	FieldArtist      RuleField = "artist"
+	FieldAlbum       RuleField = "album"
+	FieldAlbumArtist RuleField = "albumartist"
+	FieldGenre       RuleField = "genre"
+	FieldComposer    RuleField = "composer"
+	FieldPath        RuleField = "path"
+	FieldYear        RuleField = "year"
+	// FieldDuration values are in seconds or Go durations such as "4m30s"
+	FieldDuration RuleField = "duration"
+	// FieldAdded is when the track entered the library
+	FieldAdded RuleField = "added"
+	// FieldModified is the modification time of the file
+	FieldModified RuleField = "modified"
+	// FieldPlays and FieldSkips count the listens of the track
+	FieldPlays RuleField = "plays"
+	FieldSkips RuleField = "skips"
+	// FieldLastPlayed is when the track was last played, never played
+	// tracks being out of every period
+	FieldLastPlayed RuleField = "lastplayed"
+	// FieldRandom only sorts, in a new random order each time
+	FieldRandom RuleField = "random"
+)
+
+// FieldKind tells which operators and values a field accepts
+type FieldKind int
+
+const (
+	TextField FieldKind = iota
+	NumberField
+	// DateField values are dates such as "2024-01-31", or periods such as
+	// "30d", "2w", "6m" or "1y" for the within operators
+	DateField
+)
+
+var ruleFields = map[RuleField]FieldKind{
+	FieldTitle:       TextField,
+	FieldArtist:      TextField,
+	FieldAlbum:       TextField,
+	FieldAlbumArtist: TextField,
+	FieldGenre:       TextField,
+	FieldComposer:    TextField,
+	FieldPath:        TextField,
+	FieldYear:        NumberField,
+	FieldDuration:    NumberField,
+	FieldAdded:       DateField,
+	FieldModified:    DateField,
+	FieldPlays:       NumberField,
+	FieldSkips:       NumberField,
+	FieldLastPlayed:  DateField,
+}
+
+// Kind returns the kind of a field, false for unknown fields
+func (f RuleField) Kind() (FieldKind, bool) {
+	kind, ok := ruleFields[f]
+	return kind, ok
+}
+
+// RuleOperator compares a field to the value of a rule
+type RuleOperator string
+
+const (
+	OpEqual        RuleOperator = "="
+	OpNotEqual     RuleOperator = "!="
+	OpLess         RuleOperator = "<"
+	OpLessEqual    RuleOperator = "<="
+	OpGreater      RuleOperator = ">"
+	OpGreaterEqual RuleOperator = ">="
+	// OpContains, OpStartsWith and OpEndsWith match text, ignoring case
+	OpContains    RuleOperator = "contains"
+	OpNotContains RuleOperator = "!contains"
+	OpStartsWith  RuleOperator = "starts"
+	OpEndsWith    RuleOperator = "ends"
+	// OpWithin matches dates in a period before now, such as "30d"
+	OpWithin    RuleOperator = "within"
+	OpNotWithin RuleOperator = "!within"
+)
+
+var comparisons = []RuleOperator{
+	OpEqual, OpNotEqual, OpLess, OpLessEqual, OpGreater, OpGreaterEqual,
+}
+
+var kindOperators = map[FieldKind][]RuleOperator{
+	TextField: {
+		OpEqual, OpNotEqual, OpContains, OpNotContains, OpStartsWith, OpEndsWith,
+	},
+	NumberField: comparisons,
+	DateField:   append([]RuleOperator{OpWithin, OpNotWithin}, comparisons...),
+}
+
+// Rule is a condition on a field of the tracks, or a nested group of rules
+type Rule struct {
+	Field    RuleField    `json:"field,omitempty"`
+	Operator RuleOperator `json:"op,omitempty"`
+	Value    string       `json:"value,omitempty"`
+	// Group replaces the condition with a group of rules
+	Group *RuleGroup `json:"group,omitempty"`
+}
+
+// RuleGroup matches tracks matching all its rules, or any of them. An empty
+// group matches every track.
+type RuleGroup struct {
+	Any   bool   `json:"any,omitempty"`
+	Rules []Rule `json:"rules"`
+}
+
+// SmartRules select the tracks of a smart playlist, which follows the
+// library as it changes
+type SmartRules struct {
+	Match RuleGroup `json:"match"`
+	// Sort orders the tracks before the limit applies, album order when
+	// empty
+	Sort       RuleField `json:"sort,omitempty"`
+	Descending bool      `json:"desc,omitempty"`
+	// Limit is the maximum number of tracks, none when zero
+	Limit int `json:"limit,omitempty"`
+}
+
+// Validate checks the fields, operators and values of the rules
+func (r SmartRules) Validate() error {
+	if r.Limit < 0 {
+		return fmt.Errorf("invalid limit: %d", r.Limit)
+	}
+	if _, ok := r.Sort.Kind(); !ok && r.Sort != "" && r.Sort != FieldRandom {
+		return fmt.Errorf("unknown sort field: %q", r.Sort)
+	}
+	return r.Match.validate()
+}
+
+func (g RuleGroup) validate() error {
+	for _, rule := range g.Rules {
+		if err := rule.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r Rule) validate() error {
+	if r.Group != nil {
+		return r.Group.validate()
+	}
+	kind, ok := r.Field.Kind()
+	if !ok {
+		return fmt.Errorf("unknown field: %q", r.Field)
+	}
+	valid := false
+	for _, op := range kindOperators[kind] {
+		valid = valid || op == r.Operator
+	}
+	if !valid {
+		return fmt.Errorf("%s does not support %q", r.Field, r.Operator)
+	}
+
+	switch kind {
+	case NumberField:
+		_, err := r.Number()
+		return err
+	case DateField:
+		_, err := r.Time(time.Now())
+		return err
+	}
+	return nil
+}
+
+// Number returns the value of a rule on a number field, in seconds for
+// durations
+func (r Rule) Number() (float64, error) {
+	if r.Field == FieldDuration {
+		if d, err := time.ParseDuration(r.Value); err == nil {
+			return d.Seconds(), nil
+		}
+	}
+	n, err := strconv.ParseFloat(r.Value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid number %q", r.Field, r.Value)
+	}
+	return n, nil
+}
+
+// Time returns the value of a rule on a date field: the start of the
+// period before now for the within operators, else the start of the day
+func (r Rule) Time(now time.Time) (time.Time, error) {
+	if r.Operator == OpWithin || r.Operator == OpNotWithin {
+		return periodStart(r.Value, now)
+	}
+	day, err := time.ParseInLocation("2006-01-02", r.Value, now.Location())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: invalid date %q, want YYYY-MM-DD", r.Field, r.Value)
+	}
+	return day, nil
+}
+
+// periodStart returns now minus a period such as "30d", "2w", "6m" or "1y",
+// a bare number counting days
+func periodStart(period string, now time.Time) (time.Time, error) {
+	invalid := fmt.Errorf("invalid period %q, want a count of d, w, m or y", period)
+	if period == "" {
+		return time.Time{}, invalid
+	}
+	count, unit := period, byte('d')
+	if last := period[len(period)-1]; last < '0' || last > '9' {
+		count, unit = period[:len(period)-1], last
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return time.Time{}, invalid
+	}
+	switch unit {
+	case 'd':
+		return now.AddDate(0, 0, -n), nil
+	case 'w':
+		return now.AddDate(0, 0, -7*n), nil
+	case 'm':
+		return now.AddDate(0, -n, 0), nil
+	case 'y':
+		return now.AddDate(-n, 0, 0), nil
+	}
+	return time.Time{}, invalid
+}
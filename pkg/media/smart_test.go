@@ -0,0 +1,118 @@
+package media
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSmartRules(t *testing.T) {
+	cond := func(field RuleField, op RuleOperator, value string) Rule {
+		return Rule{Field: field, Operator: op, Value: value}
+	}
+	tests := []struct {
+		text string
+		want SmartRules
+	}{
+		{"", SmartRules{}},
+		{
+			"genre = jazz and year<1970",
+			SmartRules{Match: RuleGroup{Rules: []Rule{
+				cond(FieldGenre, OpEqual, "jazz"),
+				cond(FieldYear, OpLess, "1970"),
+			}}},
+		},
+		{
+			"added within 30d sort added desc limit 50",
+			SmartRules{
+				Match:      RuleGroup{Rules: []Rule{cond(FieldAdded, OpWithin, "30d")}},
+				Sort:       FieldAdded,
+				Descending: true,
+				Limit:      50,
+			},
+		},
+		{
+			`(artist = "miles davis" or artist contains coltrane) and duration > 5m`,
+			SmartRules{Match: RuleGroup{Rules: []Rule{
+				{Group: &RuleGroup{Any: true, Rules: []Rule{
+					cond(FieldArtist, OpEqual, "miles davis"),
+					cond(FieldArtist, OpContains, "coltrane"),
+				}}},
+				cond(FieldDuration, OpGreater, "5m"),
+			}}},
+		},
+		{
+			"genre = jazz or genre = blues and year != 1959 or title !contains live",
+			SmartRules{Match: RuleGroup{Any: true, Rules: []Rule{
+				cond(FieldGenre, OpEqual, "jazz"),
+				{Group: &RuleGroup{Rules: []Rule{
+					cond(FieldGenre, OpEqual, "blues"),
+					cond(FieldYear, OpNotEqual, "1959"),
+				}}},
+				cond(FieldTitle, OpNotContains, "live"),
+			}}},
+		},
+		{"sort random limit 20", SmartRules{Sort: FieldRandom, Limit: 20}},
+	}
+	for _, tt := range tests {
+		got, err := ParseSmartRules(tt.text)
+		if err != nil {
+			t.Errorf("ParseSmartRules(%q): %v", tt.text, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseSmartRules(%q) = %+v, want %+v", tt.text, got, tt.want)
+		}
+		// The text form reads back to the same rules
+		again, err := ParseSmartRules(got.String())
+		if err != nil || !reflect.DeepEqual(again, got) {
+			t.Errorf("ParseSmartRules(%q) = %+v, %v, want %+v", got.String(), again, err, got)
+		}
+	}
+}
+
+func TestParseSmartRulesErrors(t *testing.T) {
+	for _, text := range []string{
+		"mood = happy",
+		"year contains 19",
+		"genre ~ jazz",
+		"year < soon",
+		"added within forever",
+		"added > 31/01/2024",
+		"genre = jazz and",
+		"(genre = jazz",
+		`title = "unterminated`,
+		"limit none",
+		"limit",
+		"genre = jazz limit",
+		"sort mood",
+		"sort",
+		"genre = jazz sort",
+		"limit 5 sort",
+		"sort limit 5",
+		"sort desc",
+	} {
+		if _, err := ParseSmartRules(text); err == nil {
+			t.Errorf("ParseSmartRules(%q) succeeded", text)
+		}
+	}
+}
+
+func TestRuleTime(t *testing.T) {
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		rule Rule
+		want time.Time
+	}{
+		{Rule{Field: FieldAdded, Operator: OpWithin, Value: "30"}, time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)},
+		{Rule{Field: FieldAdded, Operator: OpWithin, Value: "2w"}, time.Date(2024, 3, 17, 12, 0, 0, 0, time.UTC)},
+		{Rule{Field: FieldAdded, Operator: OpNotWithin, Value: "1y"}, time.Date(2023, 3, 31, 12, 0, 0, 0, time.UTC)},
+		{Rule{Field: FieldAdded, Operator: OpLess, Value: "2024-01-31"}, time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := tt.rule.Time(now)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("%s: Time() = %v, %v, want %v", tt.rule, got, err, tt.want)
+		}
+	}
+}
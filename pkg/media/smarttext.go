@@ -0,0 +1,281 @@
+package media
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseSmartRules parses smart playlist rules written as text, such as
+//
+//	genre = jazz and year < 1970
+//	added within 30d sort added desc limit 50
+//	(artist = "miles davis" or artist contains coltrane) and duration > 5m
+//
+// Conditions are a field, an operator and a value, quoted when it holds
+// spaces or keywords. "and" binds tighter than "or", parentheses group
+// conditions, and "limit" and "sort" end the rules.
+func ParseSmartRules(text string) (SmartRules, error) {
+	tokens, err := tokenizeRules(text)
+	if err != nil {
+		return SmartRules{}, err
+	}
+	p := &ruleParser{tokens: tokens}
+
+	var rules SmartRules
+	if !p.done() && !p.atKeyword("limit", "sort") {
+		rules.Match, err = p.parseOr()
+		if err != nil {
+			return SmartRules{}, err
+		}
+	}
+	for !p.done() {
+		switch {
+		case p.atKeyword("limit"):
+			p.next()
+			limit, err := strconv.Atoi(p.next().text)
+			if err != nil || limit <= 0 {
+				return SmartRules{}, fmt.Errorf("limit wants a positive number")
+			}
+			rules.Limit = limit
+		case p.atKeyword("sort"):
+			p.next()
+			if p.done() || p.atKeyword("limit", "sort", "asc", "desc") {
+				return SmartRules{}, fmt.Errorf("sort wants a field")
+			}
+			rules.Sort = RuleField(strings.ToLower(p.next().text))
+			if p.atKeyword("desc") {
+				p.next()
+				rules.Descending = true
+			} else if p.atKeyword("asc") {
+				p.next()
+			}
+		default:
+			return SmartRules{}, fmt.Errorf("unexpected %q", p.peek().text)
+		}
+	}
+	return rules, rules.Validate()
+}
+
+// ruleToken is a word, an operator, a parenthesis or a quoted value
+type ruleToken struct {
+	text   string
+	quoted bool
+}
+
+func tokenizeRules(text string) ([]ruleToken, error) {
+	var tokens []ruleToken
+	isOperator := func(c byte) bool { return strings.IndexByte("<>=!", c) >= 0 }
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, ruleToken{text: string(c)})
+			i++
+		case c == '"':
+			var value strings.Builder
+			i++
+			for i < len(text) && text[i] != '"' {
+				if text[i] == '\\' && i+1 < len(text) {
+					i++
+				}
+				value.WriteByte(text[i])
+				i++
+			}
+			if i == len(text) {
+				return nil, fmt.Errorf("unterminated quote")
+			}
+			tokens = append(tokens, ruleToken{text: value.String(), quoted: true})
+			i++
+		// "!" starts words such as "!contains" but not "!="
+		case isOperator(c) && !(c == '!' && i+1 < len(text) && text[i+1] != '='):
+			start := i
+			for i < len(text) && isOperator(text[i]) {
+				i++
+			}
+			tokens = append(tokens, ruleToken{text: text[start:i]})
+		default:
+			start := i
+			i++
+			for i < len(text) && strings.IndexByte(" \t\n()\"<>=", text[i]) < 0 &&
+				!(text[i] == '!' && i+1 < len(text) && text[i+1] == '=') {
+				i++
+			}
+			tokens = append(tokens, ruleToken{text: text[start:i]})
+		}
+	}
+	return tokens, nil
+}
+
+type ruleParser struct {
+	tokens []ruleToken
+	pos    int
+}
+
+func (p *ruleParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *ruleParser) peek() ruleToken {
+	if p.done() {
+		return ruleToken{}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *ruleParser) next() ruleToken {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+// atKeyword reports whether the next token is one of the unquoted keywords
+func (p *ruleParser) atKeyword(keywords ...string) bool {
+	token := p.peek()
+	if token.quoted {
+		return false
+	}
+	for _, keyword := range keywords {
+		if strings.EqualFold(token.text, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseOr parses conditions joined by "or", each of them being conditions
+// joined by "and"
+func (p *ruleParser) parseOr() (RuleGroup, error) {
+	group := RuleGroup{Any: true}
+	for {
+		all, err := p.parseAnd()
+		if err != nil {
+			return RuleGroup{}, err
+		}
+		group.Rules = appendRules(group.Rules, true, Rule{Group: &all})
+		if !p.atKeyword("or") {
+			break
+		}
+		p.next()
+	}
+	// A single condition or group needs no "or"
+	if len(group.Rules) == 1 {
+		if inner := group.Rules[0].Group; inner != nil {
+			return *inner, nil
+		}
+		group.Any = false
+	}
+	return group, nil
+}
+
+func (p *ruleParser) parseAnd() (RuleGroup, error) {
+	var group RuleGroup
+	for {
+		rule, err := p.parseCondition()
+		if err != nil {
+			return RuleGroup{}, err
+		}
+		group.Rules = appendRules(group.Rules, false, rule)
+		if !p.atKeyword("and") {
+			break
+		}
+		p.next()
+	}
+	return group, nil
+}
+
+// parseCondition parses a condition or a group in parentheses
+func (p *ruleParser) parseCondition() (Rule, error) {
+	if token := p.peek(); token.text == "(" && !token.quoted {
+		p.next()
+		group, err := p.parseOr()
+		if err != nil {
+			return Rule{}, err
+		}
+		if token := p.next(); token.text != ")" || token.quoted {
+			return Rule{}, fmt.Errorf("missing closing parenthesis")
+		}
+		return Rule{Group: &group}, nil
+	}
+
+	if p.done() || p.atKeyword("and", "or", "limit", "sort") {
+		return Rule{}, fmt.Errorf("missing condition")
+	}
+	field := p.next()
+	operator := p.next()
+	if p.done() {
+		return Rule{}, fmt.Errorf("incomplete condition on %q", field.text)
+	}
+	value := p.next()
+	return Rule{
+		Field:    RuleField(strings.ToLower(field.text)),
+		Operator: RuleOperator(strings.ToLower(operator.text)),
+		Value:    value.text,
+	}, nil
+}
+
+// appendRules appends a rule to the rules of a group, flattening groups
+// matching the same way and groups of a single rule
+func appendRules(rules []Rule, matchAny bool, rule Rule) []Rule {
+	if group := rule.Group; group != nil {
+		if group.Any == matchAny || len(group.Rules) <= 1 {
+			return append(rules, group.Rules...)
+		}
+	}
+	return append(rules, rule)
+}
+
+// String returns the rules in the text form read by ParseSmartRules
+func (r SmartRules) String() string {
+	var parts []string
+	if len(r.Match.Rules) > 0 {
+		parts = append(parts, r.Match.String())
+	}
+	if r.Sort != "" {
+		sort := "sort " + string(r.Sort)
+		if r.Descending {
+			sort += " desc"
+		}
+		parts = append(parts, sort)
+	}
+	if r.Limit > 0 {
+		parts = append(parts, fmt.Sprintf("limit %d", r.Limit))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (g RuleGroup) String() string {
+	join := " and "
+	if g.Any {
+		join = " or "
+	}
+	parts := make([]string, len(g.Rules))
+	for i, rule := range g.Rules {
+		parts[i] = rule.String()
+	}
+	return strings.Join(parts, join)
+}
+
+func (r Rule) String() string {
+	if r.Group != nil {
+		if len(r.Group.Rules) == 1 {
+			return r.Group.Rules[0].String()
+		}
+		return "(" + r.Group.String() + ")"
+	}
+	return fmt.Sprintf("%s %s %s", r.Field, r.Operator, quoteRuleValue(r.Value))
+}
+
+// quoteRuleValue quotes the values that would not read back as one word
+func quoteRuleValue(value string) string {
+	switch strings.ToLower(value) {
+	case "and", "or", "limit", "sort":
+		return strconv.Quote(value)
+	}
+	if value == "" || strings.ContainsAny(value, " \t\n()\"<>=!\\") {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+	}
+	return value
+}
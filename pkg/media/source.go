@@ -11,9 +11,50 @@ type Source interface {
 	Type() string
 	// Name returns a human-readable name for the source
 	Name() string
-	// Scan scans the source for tracks and returns them through the channel
-	// The optional onFile callback is called for each file encountered, even if it's not a track
-	Scan(ctx context.Context, tracks chan<- Track, onFile func(path string)) error
+	// Scan scans the source for tracks and returns the new and changed ones
+	// through the channel, keeping the ID of changed tracks. known maps the
+	// paths indexed by the previous scan to their state, files whose state is
+	// unchanged are skipped.
+	// The optional onFile callback is called once for each track file found,
+	// with unchanged set when the file was skipped. Files and directories
+	// that cannot be read properly are reported to the optional onIssue
+	// callback and do not stop the scan. Calls never overlap.
+	Scan(
+		ctx context.Context,
+		known map[string]FileState,
+		tracks chan<- Track,
+		onFile func(path string, unchanged bool),
+		onIssue func(issue ScanIssue),
+	) error
+}
+
+// WatchableSource is a Source that can report changes as they happen
+type WatchableSource interface {
+	Source
+	// Watch sends batches of changed paths until the context is cancelled
+	Watch(ctx context.Context, changes chan<- []string) error
+	// ScanPaths works like Scan, limited to the given paths
+	ScanPaths(
+		ctx context.Context,
+		paths []string,
+		known map[string]FileState,
+		tracks chan<- Track,
+		onFile func(path string, unchanged bool),
+		onIssue func(issue ScanIssue),
+	) error
+}
+
+// FileState identifies the version of a file indexed by a scan
+type FileState struct {
+	TrackID string
+	ModTime time.Time
+	Size    int64
+}
+
+// Unchanged reports whether a file still has the same modification time and
+// size
+func (s FileState) Unchanged(modTime time.Time, size int64) bool {
+	return s.ModTime.Equal(modTime) && s.Size == size
 }
 
 // Track represents a media track with its metadata
@@ -25,8 +66,36 @@ type Track struct {
 	Title       string
 	Artist      string
 	Album       string
+	AlbumArtist string
+	TrackNumber int
+	DiscNumber  int
+	Year        int
+	Genre       string
+	Composer    string
 	Duration    time.Duration
+	ReplayGain  ReplayGain
+	Loudness    Loudness
+	ModTime     time.Time
+	Size        int64
 	LastScanned time.Time
+	// AddedAt is when the track entered the library
+	AddedAt time.Time
+	// PlayCount and SkipCount count the listens recorded for the track,
+	// LastPlayed is zero when it was never played
+	PlayCount  int
+	SkipCount  int
+	LastPlayed time.Time
+}
+
+// ReplayGain holds the ReplayGain values of a track. Gains are in dB and
+// peaks are linear sample amplitudes, zero when unknown.
+type ReplayGain struct {
+	TrackGain float64
+	TrackPeak float64
+	AlbumGain float64
+	AlbumPeak float64
+	HasTrack  bool
+	HasAlbum  bool
 }
 
 // SourceConfig represents the configuration for a media source
@@ -37,3 +106,13 @@ type SourceConfig struct {
 	Config      map[string]string
 	LastScanned time.Time
 }
+
+// Loudness holds the EBU R128 measurement of a track
+type Loudness struct {
+	// Integrated is the integrated loudness in LUFS
+	Integrated float64
+	// TruePeak is the true peak as a linear sample amplitude
+	TruePeak float64
+	// Analyzed is false until the track has been measured
+	Analyzed bool
+}
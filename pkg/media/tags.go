@@ -0,0 +1,200 @@
+package media
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bogem/id3v2/v2"
+	"github.com/jfreymuth/oggvorbis"
+	"github.com/mewkiz/flac"
+	"github.com/mewkiz/flac/meta"
+)
+
+// Tags holds the metadata read from an audio file's native tags
+type Tags struct {
+	Title       string
+	Artist      string
+	Album       string
+	AlbumArtist string
+	TrackNumber int
+	DiscNumber  int
+	Year        int
+	Genre       string
+	Composer    string
+	ReplayGain  ReplayGain
+}
+
+// ReadTags reads the native tags of an audio file: ID3v2 for MP3 and WAV,
+// Vorbis comments for FLAC and Ogg Vorbis
+func ReadTags(path string) (Tags, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".flac":
+		return readFLACTags(path)
+	case ".ogg", ".oga":
+		return readOggTags(path)
+	default:
+		return readID3Tags(path)
+	}
+}
+
+func readID3Tags(path string) (Tags, error) {
+	tag, err := id3v2.Open(path, id3v2.Options{Parse: true})
+	if err != nil {
+		return Tags{}, err
+	}
+	defer tag.Close()
+
+	// ReplayGain values live in user defined text frames
+	txxx := make(map[string]string)
+	for _, frame := range tag.GetFrames("TXXX") {
+		if udtf, ok := frame.(id3v2.UserDefinedTextFrame); ok {
+			txxx[strings.ToUpper(udtf.Description)] = udtf.Value
+		}
+	}
+
+	return Tags{
+		Title:       tag.Title(),
+		Artist:      tag.Artist(),
+		Album:       tag.Album(),
+		AlbumArtist: tag.GetTextFrame("TPE2").Text,
+		TrackNumber: parseNumber(tag.GetTextFrame("TRCK").Text),
+		DiscNumber:  parseNumber(tag.GetTextFrame("TPOS").Text),
+		Year:        parseNumber(tag.Year()),
+		Genre:       parseID3Genre(tag.Genre()),
+		Composer:    tag.GetTextFrame("TCOM").Text,
+		ReplayGain:  parseReplayGain(txxx),
+	}, nil
+}
+
+func readFLACTags(path string) (Tags, error) {
+	stream, err := flac.ParseFile(path)
+	if err != nil {
+		return Tags{}, err
+	}
+	defer stream.Close()
+
+	var comments [][2]string
+	for _, block := range stream.Blocks {
+		if vc, ok := block.Body.(*meta.VorbisComment); ok {
+			comments = append(comments, vc.Tags...)
+		}
+	}
+	return tagsFromVorbisComments(comments), nil
+}
+
+func readOggTags(path string) (Tags, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Tags{}, err
+	}
+	defer f.Close()
+
+	header, err := oggvorbis.GetCommentHeader(f)
+	if err != nil {
+		return Tags{}, err
+	}
+
+	comments := make([][2]string, 0, len(header.Comments))
+	for _, comment := range header.Comments {
+		key, value, ok := strings.Cut(comment, "=")
+		if !ok {
+			continue
+		}
+		comments = append(comments, [2]string{key, value})
+	}
+	return tagsFromVorbisComments(comments), nil
+}
+
+// tagsFromVorbisComments maps Vorbis comment fields to tags. Field names are
+// case-insensitive and the first occurrence of a field wins.
+func tagsFromVorbisComments(comments [][2]string) Tags {
+	fields := make(map[string]string)
+	for _, comment := range comments {
+		key := strings.ToUpper(comment[0])
+		if _, ok := fields[key]; !ok {
+			fields[key] = strings.TrimSpace(comment[1])
+		}
+	}
+
+	albumArtist := fields["ALBUMARTIST"]
+	if albumArtist == "" {
+		albumArtist = fields["ALBUM ARTIST"]
+	}
+
+	return Tags{
+		Title:       fields["TITLE"],
+		Artist:      fields["ARTIST"],
+		Album:       fields["ALBUM"],
+		AlbumArtist: albumArtist,
+		TrackNumber: parseNumber(fields["TRACKNUMBER"]),
+		DiscNumber:  parseNumber(fields["DISCNUMBER"]),
+		Year:        parseNumber(fields["DATE"]),
+		Genre:       fields["GENRE"],
+		Composer:    fields["COMPOSER"],
+		ReplayGain:  parseReplayGain(fields),
+	}
+}
+
+// parseNumber reads the leading number of values such as "3/12" or
+// "1999-05-01", returning 0 when there is none
+func parseNumber(value string) int {
+	value = strings.TrimSpace(value)
+	end := 0
+	for end < len(value) && value[end] >= '0' && value[end] <= '9' {
+		end++
+	}
+	n, _ := strconv.Atoi(value[:end])
+	return n
+}
+
+// parseID3Genre strips the ID3v1 genre references, such as "(17)", that
+// older taggers put in front of or instead of the genre name
+func parseID3Genre(value string) string {
+	for strings.HasPrefix(value, "(") && !strings.HasPrefix(value, "((") {
+		end := strings.IndexByte(value, ')')
+		if end < 0 {
+			break
+		}
+		rest := value[end+1:]
+		if rest == "" {
+			// Only a reference, keep it rather than losing the genre
+			break
+		}
+		value = rest
+	}
+	return strings.TrimSpace(value)
+}
+
+// parseReplayGain reads the REPLAYGAIN_* fields, keyed in upper case
+func parseReplayGain(fields map[string]string) ReplayGain {
+	var rg ReplayGain
+	if gain, ok := parseGain(fields["REPLAYGAIN_TRACK_GAIN"]); ok {
+		rg.TrackGain = gain
+		rg.HasTrack = true
+		rg.TrackPeak, _ = parseGain(fields["REPLAYGAIN_TRACK_PEAK"])
+	}
+	if gain, ok := parseGain(fields["REPLAYGAIN_ALBUM_GAIN"]); ok {
+		rg.AlbumGain = gain
+		rg.HasAlbum = true
+		rg.AlbumPeak, _ = parseGain(fields["REPLAYGAIN_ALBUM_PEAK"])
+	}
+	return rg
+}
+
+// parseGain parses values such as "-6.54 dB" or "0.988312"
+func parseGain(value string) (float64, bool) {
+	value = strings.TrimSpace(value)
+	if len(value) > 2 && strings.EqualFold(value[len(value)-2:], "dB") {
+		value = strings.TrimSpace(value[:len(value)-2])
+	}
+	if value == "" {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, false
+	}
+	return f, true
+}
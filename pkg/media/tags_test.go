@@ -0,0 +1,47 @@
+package media
+
+import "testing"
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{"", 0},
+		{"7", 7},
+		{" 12 ", 12},
+		{"3/12", 3},
+		{"04", 4},
+		{"1999-05-17", 1999},
+		{"2nd", 2},
+		{"A1", 0},
+		{"-1", 0},
+	}
+	for _, tt := range tests {
+		if got := parseNumber(tt.value); got != tt.want {
+			t.Errorf("parseNumber(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseID3Genre(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", ""},
+		{"Jazz", "Jazz"},
+		{" Jazz ", "Jazz"},
+		{"(8)Jazz", "Jazz"},
+		{"(8)(26)Ambient", "Ambient"},
+		{"(17) Rock", "Rock"},
+		{"(17)", "(17)"},
+		{"((Bracketed))", "((Bracketed))"},
+		{"(Unclosed", "(Unclosed"},
+	}
+	for _, tt := range tests {
+		if got := parseID3Genre(tt.value); got != tt.want {
+			t.Errorf("parseID3Genre(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
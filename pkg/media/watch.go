@@ -0,0 +1,104 @@
+package media
+
+import (
+	"context"
+)
+
+// WatchSources keeps the library in sync with the sources that support
+// watching, until the context is cancelled. Sources added while watching are
+// watched too. Changed paths go through the incremental scan, and the ID of
+// each source whose tracks changed is sent on Changes.
+func (m *SourceManager) WatchSources(ctx context.Context) {
+	m.mu.Lock()
+	m.watchCtx = ctx
+	ids := make([]string, 0, len(m.sources))
+	for id := range m.sources {
+		ids = append(ids, id)
+	}
+	m.mu.Unlock()
+
+	for _, id := range ids {
+		m.watchSource(ctx, id)
+	}
+
+	<-ctx.Done()
+	m.mu.Lock()
+	m.watchCtx = nil
+	clear(m.watchCancels)
+	m.mu.Unlock()
+	m.watchers.Wait()
+}
+
+// Changes returns a channel receiving the ID of a source when watching
+// updated its tracks
+func (m *SourceManager) Changes() <-chan string {
+	return m.changes
+}
+
+// watchSource starts watching a source in the background when it supports it
+func (m *SourceManager) watchSource(ctx context.Context, sourceID string) {
+	m.mu.Lock()
+	source, ok := m.sources[sourceID].(WatchableSource)
+	if !ok {
+		m.mu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	m.watchCancels[sourceID] = cancel
+	m.mu.Unlock()
+
+	changes := make(chan []string)
+	m.watchers.Add(2)
+	go func() {
+		defer m.watchers.Done()
+		defer close(changes)
+		// Watching is best effort, the manual rescan still works without it
+		_ = source.Watch(ctx, changes)
+	}()
+	go func() {
+		defer m.watchers.Done()
+		for paths := range changes {
+			m.scanChanges(ctx, sourceID, source, paths)
+		}
+	}()
+}
+
+// unwatchSource stops watching a source, the caller holds m.mu
+func (m *SourceManager) unwatchSource(sourceID string) {
+	if cancel, ok := m.watchCancels[sourceID]; ok {
+		cancel()
+		delete(m.watchCancels, sourceID)
+	}
+}
+
+// scanChanges rescans the changed paths of a source
+func (m *SourceManager) scanChanges(
+	ctx context.Context,
+	sourceID string,
+	source WatchableSource,
+	paths []string,
+) {
+	run := func(
+		ctx context.Context,
+		known map[string]FileState,
+		tracks chan<- Track,
+		onFile func(path string, unchanged bool),
+		onIssue func(issue ScanIssue),
+	) error {
+		return source.ScanPaths(ctx, paths, known, tracks, onFile, onIssue)
+	}
+	result, err := m.runScan(ctx, sourceID, "Updating...", run, paths)
+	if err != nil {
+		return
+	}
+	if result.Added+result.Updated+result.Moved+result.Removed == 0 {
+		return
+	}
+
+	// Never block on a listener that is not keeping up, it reloads
+	// everything on the next change anyway
+	select {
+	case m.changes <- sourceID:
+	default:
+	}
+}
@@ -0,0 +1,235 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fakeWatchable is a fake source of files rescanned by path
+type fakeWatchable struct {
+	fakeSource
+	files map[string]fakeFile
+}
+
+func (fakeWatchable) Watch(ctx context.Context, _ chan<- []string) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (s fakeWatchable) ScanPaths(
+	ctx context.Context,
+	paths []string,
+	known map[string]FileState,
+	tracks chan<- Track,
+	onFile func(path string, unchanged bool),
+	onIssue func(issue ScanIssue),
+) error {
+	files := make(map[string]fakeFile)
+	for path, file := range s.files {
+		if isUnderAny(path, paths) {
+			files[path] = file
+		}
+	}
+	var unreadable []string
+	return scanTree(files, &unreadable)(ctx, known, tracks, onFile, onIssue)
+}
+
+func TestScanChangedPaths(t *testing.T) {
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := &memStore{states: map[string]FileState{
+		"/music/a/1.mp3": {TrackID: "id:/music/a/1.mp3", ModTime: day, Size: 100},
+		"/music/a/2.mp3": {TrackID: "id:/music/a/2.mp3", ModTime: day, Size: 200},
+		"/music/b/3.mp3": {TrackID: "id:/music/b/3.mp3", ModTime: day, Size: 300},
+	}}
+	// Both 2.mp3 and 3.mp3 are gone, only the changes under a are reported
+	source := fakeWatchable{files: map[string]fakeFile{
+		"/music/a/1.mp3": {day, 100},
+	}}
+	m := NewSourceManager(store)
+	m.sources["src"] = source
+
+	m.scanChanges(context.Background(), "src", source, []string{"/music/a"})
+	if want := []string{"id:/music/a/2.mp3"}; !reflect.DeepEqual(store.removed, want) {
+		t.Errorf("removed = %v, want %v", store.removed, want)
+	}
+	if _, ok := store.states["/music/b/3.mp3"]; !ok {
+		t.Error("the track outside the changed paths was removed")
+	}
+	select {
+	case id := <-m.Changes():
+		if id != "src" {
+			t.Errorf("changed source = %q, want src", id)
+		}
+	default:
+		t.Error("no change sent after removing a track")
+	}
+
+	// Nothing is sent when the rescan changed nothing
+	m.scanChanges(context.Background(), "src", source, []string{"/music/a"})
+	select {
+	case id := <-m.Changes():
+		t.Errorf("change sent for %q after an unchanged rescan", id)
+	default:
+	}
+}
+
+// startBatcher runs a batcher of the changes under root until the end of
+// the test, and returns its input and output channels and the directories it
+// started watching
+func startBatcher(t *testing.T, root string, debounce, maxDelay time.Duration) (
+	chan fsnotify.Event, chan error, chan []string, *[]string,
+) {
+	t.Helper()
+	events := make(chan fsnotify.Event)
+	errs := make(chan error)
+	changes := make(chan []string)
+	var watched []string
+	batcher := changeBatcher{
+		roots:    []string{root},
+		debounce: debounce,
+		maxDelay: maxDelay,
+		isAudioFile: func(path string) bool {
+			return strings.HasSuffix(path, ".mp3")
+		},
+		watchDir: func(dir string) error {
+			watched = append(watched, dir)
+			return nil
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		batcher.run(ctx, events, errs, changes)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return events, errs, changes, &watched
+}
+
+// receiveBatch waits for a batch of changes
+func receiveBatch(t *testing.T, changes <-chan []string) []string {
+	t.Helper()
+	select {
+	case paths := <-changes:
+		return paths
+	case <-time.After(5 * time.Second):
+		t.Fatal("no batch of changes")
+		return nil
+	}
+}
+
+func TestChangeBatcher(t *testing.T) {
+	root := t.TempDir()
+	for _, path := range []string{"album/01.mp3", "album/cover.jpg", "new/02.mp3"} {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events, errs, changes, watched := startBatcher(t, root, 50*time.Millisecond, time.Minute)
+
+	for _, event := range []fsnotify.Event{
+		{Name: filepath.Join(root, "album/01.mp3"), Op: fsnotify.Write},
+		{Name: filepath.Join(root, "album/01.mp3"), Op: fsnotify.Write},
+		// Attribute changes and other files are ignored
+		{Name: filepath.Join(root, "album/03.mp3"), Op: fsnotify.Chmod},
+		{Name: filepath.Join(root, "album/cover.jpg"), Op: fsnotify.Write},
+		// A new directory is watched and covers the files inside it
+		{Name: filepath.Join(root, "new"), Op: fsnotify.Create},
+		{Name: filepath.Join(root, "new/02.mp3"), Op: fsnotify.Create},
+		// Removed paths may have been directories
+		{Name: filepath.Join(root, "gone"), Op: fsnotify.Remove},
+	} {
+		events <- event
+	}
+	want := []string{
+		filepath.Join(root, "album/01.mp3"),
+		filepath.Join(root, "gone"),
+		filepath.Join(root, "new"),
+	}
+	if got := receiveBatch(t, changes); !reflect.DeepEqual(got, want) {
+		t.Errorf("batch = %v, want %v", got, want)
+	}
+	if want := []string{filepath.Join(root, "new")}; !reflect.DeepEqual(*watched, want) {
+		t.Errorf("watched = %v, want %v", *watched, want)
+	}
+
+	// Dropped events rescan the roots, other errors are ignored
+	errs <- errors.New("watch limit reached")
+	errs <- fsnotify.ErrEventOverflow
+	if got := receiveBatch(t, changes); !reflect.DeepEqual(got, []string{root}) {
+		t.Errorf("batch after an overflow = %v, want %v", got, []string{root})
+	}
+}
+
+func TestChangeBatcherMaxDelay(t *testing.T) {
+	root := t.TempDir()
+	const debounce, maxDelay = 100 * time.Millisecond, 300 * time.Millisecond
+	events, _, changes, _ := startBatcher(t, root, debounce, maxDelay)
+
+	// Changes keep coming faster than the debounce, the batch is sent after
+	// the maximum delay anyway
+	start := time.Now()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			event := fsnotify.Event{Name: filepath.Join(root, "burst.mp3"), Op: fsnotify.Write}
+			select {
+			case events <- event:
+			case <-stop:
+				return
+			}
+			time.Sleep(debounce / 5)
+		}
+	}()
+	got := receiveBatch(t, changes)
+	if elapsed := time.Since(start); elapsed < maxDelay || elapsed > 3*maxDelay {
+		t.Errorf("batch sent after %v, want about %v", elapsed, maxDelay)
+	}
+	if want := []string{filepath.Join(root, "burst.mp3")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("batch = %v, want %v", got, want)
+	}
+}
+
+func TestCollapsePaths(t *testing.T) {
+	tests := []struct {
+		paths []string
+		want  []string
+	}{
+		{nil, nil},
+		{[]string{"/music/b.mp3", "/music/a.mp3"}, []string{"/music/a.mp3", "/music/b.mp3"}},
+		{
+			[]string{"/music/album/01.mp3", "/music/album", "/music/album/cd2/01.mp3"},
+			[]string{"/music/album"},
+		},
+		// A shared name prefix is not a parent directory
+		{
+			[]string{"/music/album 2/01.mp3", "/music/album"},
+			[]string{"/music/album", "/music/album 2/01.mp3"},
+		},
+	}
+	for _, tt := range tests {
+		set := make(map[string]bool)
+		for _, path := range tt.paths {
+			set[path] = true
+		}
+		if got := collapsePaths(set); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("collapsePaths(%v) = %v, want %v", tt.paths, got, tt.want)
+		}
+	}
+}
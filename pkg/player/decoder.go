@@ -0,0 +1,168 @@
+package player
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gopxl/beep/v2"
+	"github.com/gopxl/beep/v2/flac"
+	"github.com/gopxl/beep/v2/mp3"
+	"github.com/gopxl/beep/v2/vorbis"
+	"github.com/gopxl/beep/v2/wav"
+)
+
+// headerSize is the number of bytes read from the start of a file to detect
+// its format
+const headerSize = 12
+
+// DecodeFunc decodes an opened audio file. The returned streamer takes
+// ownership of the file and closes it when it is closed.
+type DecodeFunc func(f *os.File) (beep.StreamSeekCloser, beep.Format, error)
+
+// Decoder describes how to recognize and decode an audio format
+type Decoder struct {
+	// Name is a human-readable format name
+	Name string
+	// Extensions lists the lowercase file extensions handled, including the dot
+	Extensions []string
+	// Match reports whether the file header belongs to this format
+	Match func(header []byte) bool
+	// Decode decodes the file
+	Decode DecodeFunc
+}
+
+var (
+	decodersMu sync.RWMutex
+	decoders   []Decoder
+)
+
+func init() {
+	RegisterDecoder(Decoder{
+		Name:       "MP3",
+		Extensions: []string{".mp3"},
+		Match:      isMP3,
+		Decode: func(f *os.File) (beep.StreamSeekCloser, beep.Format, error) {
+			return mp3.Decode(f)
+		},
+	})
+	RegisterDecoder(Decoder{
+		Name:       "FLAC",
+		Extensions: []string{".flac"},
+		Match: func(header []byte) bool {
+			return bytes.HasPrefix(header, []byte("fLaC"))
+		},
+		Decode: func(f *os.File) (beep.StreamSeekCloser, beep.Format, error) {
+			return flac.Decode(f)
+		},
+	})
+	RegisterDecoder(Decoder{
+		Name:       "Ogg Vorbis",
+		Extensions: []string{".ogg", ".oga"},
+		Match: func(header []byte) bool {
+			return bytes.HasPrefix(header, []byte("OggS"))
+		},
+		Decode: func(f *os.File) (beep.StreamSeekCloser, beep.Format, error) {
+			return vorbis.Decode(f)
+		},
+	})
+	RegisterDecoder(Decoder{
+		Name:       "WAV",
+		Extensions: []string{".wav"},
+		Match: func(header []byte) bool {
+			return len(header) >= 12 &&
+				bytes.Equal(header[0:4], []byte("RIFF")) &&
+				bytes.Equal(header[8:12], []byte("WAVE"))
+		},
+		Decode: func(f *os.File) (beep.StreamSeekCloser, beep.Format, error) {
+			return wav.Decode(f)
+		},
+	})
+}
+
+// isMP3 matches an ID3v2 tag or an MPEG audio frame sync
+func isMP3(header []byte) bool {
+	if bytes.HasPrefix(header, []byte("ID3")) {
+		return true
+	}
+	return len(header) >= 2 && header[0] == 0xFF && header[1]&0xE0 == 0xE0
+}
+
+// RegisterDecoder adds a decoder to the registry. Decoders registered later
+// take precedence over earlier ones for the same extension.
+func RegisterDecoder(d Decoder) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	decoders = append([]Decoder{d}, decoders...)
+}
+
+// SupportedExtensions returns the file extensions of all registered decoders
+func SupportedExtensions() []string {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+
+	var exts []string
+	for _, d := range decoders {
+		exts = append(exts, d.Extensions...)
+	}
+	return exts
+}
+
+// findDecoder picks a decoder from the file's magic bytes, falling back to its
+// extension when the header is not recognized
+func findDecoder(path string, header []byte) (Decoder, bool) {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+
+	for _, d := range decoders {
+		if d.Match != nil && d.Match(header) {
+			return d, true
+		}
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, d := range decoders {
+		for _, e := range d.Extensions {
+			if e == ext {
+				return d, true
+			}
+		}
+	}
+	return Decoder{}, false
+}
+
+// Decode opens and decodes the audio file at path with the matching decoder
+func Decode(path string) (beep.StreamSeekCloser, beep.Format, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, beep.Format{}, err
+	}
+
+	header := make([]byte, headerSize)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		f.Close()
+		return nil, beep.Format{}, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, beep.Format{}, err
+	}
+
+	decoder, ok := findDecoder(path, header[:n])
+	if !ok {
+		f.Close()
+		return nil, beep.Format{}, fmt.Errorf("unsupported audio format: %s", path)
+	}
+
+	streamer, format, err := decoder.Decode(f)
+	if err != nil {
+		f.Close()
+		return nil, beep.Format{}, fmt.Errorf("failed to decode %s: %w", decoder.Name, err)
+	}
+	return streamer, format, nil
+}
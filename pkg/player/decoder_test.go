@@ -0,0 +1,54 @@
+package player
+
+import "testing"
+
+func TestFindDecoder(t *testing.T) {
+	tests := []struct {
+		path   string
+		header []byte
+		want   string
+	}{
+		// The magic bytes win over the extension
+		{"song.mp3", []byte("ID3\x04\x00"), "MP3"},
+		{"song.mp3", []byte{0xFF, 0xFB, 0x90, 0x00}, "MP3"},
+		{"song.flac", []byte("fLaC\x00\x00\x00\x22"), "FLAC"},
+		{"song.mp3", []byte("fLaC\x00\x00\x00\x22"), "FLAC"},
+		{"song.ogg", []byte("OggS\x00\x02"), "Ogg Vorbis"},
+		{"song.oga", []byte("OggS\x00\x02"), "Ogg Vorbis"},
+		{"song.wav", []byte("RIFF\x24\x08\x00\x00WAVE"), "WAV"},
+		{"song.flac", []byte("RIFF\x24\x08\x00\x00WAVE"), "WAV"},
+		// Unrecognized headers fall back to the extension
+		{"song.flac", []byte("junk"), "FLAC"},
+		{"SONG.OGG", nil, "Ogg Vorbis"},
+		{"song.wav", []byte("RIFF\x24\x08\x00\x00AVI "), "WAV"},
+		{"song.mp3", []byte{0xFF, 0x00}, "MP3"},
+		{"song.aac", []byte("junk"), ""},
+		{"song", nil, ""},
+	}
+	for _, tt := range tests {
+		got, ok := findDecoder(tt.path, tt.header)
+		if ok != (tt.want != "") || got.Name != tt.want {
+			t.Errorf("findDecoder(%q, %q) = %q, %t, want %q", tt.path, tt.header, got.Name, ok, tt.want)
+		}
+	}
+}
+
+func TestIsMP3(t *testing.T) {
+	tests := []struct {
+		header []byte
+		want   bool
+	}{
+		{[]byte("ID3"), true},
+		{[]byte{0xFF, 0xFB}, true},
+		{[]byte{0xFF, 0xE2}, true},
+		{[]byte{0xFF, 0xD0}, false},
+		{[]byte{0xFF}, false},
+		{[]byte("fLaC"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := isMP3(tt.header); got != tt.want {
+			t.Errorf("isMP3(% x) = %t, want %t", tt.header, got, tt.want)
+		}
+	}
+}
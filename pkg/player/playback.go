@@ -0,0 +1,115 @@
+package player
+
+import (
+	"github.com/gopxl/beep/v2"
+	"github.com/gopxl/beep/v2/effects"
+)
+
+// playback is the streamer fed to the speaker. It plays the current track,
+// moves on to the preloaded next one on the exact sample where the current one
+// ends, and mixes in the tails of tracks fading out during a crossfade.
+//
+// All its methods run with the speaker locked.
+type playback struct {
+	p      *Player
+	fades  beep.Mixer
+	fading []*loadedTrack
+	buf    [][2]float64
+}
+
+func (s *playback) Stream(samples [][2]float64) (n int, ok bool) {
+	p := s.p
+	// Tails fading out are mixed from the sample where they started
+	mixed := 0
+	for n < len(samples) && p.current != nil && !p.current.drained {
+		chunk := samples[n:]
+
+		// Stop at the fade point so the crossfade starts on time
+		if until, fade := p.untilCrossfade(); fade {
+			if until <= 0 {
+				s.mixFades(samples[mixed:n])
+				mixed = n
+				s.startCrossfade()
+				continue
+			}
+			chunk = chunk[:min(until, len(chunk))]
+		}
+
+		sn, sok := p.current.stream.Stream(chunk)
+		n += sn
+		if !sok {
+			p.advance()
+		} else if sn == 0 {
+			break
+		}
+	}
+	clear(samples[n:])
+
+	s.mixFades(samples[mixed:])
+	return len(samples), true
+}
+
+// mixFades adds the fading tails on top of the samples
+func (s *playback) mixFades(samples [][2]float64) {
+	if s.fades.Len() == 0 || len(samples) == 0 {
+		return
+	}
+	if cap(s.buf) < len(samples) {
+		s.buf = make([][2]float64, len(samples))
+	}
+	buf := s.buf[:len(samples)]
+	s.fades.Stream(buf)
+	for i := range samples {
+		samples[i][0] += buf[i][0]
+		samples[i][1] += buf[i][1]
+	}
+}
+
+// startCrossfade fades the current track out in the background and fades the
+// next one in as the new current track
+func (s *playback) startCrossfade() {
+	p := s.p
+	length := min(p.outputRate.N(p.crossfade), p.current.remaining(p.outputRate))
+	if length <= 0 {
+		p.advance()
+		return
+	}
+
+	old := p.current
+	s.fading = append(s.fading, old)
+	s.fades.Add(beep.Seq(
+		beep.Take(length, effects.Transition(
+			old.stream, length, 1, 0, effects.TransitionEqualPower,
+		)),
+		beep.Callback(func() { s.release(old) }),
+	))
+
+	p.next.stream = effects.Transition(
+		p.next.stream, length, 0, 1, effects.TransitionEqualPower,
+	)
+	p.promoteNext()
+}
+
+// release closes a track once its fade out is over
+func (s *playback) release(track *loadedTrack) {
+	for i, t := range s.fading {
+		if t == track {
+			s.fading = append(s.fading[:i], s.fading[i+1:]...)
+			break
+		}
+	}
+	track.close()
+}
+
+// close releases the tracks still fading out
+func (s *playback) close() {
+	for _, t := range s.fading {
+		t.close()
+	}
+	s.fading = nil
+	s.fades.Clear()
+}
+
+func (s *playback) Err() error {
+	return nil
+}
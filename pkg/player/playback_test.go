@@ -0,0 +1,224 @@
+package player
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gopxl/beep/v2"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+)
+
+// testRate is the output rate of the test players, a sample per millisecond
+const testRate = beep.SampleRate(1000)
+
+// memStreamer streams samples held in memory
+type memStreamer struct {
+	samples [][2]float64
+	pos     int
+	closed  bool
+}
+
+func (s *memStreamer) Stream(samples [][2]float64) (int, bool) {
+	if s.pos >= len(s.samples) {
+		return 0, false
+	}
+	n := copy(samples, s.samples[s.pos:])
+	s.pos += n
+	return n, true
+}
+
+func (s *memStreamer) Err() error       { return nil }
+func (s *memStreamer) Len() int         { return len(s.samples) }
+func (s *memStreamer) Position() int    { return s.pos }
+func (s *memStreamer) Seek(p int) error { s.pos = p; return nil }
+func (s *memStreamer) Close() error     { s.closed = true; return nil }
+
+// newTestTrack loads samples as a track playing at the test rate
+func newTestTrack(track media.Track, samples [][2]float64) *loadedTrack {
+	streamer := &memStreamer{samples: samples}
+	return &loadedTrack{
+		track:      track,
+		streamer:   streamer,
+		stream:     streamer,
+		length:     len(samples),
+		sampleRate: testRate,
+	}
+}
+
+// newTestPlayer returns a player streaming current then next without a
+// speaker
+func newTestPlayer(current, next *loadedTrack) *Player {
+	p := New(Config{SampleRate: testRate})
+	p.current = current
+	p.next = next
+	p.playback = &playback{p: p}
+	return p
+}
+
+// ramp returns n samples counting up from start on the left channel and down
+// on the right one
+func ramp(start, n int) [][2]float64 {
+	samples := make([][2]float64, n)
+	for i := range samples {
+		samples[i] = [2]float64{float64(start + i), -float64(start + i)}
+	}
+	return samples
+}
+
+// streamAll reads n samples from the playback in chunks of the given size
+func streamAll(t *testing.T, p *Player, n, chunk int) [][2]float64 {
+	t.Helper()
+	out := make([][2]float64, 0, n)
+	for len(out) < n {
+		samples := make([][2]float64, min(chunk, n-len(out)))
+		sn, ok := p.playback.Stream(samples)
+		if sn != len(samples) || !ok {
+			t.Fatalf("Stream = %d, %t, want %d, true", sn, ok, len(samples))
+		}
+		out = append(out, samples...)
+	}
+	return out
+}
+
+// receiveEnds returns the track ends reported so far
+func receiveEnds(p *Player) []TrackEnd {
+	var ends []TrackEnd
+	for {
+		select {
+		case end := <-p.Ended():
+			ends = append(ends, end)
+		default:
+			return ends
+		}
+	}
+}
+
+func TestPlaybackGapless(t *testing.T) {
+	first := media.Track{ID: "first", Album: "Kind of Blue"}
+	second := media.Track{ID: "second", Album: "Blue Train"}
+	for _, chunk := range []int{1, 7, 50, 128} {
+		current := newTestTrack(first, ramp(0, 50))
+		next := newTestTrack(second, ramp(50, 30))
+		p := newTestPlayer(current, next)
+
+		// The next track follows on the sample after the last one, then
+		// silence
+		out := streamAll(t, p, 100, chunk)
+		for i, sample := range out {
+			want := [2]float64{}
+			if i < 80 {
+				want = [2]float64{float64(i), -float64(i)}
+			}
+			if sample != want {
+				t.Fatalf("chunks of %d: sample %d = %v, want %v", chunk, i, sample, want)
+			}
+		}
+
+		ends := receiveEnds(p)
+		want := []TrackEnd{{Track: first, Continued: true}, {Track: second}}
+		if len(ends) != len(want) || ends[0] != want[0] || ends[1] != want[1] {
+			t.Errorf("chunks of %d: ends = %+v, want %+v", chunk, ends, want)
+		}
+		if !current.streamer.(*memStreamer).closed {
+			t.Errorf("chunks of %d: the first track was not closed", chunk)
+		}
+		if p.current != next || !next.drained {
+			t.Errorf("chunks of %d: the second track is not current and drained", chunk)
+		}
+	}
+}
+
+func TestPlaybackCrossfade(t *testing.T) {
+	tests := []struct {
+		name          string
+		first, second media.Track
+		crossfade     time.Duration
+		// fadeIn is the sample where the second track starts
+		fadeIn int
+	}{
+		{
+			name:      "other album",
+			first:     media.Track{ID: "first", Artist: "Miles Davis", Album: "Kind of Blue"},
+			second:    media.Track{ID: "second", Artist: "John Coltrane", Album: "Blue Train"},
+			crossfade: 20 * time.Millisecond,
+			fadeIn:    80,
+		},
+		{
+			name:      "same album",
+			first:     media.Track{ID: "first", Artist: "Miles Davis", Album: "Kind of Blue"},
+			second:    media.Track{ID: "second", Artist: "Miles Davis", Album: "Kind of Blue"},
+			crossfade: 20 * time.Millisecond,
+			fadeIn:    100,
+		},
+		{
+			name:      "longer than the track",
+			first:     media.Track{ID: "first", Album: "Kind of Blue"},
+			second:    media.Track{ID: "second", Album: "Blue Train"},
+			crossfade: 150 * time.Millisecond,
+			fadeIn:    0,
+		},
+		{
+			name:   "gapless",
+			first:  media.Track{ID: "first", Album: "Kind of Blue"},
+			second: media.Track{ID: "second", Album: "Blue Train"},
+			fadeIn: 100,
+		},
+	}
+	for _, tt := range tests {
+		// The first track plays on the left channel, the second on the right
+		current := newTestTrack(tt.first, constant(100, [2]float64{1, 0}))
+		next := newTestTrack(tt.second, constant(100, [2]float64{0, 1}))
+		p := newTestPlayer(current, next)
+		p.crossfade = tt.crossfade
+
+		out := streamAll(t, p, 300, 16)
+		fadeOut, lastLeft, firstRight := -1, -1, -1
+		for i, sample := range out {
+			if sample[0] != 0 {
+				lastLeft = i
+				if fadeOut < 0 && sample[0] < 1 {
+					fadeOut = i
+				}
+			}
+			if sample[1] != 0 && firstRight < 0 {
+				firstRight = i
+			}
+		}
+		if lastLeft != 99 {
+			t.Errorf("%s: the first track ends on sample %d, want 99", tt.name, lastLeft)
+		}
+		if firstRight != tt.fadeIn {
+			t.Errorf("%s: the second track starts on sample %d, want %d", tt.name, firstRight, tt.fadeIn)
+		}
+		// The first track fades out over the crossfade only
+		if crossfaded := tt.fadeIn < 100; crossfaded && fadeOut != tt.fadeIn || !crossfaded && fadeOut >= 0 {
+			t.Errorf("%s: the first track fades out from sample %d", tt.name, fadeOut)
+		}
+		for i := 100; i < tt.fadeIn+100; i++ {
+			if out[i] != [2]float64{0, 1} {
+				t.Fatalf("%s: sample %d = %v, want the second track at full level", tt.name, i, out[i])
+			}
+		}
+		if out[tt.fadeIn+100] != [2]float64{} {
+			t.Errorf("%s: sample %d = %v, want silence after the second track", tt.name, tt.fadeIn+100, out[tt.fadeIn+100])
+		}
+
+		if !current.streamer.(*memStreamer).closed {
+			t.Errorf("%s: the first track was not closed", tt.name)
+		}
+		ends := receiveEnds(p)
+		if len(ends) != 2 || ends[0].Track.ID != "first" || !ends[0].Continued {
+			t.Errorf("%s: ends = %+v, want the first track continued", tt.name, ends)
+		}
+	}
+}
+
+// constant returns n samples of the same value
+func constant(n int, sample [2]float64) [][2]float64 {
+	samples := make([][2]float64, n)
+	for i := range samples {
+		samples[i] = sample
+	}
+	return samples
+}
@@ -1,105 +1,450 @@
 package player
 
 import (
-	"os"
+	"fmt"
+	"sync"
 	"time"
 
-	"github.com/bogem/id3v2/v2"
 	"github.com/gopxl/beep/v2"
-	"github.com/gopxl/beep/v2/mp3"
+	"github.com/gopxl/beep/v2/effects"
 	"github.com/gopxl/beep/v2/speaker"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+)
+
+const (
+	// resampleQuality is the beep.Resample quality used when a track's sample
+	// rate differs from the output rate
+	resampleQuality = 4
+	// MaxCrossfade is the longest supported crossfade between two tracks
+	MaxCrossfade = 12 * time.Second
+	// DefaultSampleRate is the default output sample rate
+	DefaultSampleRate = beep.SampleRate(44100)
+	// DefaultBufferSize is the default output buffer duration
+	DefaultBufferSize = 100 * time.Millisecond
+	// volumeRange is the attenuation, as a power of two, at the lowest
+	// non-zero volume level
+	volumeRange = 8
 )
 
+// Config configures the audio output
+type Config struct {
+	// SampleRate is the fixed output rate every track is resampled to
+	SampleRate beep.SampleRate
+	// BufferSize trades latency (small) for stable output (large)
+	BufferSize time.Duration
+}
+
+// DefaultConfig returns the default output configuration
+func DefaultConfig() Config {
+	return Config{
+		SampleRate: DefaultSampleRate,
+		BufferSize: DefaultBufferSize,
+	}
+}
+
+var (
+	speakerOnce sync.Once
+	speakerErr  error
+)
+
+// initSpeaker opens the output device. The speaker can only be initialized
+// once per process, so the first configuration wins.
+func initSpeaker(config Config) error {
+	speakerOnce.Do(func() {
+		bufferSize := config.SampleRate.N(config.BufferSize)
+		if err := speaker.Init(config.SampleRate, bufferSize); err != nil {
+			speakerErr = fmt.Errorf("failed to initialize audio output: %w", err)
+		}
+	})
+	return speakerErr
+}
+
 type Metadata struct {
 	Artist string
 	Title  string
+	Album  string
 }
 
-type Player struct {
-	streamer   beep.StreamSeekCloser
-	ctrl       *beep.Ctrl
+// TrackEnd reports a track that played to its end
+type TrackEnd struct {
+	Track media.Track
+	// Continued is true when playback moved on to the preloaded next track
+	Continued bool
+}
+
+// loadedTrack is a decoded track ready to be streamed
+type loadedTrack struct {
+	track    media.Track
+	streamer beep.StreamSeekCloser
+	// stream is the streamer converted to the output sample rate, with its
+	// ReplayGain applied
+	stream     beep.Streamer
+	gain       *effects.Gain
 	length     int
 	sampleRate beep.SampleRate
-	metadata   Metadata
+	// drained is set once the track played to its end with nothing after it
+	drained bool
 }
 
-func New() *Player {
-	return &Player{}
+func (t *loadedTrack) close() {
+	t.streamer.Close()
 }
 
-func (p *Player) Play(filepath string) error {
-	// Read metadata first
-	tag, err := id3v2.Open(filepath, id3v2.Options{Parse: true})
-	if err == nil {
-		p.metadata = Metadata{
-			Artist: tag.Artist(),
-			Title:  tag.Title(),
-		}
-		tag.Close()
+// remaining returns the number of samples left to play at the given rate
+func (t *loadedTrack) remaining(rate beep.SampleRate) int {
+	left := t.length - t.streamer.Position()
+	return int(float64(left) * float64(rate) / float64(t.sampleRate))
+}
+
+type Player struct {
+	ctrl       *beep.Ctrl
+	volume     *effects.Volume
+	level      float64
+	muted      bool
+	playback   *playback
+	current    *loadedTrack
+	next       *loadedTrack
+	outputRate beep.SampleRate
+	config     Config
+	crossfade  time.Duration
+	rgMode     ReplayGainMode
+	ended      chan TrackEnd
+}
+
+func New(config Config) *Player {
+	if config.SampleRate <= 0 {
+		config.SampleRate = DefaultSampleRate
+	}
+	if config.BufferSize <= 0 {
+		config.BufferSize = DefaultBufferSize
 	}
+	return &Player{
+		volume:     &effects.Volume{Base: 2},
+		level:      1,
+		outputRate: config.SampleRate,
+		config:     config,
+		ended:      make(chan TrackEnd, 4),
+	}
+}
 
-	f, err := os.Open(filepath)
+// load decodes a track and resamples it to the fixed output rate
+func (p *Player) load(track media.Track, outputRate beep.SampleRate) (*loadedTrack, error) {
+	streamer, format, err := Decode(track.Path)
 	if err != nil {
+		return nil, err
+	}
+
+	var stream beep.Streamer = streamer
+	if format.SampleRate != outputRate {
+		stream = beep.Resample(resampleQuality, format.SampleRate, outputRate, streamer)
+	}
+	gain := &effects.Gain{Streamer: stream}
+
+	return &loadedTrack{
+		track:      track,
+		streamer:   streamer,
+		stream:     gain,
+		gain:       gain,
+		length:     streamer.Len(),
+		sampleRate: format.SampleRate,
+	}, nil
+}
+
+// Play starts playing a track, replacing the one currently playing
+func (p *Player) Play(track media.Track) error {
+	if err := initSpeaker(p.config); err != nil {
 		return err
 	}
 
-	streamer, format, err := mp3.Decode(f)
+	current, err := p.load(track, p.outputRate)
 	if err != nil {
-		f.Close()
 		return err
 	}
 
-	speaker.Init(format.SampleRate, format.SampleRate.N(time.Second/10))
+	p.Close()
 
-	p.streamer = streamer
-	p.ctrl = &beep.Ctrl{Streamer: streamer}
-	p.length = streamer.Len()
-	p.sampleRate = format.SampleRate
+	speaker.Lock()
+	p.applyReplayGain(current)
+	p.current = current
+	p.playback = &playback{p: p}
+	p.ctrl = &beep.Ctrl{Streamer: p.playback}
+	p.volume.Streamer = p.ctrl
+	speaker.Unlock()
 
-	speaker.Play(p.ctrl)
+	speaker.Play(p.volume)
 	return nil
 }
 
+// SetNext decodes the track to play after the current one, so the switch
+// happens without any gap
+func (p *Player) SetNext(track media.Track) error {
+	if !p.Loaded() {
+		return nil
+	}
+
+	next, err := p.load(track, p.outputRate)
+	if err != nil {
+		return err
+	}
+
+	speaker.Lock()
+	defer speaker.Unlock()
+	if p.current == nil {
+		next.close()
+		return nil
+	}
+	if p.next != nil {
+		p.next.close()
+	}
+	p.applyReplayGain(next)
+	p.next = next
+	return nil
+}
+
+// ClearNext drops the preloaded next track, playback stops after the current one
+func (p *Player) ClearNext() {
+	speaker.Lock()
+	defer speaker.Unlock()
+	if p.next == nil {
+		return
+	}
+	p.next.close()
+	p.next = nil
+}
+
+// SetCrossfade sets how long consecutive tracks overlap, zero plays them
+// gaplessly. Tracks from the same album are never crossfaded.
+func (p *Player) SetCrossfade(d time.Duration) {
+	speaker.Lock()
+	defer speaker.Unlock()
+	p.crossfade = max(0, min(d, MaxCrossfade))
+}
+
+// Crossfade returns the crossfade duration
+func (p *Player) Crossfade() time.Duration {
+	speaker.Lock()
+	defer speaker.Unlock()
+	return p.crossfade
+}
+
+// sameAlbum reports whether two tracks belong to the same album, in which
+// case they are played gaplessly instead of crossfaded. Albums of different
+// artists may share a title.
+func sameAlbum(a, b media.Track) bool {
+	return a.Album != "" && a.Album == b.Album && albumArtist(a) == albumArtist(b)
+}
+
+// albumArtist returns the album artist of a track, its artist when untagged
+func albumArtist(track media.Track) string {
+	if track.AlbumArtist != "" {
+		return track.AlbumArtist
+	}
+	return track.Artist
+}
+
+// untilCrossfade returns how many output samples remain before the crossfade
+// into the next track must start, and false when no crossfade applies
+func (p *Player) untilCrossfade() (int, bool) {
+	if p.next == nil || p.crossfade <= 0 || sameAlbum(p.current.track, p.next.track) {
+		return 0, false
+	}
+	return p.current.remaining(p.outputRate) - p.outputRate.N(p.crossfade), true
+}
+
+// advance runs on the audio goroutine, with the speaker locked, when the
+// current track runs out
+func (p *Player) advance() {
+	if p.next == nil {
+		p.current.drained = true
+		p.notify(TrackEnd{Track: p.current.track})
+		return
+	}
+	p.current.close()
+	p.promoteNext()
+}
+
+// promoteNext makes the preloaded track the current one
+func (p *Player) promoteNext() {
+	ended := TrackEnd{Track: p.current.track, Continued: true}
+	p.current = p.next
+	p.next = nil
+	p.notify(ended)
+}
+
+func (p *Player) notify(ended TrackEnd) {
+	// Never block the audio goroutine
+	select {
+	case p.ended <- ended:
+	default:
+	}
+}
+
+// Ended returns a channel receiving each track that played to its end
+func (p *Player) Ended() <-chan TrackEnd {
+	return p.ended
+}
+
+// Loaded reports whether a track is loaded in the player
+func (p *Player) Loaded() bool {
+	speaker.Lock()
+	defer speaker.Unlock()
+	return p.ctrl != nil
+}
+
+// Track returns the track currently playing
+func (p *Player) Track() media.Track {
+	speaker.Lock()
+	defer speaker.Unlock()
+	if p.current == nil {
+		return media.Track{}
+	}
+	return p.current.track
+}
+
+// SetVolume sets the volume level, from 0 (silent) to 1 (full)
+func (p *Player) SetVolume(level float64) {
+	speaker.Lock()
+	defer speaker.Unlock()
+	p.level = max(0, min(level, 1))
+	p.applyVolume()
+}
+
+// Volume returns the volume level, from 0 to 1
+func (p *Player) Volume() float64 {
+	speaker.Lock()
+	defer speaker.Unlock()
+	return p.level
+}
+
+// ToggleMute mutes or unmutes the output, keeping the volume level
+func (p *Player) ToggleMute() {
+	speaker.Lock()
+	defer speaker.Unlock()
+	p.muted = !p.muted
+	p.applyVolume()
+}
+
+// Muted reports whether the output is muted
+func (p *Player) Muted() bool {
+	speaker.Lock()
+	defer speaker.Unlock()
+	return p.muted
+}
+
+// applyVolume maps the linear level onto the exponential volume stage, the
+// caller must hold the speaker lock
+func (p *Player) applyVolume() {
+	p.volume.Silent = p.muted || p.level == 0
+	p.volume.Volume = (p.level - 1) * volumeRange
+}
+
+// Toggle pauses or resumes the loaded track, it does nothing when no track is
+// loaded. Play runs outside the UI goroutine, the player state is only read
+// with the speaker locked.
 func (p *Player) Toggle() {
+	speaker.Lock()
+	defer speaker.Unlock()
 	if p.ctrl != nil {
 		p.ctrl.Paused = !p.ctrl.Paused
 	}
 }
 
 func (p *Player) Stop() {
-	if p.streamer != nil {
-		p.streamer.Seek(0)
+	speaker.Lock()
+	defer speaker.Unlock()
+	if p.current != nil {
+		p.current.streamer.Seek(0)
 	}
 }
 
 func (p *Player) Close() {
-	if p.streamer != nil {
-		p.streamer.Close()
+	// Clear takes the speaker lock itself
+	if p.Loaded() {
+		speaker.Clear()
+	}
+
+	speaker.Lock()
+	defer speaker.Unlock()
+	if p.current != nil {
+		p.current.close()
+	}
+	if p.next != nil {
+		p.next.close()
+	}
+	if p.playback != nil {
+		p.playback.close()
 	}
+	p.current = nil
+	p.next = nil
+	p.playback = nil
 	p.ctrl = nil
 }
 
+// Seek moves playback to the given position, clamped to the track bounds
+func (p *Player) Seek(position time.Duration) error {
+	// The audio goroutine reads the streamer concurrently
+	speaker.Lock()
+	defer speaker.Unlock()
+	if p.current == nil || p.current.sampleRate == 0 {
+		return nil
+	}
+
+	sample := p.current.sampleRate.N(position)
+	sample = max(0, min(sample, p.current.length-1))
+	return p.current.streamer.Seek(sample)
+}
+
+// SeekBy moves playback forward or backward relative to the current position
+func (p *Player) SeekBy(delta time.Duration) error {
+	return p.Seek(p.CurrentPosition() + delta)
+}
+
+// SeekFraction moves playback to a fraction (0 to 1) of the track
+func (p *Player) SeekFraction(fraction float64) error {
+	return p.Seek(time.Duration(fraction * float64(p.Duration())))
+}
+
+// state returns the current track's position, length and sample rate while
+// holding the speaker lock
+func (p *Player) state() (position, length int, sampleRate beep.SampleRate) {
+	speaker.Lock()
+	defer speaker.Unlock()
+	if p.current == nil {
+		return 0, 0, 0
+	}
+	return p.current.streamer.Position(), p.current.length, p.current.sampleRate
+}
+
 func (p *Player) Position() float64 {
-	if p.streamer == nil || p.length == 0 {
+	position, length, _ := p.state()
+	if length == 0 {
 		return 0
 	}
-	return float64(p.streamer.Position()) / float64(p.length)
+	return float64(position) / float64(length)
 }
 
 func (p *Player) GetMetadata() Metadata {
-	return p.metadata
+	track := p.Track()
+	return Metadata{
+		Artist: track.Artist,
+		Title:  track.Title,
+		Album:  track.Album,
+	}
 }
 
 func (p *Player) Duration() time.Duration {
-	if p.streamer == nil || p.sampleRate == 0 {
+	_, length, sampleRate := p.state()
+	if sampleRate == 0 {
 		return 0
 	}
-	return p.sampleRate.D(p.length)
+	return sampleRate.D(length)
 }
 
 func (p *Player) CurrentPosition() time.Duration {
-	if p.streamer == nil || p.sampleRate == 0 {
+	position, _, sampleRate := p.state()
+	if sampleRate == 0 {
 		return 0
 	}
-	return p.sampleRate.D(p.streamer.Position())
+	return sampleRate.D(position)
 }
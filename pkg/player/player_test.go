@@ -0,0 +1,31 @@
+package player
+
+import (
+	"testing"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+)
+
+func TestSameAlbum(t *testing.T) {
+	track := func(album, albumArtist, artist string) media.Track {
+		return media.Track{Album: album, AlbumArtist: albumArtist, Artist: artist}
+	}
+	tests := []struct {
+		a, b media.Track
+		want bool
+	}{
+		{track("Kind of Blue", "", "Miles Davis"), track("Kind of Blue", "", "Miles Davis"), true},
+		{track("Kind of Blue", "Miles Davis", "Miles Davis"), track("Kind of Blue", "", "Miles Davis"), true},
+		// Compilations share their album artist, not their artists
+		{track("Jazz Hits", "Various Artists", "Miles Davis"), track("Jazz Hits", "Various Artists", "Nina Simone"), true},
+		{track("Greatest Hits", "Queen", "Queen"), track("Greatest Hits", "ABBA", "ABBA"), false},
+		{track("Greatest Hits", "", "Queen"), track("Greatest Hits", "", "ABBA"), false},
+		{track("Kind of Blue", "", "Miles Davis"), track("Blue Train", "", "Miles Davis"), false},
+		{track("", "", "Miles Davis"), track("", "", "Miles Davis"), false},
+	}
+	for _, tt := range tests {
+		if got := sameAlbum(tt.a, tt.b); got != tt.want {
+			t.Errorf("sameAlbum(%+v, %+v) = %t, want %t", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package player
+
+import (
+	"math"
+
+	"github.com/gopxl/beep/v2/speaker"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+)
+
+// ReplayGainMode selects which ReplayGain value normalizes playback
+type ReplayGainMode int
+
+const (
+	ReplayGainOff ReplayGainMode = iota
+	ReplayGainTrack
+	ReplayGainAlbum
+)
+
+func (m ReplayGainMode) String() string {
+	switch m {
+	case ReplayGainTrack:
+		return "track"
+	case ReplayGainAlbum:
+		return "album"
+	default:
+		return "off"
+	}
+}
+
+// ParseReplayGainMode parses a mode name as returned by String
+func ParseReplayGainMode(s string) ReplayGainMode {
+	switch s {
+	case "track":
+		return ReplayGainTrack
+	case "album":
+		return ReplayGainAlbum
+	default:
+		return ReplayGainOff
+	}
+}
+
+// referenceLoudness is the ReplayGain 2.0 target level in LUFS, used to
+// derive a gain from measured loudness
+const referenceLoudness = -18.0
+
+// replayGainFactor returns the linear gain to apply to a track. The preferred
+// value falls back to the other one when missing, then to the measured
+// loudness, and the gain is lowered when the peak would clip.
+func replayGainFactor(track media.Track, mode ReplayGainMode) float64 {
+	rg := track.ReplayGain
+	var gain, peak float64
+	switch {
+	case mode == ReplayGainOff:
+		return 1
+	case mode == ReplayGainAlbum && rg.HasAlbum, mode == ReplayGainTrack && !rg.HasTrack && rg.HasAlbum:
+		gain, peak = rg.AlbumGain, rg.AlbumPeak
+	case rg.HasTrack:
+		gain, peak = rg.TrackGain, rg.TrackPeak
+	case track.Loudness.Analyzed:
+		gain, peak = referenceLoudness-track.Loudness.Integrated, track.Loudness.TruePeak
+	default:
+		return 1
+	}
+
+	factor := math.Pow(10, gain/20)
+	if peak > 0 && factor*peak > 1 {
+		factor = 1 / peak
+	}
+	return factor
+}
+
+// SetReplayGainMode changes the normalization mode, applied immediately to
+// the playing and preloaded tracks
+func (p *Player) SetReplayGainMode(mode ReplayGainMode) {
+	speaker.Lock()
+	defer speaker.Unlock()
+	p.rgMode = mode
+	for _, t := range []*loadedTrack{p.current, p.next} {
+		if t != nil {
+			p.applyReplayGain(t)
+		}
+	}
+}
+
+// ReplayGainMode returns the normalization mode
+func (p *Player) ReplayGainMode() ReplayGainMode {
+	speaker.Lock()
+	defer speaker.Unlock()
+	return p.rgMode
+}
+
+// applyReplayGain sets the gain stage of a track for the current mode, the
+// caller must hold the speaker lock
+func (p *Player) applyReplayGain(t *loadedTrack) {
+	t.gain.Gain = replayGainFactor(t.track, p.rgMode) - 1
+}
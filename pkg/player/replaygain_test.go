@@ -0,0 +1,75 @@
+package player
+
+import (
+	"math"
+	"testing"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+)
+
+func TestReplayGainFactor(t *testing.T) {
+	db := func(gain float64) float64 { return math.Pow(10, gain/20) }
+	tagged := media.ReplayGain{
+		TrackGain: -6, TrackPeak: 0.5,
+		AlbumGain: -3, AlbumPeak: 0.7,
+		HasTrack: true, HasAlbum: true,
+	}
+	measured := func(integrated, peak float64) media.Loudness {
+		return media.Loudness{Integrated: integrated, TruePeak: peak, Analyzed: true}
+	}
+	tests := []struct {
+		name  string
+		track media.Track
+		mode  ReplayGainMode
+		want  float64
+	}{
+		{"off", media.Track{ReplayGain: tagged}, ReplayGainOff, 1},
+		{"track", media.Track{ReplayGain: tagged}, ReplayGainTrack, db(-6)},
+		{"album", media.Track{ReplayGain: tagged}, ReplayGainAlbum, db(-3)},
+		{
+			"album without album gain",
+			media.Track{ReplayGain: media.ReplayGain{TrackGain: -6, HasTrack: true}},
+			ReplayGainAlbum, db(-6),
+		},
+		{
+			"track without track gain",
+			media.Track{ReplayGain: media.ReplayGain{AlbumGain: -3, HasAlbum: true}},
+			ReplayGainTrack, db(-3),
+		},
+		{
+			"peak clamped",
+			media.Track{ReplayGain: media.ReplayGain{TrackGain: 6, TrackPeak: 0.8, HasTrack: true}},
+			ReplayGainTrack, 1.25,
+		},
+		{
+			"unknown peak",
+			media.Track{ReplayGain: media.ReplayGain{TrackGain: 6, HasTrack: true}},
+			ReplayGainTrack, db(6),
+		},
+		{
+			"tags before loudness",
+			media.Track{ReplayGain: tagged, Loudness: measured(-30, 0.1)},
+			ReplayGainTrack, db(-6),
+		},
+		{"measured", media.Track{Loudness: measured(-12, 0.3)}, ReplayGainAlbum, db(-6)},
+		{"measured peak clamped", media.Track{Loudness: measured(-24, 0.8)}, ReplayGainTrack, 1.25},
+		{"untagged", media.Track{}, ReplayGainTrack, 1},
+		{"not measured", media.Track{Loudness: media.Loudness{Integrated: -30}}, ReplayGainAlbum, 1},
+	}
+	for _, tt := range tests {
+		if got := replayGainFactor(tt.track, tt.mode); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: replayGainFactor = %f, want %f", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseReplayGainMode(t *testing.T) {
+	for _, mode := range []ReplayGainMode{ReplayGainOff, ReplayGainTrack, ReplayGainAlbum} {
+		if got := ParseReplayGainMode(mode.String()); got != mode {
+			t.Errorf("ParseReplayGainMode(%q) = %v, want %v", mode.String(), got, mode)
+		}
+	}
+	if got := ParseReplayGainMode("loud"); got != ReplayGainOff {
+		t.Errorf("ParseReplayGainMode(%q) = %v, want off", "loud", got)
+	}
+}
@@ -0,0 +1,109 @@
+package playlist
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+)
+
+// Library finds the tracks listed by playlist files
+type Library interface {
+	GetTrackByPath(path string) (media.Track, bool, error)
+	FindTrack(artist, title string) (media.Track, bool, error)
+}
+
+// Store saves the imported playlists and reads the exported ones
+type Store interface {
+	Library
+	CreatePlaylist(name string) (media.Playlist, error)
+	AddPlaylistTracks(playlistID string, trackIDs []string) error
+	GetPlaylistTracks(playlistID string) ([]media.Track, error)
+}
+
+// ImportResult tells what an import added to the library
+type ImportResult struct {
+	Playlist media.Playlist
+	Tracks   int
+	// Unresolved lists the entries matching no track of the library
+	Unresolved []Entry
+}
+
+// Resolve returns the library tracks listed by entries, matched by path and
+// then by artist and title, and the entries matching no track
+func Resolve(library Library, entries []Entry) ([]media.Track, []Entry, error) {
+	var tracks []media.Track
+	var unresolved []Entry
+	for _, entry := range entries {
+		track, ok, err := resolve(library, entry)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok {
+			unresolved = append(unresolved, entry)
+			continue
+		}
+		tracks = append(tracks, track)
+	}
+	return tracks, unresolved, nil
+}
+
+func resolve(library Library, entry Entry) (media.Track, bool, error) {
+	if entry.Path != "" {
+		track, ok, err := library.GetTrackByPath(entry.Path)
+		if err != nil || ok {
+			return track, ok, err
+		}
+	}
+	if entry.Artist == "" || entry.Title == "" {
+		return media.Track{}, false, nil
+	}
+	return library.FindTrack(entry.Artist, entry.Title)
+}
+
+// Import creates a playlist from a playlist file, named after the file when
+// name is empty
+func Import(store Store, path, name string) (ImportResult, error) {
+	entries, err := ReadFile(path)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	tracks, unresolved, err := Resolve(store, entries)
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	playlist, err := store.CreatePlaylist(name)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	ids := make([]string, len(tracks))
+	for i, track := range tracks {
+		ids[i] = track.ID
+	}
+	if err := store.AddPlaylistTracks(playlist.ID, ids); err != nil {
+		return ImportResult{}, err
+	}
+	return ImportResult{
+		Playlist:   playlist,
+		Tracks:     len(tracks),
+		Unresolved: unresolved,
+	}, nil
+}
+
+// Export writes the tracks of a playlist to a file in the format of its
+// extension
+func Export(store Store, playlistID, path string) error {
+	if _, err := FormatOf(path); err != nil {
+		return err
+	}
+	tracks, err := store.GetPlaylistTracks(playlistID)
+	if err != nil {
+		return fmt.Errorf("read playlist: %w", err)
+	}
+	return WriteFile(path, EntriesOf(tracks))
+}
@@ -0,0 +1,74 @@
+package playlist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func parseM3U(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	// info holds the #EXTINF line naming the next path
+	var info *Entry
+	scanner := bufio.NewScanner(r)
+	for first := true; scanner.Scan(); first = false {
+		line := strings.TrimSpace(scanner.Text())
+		if first {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXTINF:"):
+			entry := parseExtinf(strings.TrimPrefix(line, "#EXTINF:"))
+			info = &entry
+		case strings.HasPrefix(line, "#"):
+			// #EXTM3U and the directives of other players
+		default:
+			entry := Entry{}
+			if info != nil {
+				entry = *info
+				info = nil
+			}
+			entry.Path = line
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// parseExtinf parses the "<seconds> [attributes],<name>" of an #EXTINF line
+func parseExtinf(info string) Entry {
+	var entry Entry
+	length, name, _ := strings.Cut(info, ",")
+	// Extended players add attributes after the length
+	if fields := strings.Fields(length); len(fields) > 0 {
+		if seconds, err := strconv.ParseFloat(fields[0], 64); err == nil && seconds > 0 {
+			entry.Duration = time.Duration(seconds * float64(time.Second))
+		}
+	}
+	entry.Artist, entry.Title = splitTitle(name)
+	return entry
+}
+
+func writeM3U(w io.Writer, entries []Entry) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "#EXTM3U")
+	for _, entry := range entries {
+		if name := joinTitle(entry); name != "" || entry.Duration > 0 {
+			fmt.Fprintf(bw, "#EXTINF:%d,%s\n", durationSeconds(entry.Duration), name)
+		}
+		fmt.Fprintln(bw, entry.Path)
+	}
+	return bw.Flush()
+}
+
+// durationSeconds returns the length of M3U and PLS entries, -1 when unknown
+func durationSeconds(d time.Duration) int {
+	if d <= 0 {
+		return -1
+	}
+	return int(d.Round(time.Second) / time.Second)
+}
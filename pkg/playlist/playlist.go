@@ -0,0 +1,196 @@
+package playlist
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+)
+
+// Format is a playlist file format
+type Format string
+
+const (
+	// M3U playlists list paths, with #EXTINF lines naming the tracks. M3U8
+	// files are M3U encoded in UTF-8, which is also assumed for .m3u files.
+	M3U  Format = "m3u"
+	PLS  Format = "pls"
+	XSPF Format = "xspf"
+)
+
+// Entry is a track listed by a playlist file, Path is a file path or a URL
+// and the other fields are empty when the format or the file omits them
+type Entry struct {
+	Path     string
+	Title    string
+	Artist   string
+	Album    string
+	Duration time.Duration
+}
+
+// String names the entry by its track when known, along with its path
+func (e Entry) String() string {
+	name := joinTitle(e)
+	switch {
+	case name == "":
+		return e.Path
+	case e.Path == "":
+		return name
+	}
+	return fmt.Sprintf("%s (%s)", name, e.Path)
+}
+
+// FormatOf returns the format of a playlist file from its extension
+func FormatOf(path string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".m3u", ".m3u8":
+		return M3U, nil
+	case ".pls":
+		return PLS, nil
+	case ".xspf":
+		return XSPF, nil
+	}
+	return "", fmt.Errorf("unsupported playlist format: %s", path)
+}
+
+// Parse reads the entries of a playlist, their paths as written in the file
+func Parse(r io.Reader, format Format) ([]Entry, error) {
+	switch format {
+	case M3U:
+		return parseM3U(r)
+	case PLS:
+		return parsePLS(r)
+	case XSPF:
+		return parseXSPF(r)
+	}
+	return nil, fmt.Errorf("unsupported playlist format: %s", format)
+}
+
+// Write writes the entries as a playlist
+func Write(w io.Writer, format Format, entries []Entry) error {
+	switch format {
+	case M3U:
+		return writeM3U(w, entries)
+	case PLS:
+		return writePLS(w, entries)
+	case XSPF:
+		return writeXSPF(w, entries)
+	}
+	return fmt.Errorf("unsupported playlist format: %s", format)
+}
+
+// ReadFile reads the entries of a playlist file, resolving relative paths and
+// file URLs to absolute paths. Other URLs, such as streams, are kept as is.
+func ReadFile(path string) ([]Entry, error) {
+	format, err := FormatOf(path)
+	if err != nil {
+		return nil, err
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries, err := Parse(f, format)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	dir := filepath.Dir(path)
+	for i := range entries {
+		entries[i].Path = resolvePath(dir, entries[i].Path)
+	}
+	return entries, nil
+}
+
+// WriteFile writes the entries as a playlist file in the format of its
+// extension. Paths under the directory of the file are written relative to
+// it, so the playlist can move along with the music.
+func WriteFile(path string, entries []Entry) error {
+	format, err := FormatOf(path)
+	if err != nil {
+		return err
+	}
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	relative := make([]Entry, len(entries))
+	for i, entry := range entries {
+		entry.Path = relativePath(dir, entry.Path)
+		relative[i] = entry
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, format, relative); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
+
+// EntriesOf returns the entries listing tracks
+func EntriesOf(tracks []media.Track) []Entry {
+	entries := make([]Entry, len(tracks))
+	for i, track := range tracks {
+		entries[i] = Entry{
+			Path:     track.Path,
+			Title:    track.Title,
+			Artist:   track.Artist,
+			Album:    track.Album,
+			Duration: track.Duration,
+		}
+	}
+	return entries
+}
+
+// resolvePath returns the absolute path of an entry of a playlist in dir
+func resolvePath(dir, path string) string {
+	if u, err := url.Parse(path); err == nil && len(u.Scheme) > 1 {
+		if u.Scheme != "file" {
+			return path
+		}
+		path = u.Path
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	return filepath.Clean(path)
+}
+
+// relativePath returns path relative to dir when it is under dir
+func relativePath(dir, path string) string {
+	if !filepath.IsAbs(path) {
+		return path
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return rel
+}
+
+// splitTitle splits the "Artist - Title" names of M3U and PLS entries
+func splitTitle(name string) (artist, title string) {
+	if artist, title, ok := strings.Cut(name, " - "); ok {
+		return strings.TrimSpace(artist), strings.TrimSpace(title)
+	}
+	return "", strings.TrimSpace(name)
+}
+
+// joinTitle returns the "Artist - Title" name of an entry
+func joinTitle(entry Entry) string {
+	if entry.Artist == "" {
+		return entry.Title
+	}
+	return entry.Artist + " - " + entry.Title
+}
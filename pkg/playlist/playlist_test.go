@@ -0,0 +1,202 @@
+package playlist
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+)
+
+func TestParse(t *testing.T) {
+	want := []Entry{
+		{Path: "Radiohead/Karma Police.mp3", Artist: "Radiohead", Title: "Karma Police", Duration: 264 * time.Second},
+		{Path: "/music/unknown.flac"},
+		{Path: "http://radio.example/stream", Title: "Radio"},
+	}
+	tests := []struct {
+		format Format
+		data   string
+	}{
+		{M3U, "\ufeff#EXTM3U\r\n" +
+			"#EXTINF:264 tvg-id=\"1\",Radiohead - Karma Police\r\n" +
+			"Radiohead/Karma Police.mp3\r\n" +
+			"\r\n" +
+			"#EXTVLCOPT:start-time=0\n" +
+			"/music/unknown.flac\n" +
+			"#EXTINF:-1,Radio\n" +
+			"http://radio.example/stream\n"},
+		{PLS, "[playlist]\n" +
+			"File3=http://radio.example/stream\n" +
+			"Title3=Radio\n" +
+			"Length3=-1\n" +
+			"file1=Radiohead/Karma Police.mp3\n" +
+			"title1=Radiohead - Karma Police\n" +
+			"length1=264\n" +
+			"File2=/music/unknown.flac\n" +
+			"NumberOfEntries=3\n" +
+			"Version=2\n"},
+		{XSPF, `<?xml version="1.0" encoding="UTF-8"?>
+<playlist version="1" xmlns="http://xspf.org/ns/0/">
+  <trackList>
+    <track>
+      <location>Radiohead/Karma%20Police.mp3</location>
+      <title>Karma Police</title>
+      <creator>Radiohead</creator>
+      <duration>264000</duration>
+    </track>
+    <track><location>/music/unknown.flac</location></track>
+    <track><location>http://radio.example/stream</location><title>Radio</title></track>
+  </trackList>
+</playlist>`},
+	}
+	for _, tt := range tests {
+		got, err := Parse(strings.NewReader(tt.data), tt.format)
+		if err != nil {
+			t.Errorf("Parse(%s): %v", tt.format, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Parse(%s) = %+v, want %+v", tt.format, got, want)
+		}
+	}
+}
+
+func TestWriteFileReadFile(t *testing.T) {
+	dir := t.TempDir()
+	entries := []Entry{
+		{Path: filepath.Join(dir, "Radiohead", "Karma Police.mp3"), Artist: "Radiohead", Title: "Karma Police", Album: "OK Computer", Duration: 264 * time.Second},
+		{Path: "/elsewhere/a song#1.flac", Title: "A Song", Duration: 90 * time.Second},
+	}
+	for _, name := range []string{"list.m3u8", "list.pls", "list.xspf"} {
+		path := filepath.Join(dir, name)
+		if err := WriteFile(path, entries); err != nil {
+			t.Fatalf("WriteFile(%s): %v", name, err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Paths under the playlist directory are written relative to it
+		if bytes.Contains(data, []byte(dir)) {
+			t.Errorf("%s holds the absolute path of its directory:\n%s", name, data)
+		}
+
+		got, err := ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile(%s): %v", name, err)
+		}
+		want := entries
+		if format, _ := FormatOf(path); format != XSPF {
+			// Only XSPF keeps the albums
+			want = append([]Entry(nil), entries...)
+			want[0].Album = ""
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ReadFile(%s) = %+v, want %+v", name, got, want)
+		}
+	}
+}
+
+func TestReadFileRelative(t *testing.T) {
+	// The working directory is resolved, so must be the expected path
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "lists"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	data := "#EXTM3U\n../music/song.mp3\n"
+	if err := os.WriteFile(filepath.Join(dir, "lists", "list.m3u"), []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	got, err := ReadFile(filepath.Join("lists", "list.m3u"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The entries are absolute even though the playlist path is not
+	want := filepath.Join(dir, "music", "song.mp3")
+	if len(got) != 1 || got[0].Path != want {
+		t.Errorf("ReadFile = %+v, want %s", got, want)
+	}
+}
+
+func TestResolvePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"a.mp3", "/playlists/a.mp3"},
+		{"../music/a.mp3", "/music/a.mp3"},
+		{"/music/a.mp3", "/music/a.mp3"},
+		{"file:///music/a%20b.mp3", "/music/a b.mp3"},
+		{"https://radio.example/stream", "https://radio.example/stream"},
+	}
+	for _, tt := range tests {
+		if got := resolvePath("/playlists", tt.path); got != tt.want {
+			t.Errorf("resolvePath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+// fakeLibrary finds tracks in a list
+type fakeLibrary []media.Track
+
+func (l fakeLibrary) GetTrackByPath(path string) (media.Track, bool, error) {
+	for _, track := range l {
+		if track.Path == path {
+			return track, true, nil
+		}
+	}
+	return media.Track{}, false, nil
+}
+
+func (l fakeLibrary) FindTrack(artist, title string) (media.Track, bool, error) {
+	for _, track := range l {
+		if strings.EqualFold(track.Artist, artist) && strings.EqualFold(track.Title, title) {
+			return track, true, nil
+		}
+	}
+	return media.Track{}, false, nil
+}
+
+func TestResolve(t *testing.T) {
+	library := fakeLibrary{
+		{ID: "trk-1", Path: "/music/a.mp3", Artist: "Radiohead", Title: "Karma Police"},
+		{ID: "trk-2", Path: "/music/b.mp3", Artist: "Queen", Title: "Bohemian Rhapsody"},
+	}
+	entries := []Entry{
+		{Path: "/music/b.mp3"},
+		{Path: "/other/karma.mp3", Artist: "radiohead", Title: "karma police"},
+		{Path: "/other/missing.mp3", Artist: "Nobody", Title: "Nothing"},
+		{Path: "/music/b.mp3"},
+	}
+	tracks, unresolved, err := Resolve(library, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, track := range tracks {
+		ids = append(ids, track.ID)
+	}
+	if want := []string{"trk-2", "trk-1", "trk-2"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("tracks = %v, want %v", ids, want)
+	}
+	if len(unresolved) != 1 || unresolved[0].Path != "/other/missing.mp3" {
+		t.Errorf("unresolved = %+v, want the missing entry", unresolved)
+	}
+}
@@ -0,0 +1,77 @@
+package playlist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func parsePLS(r io.Reader) ([]Entry, error) {
+	// Keys are numbered from 1 and may come in any order
+	byIndex := make(map[int]*Entry)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue // [playlist] header and malformed lines
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		name := strings.TrimRight(key, "0123456789")
+		index, err := strconv.Atoi(key[len(name):])
+		if err != nil {
+			continue // NumberOfEntries, Version
+		}
+		entry := byIndex[index]
+		if entry == nil {
+			entry = &Entry{}
+			byIndex[index] = entry
+		}
+		value = strings.TrimSpace(value)
+		switch name {
+		case "file":
+			entry.Path = value
+		case "title":
+			entry.Artist, entry.Title = splitTitle(value)
+		case "length":
+			if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+				entry.Duration = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	indexes := make([]int, 0, len(byIndex))
+	for index := range byIndex {
+		indexes = append(indexes, index)
+	}
+	slices.Sort(indexes)
+	var entries []Entry
+	for _, index := range indexes {
+		if entry := byIndex[index]; entry.Path != "" {
+			entries = append(entries, *entry)
+		}
+	}
+	return entries, nil
+}
+
+func writePLS(w io.Writer, entries []Entry) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "[playlist]")
+	for i, entry := range entries {
+		n := i + 1
+		fmt.Fprintf(bw, "File%d=%s\n", n, entry.Path)
+		if name := joinTitle(entry); name != "" {
+			fmt.Fprintf(bw, "Title%d=%s\n", n, name)
+		}
+		fmt.Fprintf(bw, "Length%d=%d\n", n, durationSeconds(entry.Duration))
+	}
+	fmt.Fprintf(bw, "NumberOfEntries=%d\n", len(entries))
+	fmt.Fprintln(bw, "Version=2")
+	return bw.Flush()
+}
@@ -0,0 +1,87 @@
+package playlist
+
+import (
+	"encoding/xml"
+	"io"
+	"net/url"
+	"path/filepath"
+	"time"
+)
+
+// xspfPlaylist is read whatever its namespace, some players leave it out
+type xspfPlaylist struct {
+	XMLName   xml.Name    `xml:"playlist"`
+	Namespace string      `xml:"xmlns,attr"`
+	Version   string      `xml:"version,attr"`
+	Tracks    []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location string `xml:"location,omitempty"`
+	Title    string `xml:"title,omitempty"`
+	Creator  string `xml:"creator,omitempty"`
+	Album    string `xml:"album,omitempty"`
+	// Duration is in milliseconds
+	Duration int64 `xml:"duration,omitempty"`
+}
+
+func parseXSPF(r io.Reader) ([]Entry, error) {
+	var playlist xspfPlaylist
+	if err := xml.NewDecoder(r).Decode(&playlist); err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for _, track := range playlist.Tracks {
+		entry := Entry{
+			Title:    track.Title,
+			Artist:   track.Creator,
+			Album:    track.Album,
+			Duration: time.Duration(track.Duration) * time.Millisecond,
+		}
+		// Locations are URIs, relative ones are percent-encoded paths
+		entry.Path = track.Location
+		if u, err := url.Parse(track.Location); err == nil && u.Scheme == "" {
+			entry.Path = u.Path
+		}
+		if entry.Path == "" && entry.Title == "" {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func writeXSPF(w io.Writer, entries []Entry) error {
+	playlist := xspfPlaylist{Namespace: "http://xspf.org/ns/0/", Version: "1"}
+	for _, entry := range entries {
+		playlist.Tracks = append(playlist.Tracks, xspfTrack{
+			Location: locationOf(entry.Path),
+			Title:    entry.Title,
+			Creator:  entry.Artist,
+			Album:    entry.Album,
+			Duration: entry.Duration.Milliseconds(),
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(playlist); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// locationOf returns the URI of a path, a file URL when it is absolute
+func locationOf(path string) string {
+	if u, err := url.Parse(path); err == nil && len(u.Scheme) > 1 {
+		return path
+	}
+	if filepath.IsAbs(path) {
+		return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+	}
+	return (&url.URL{Path: filepath.ToSlash(path)}).String()
+}
@@ -0,0 +1,256 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+)
+
+// ErrEndOfQueue is returned when moving past either end of the queue
+var ErrEndOfQueue = errors.New("end of queue")
+
+// Store persists the queue so it survives restarts
+type Store interface {
+	SaveQueue(trackIDs []string, current int) error
+	LoadQueue() ([]media.Track, int, error)
+}
+
+// Queue is an ordered list of tracks with a cursor on the current one
+type Queue struct {
+	store   Store
+	tracks  []media.Track
+	current int
+	mu      sync.RWMutex
+}
+
+// New creates an empty queue backed by the given store
+func New(store Store) *Queue {
+	return &Queue{
+		store:   store,
+		current: -1,
+	}
+}
+
+// Load restores the queue from the store
+func (q *Queue) Load() error {
+	tracks, current, err := q.store.LoadQueue()
+	if err != nil {
+		return fmt.Errorf("failed to load queue: %w", err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.tracks = tracks
+	q.current = current
+	if q.current >= len(q.tracks) {
+		q.current = len(q.tracks) - 1
+	}
+	return nil
+}
+
+// save persists the queue, the caller must hold the lock
+func (q *Queue) save() error {
+	ids := make([]string, len(q.tracks))
+	for i, track := range q.tracks {
+		ids[i] = track.ID
+	}
+	if err := q.store.SaveQueue(ids, q.current); err != nil {
+		return fmt.Errorf("failed to save queue: %w", err)
+	}
+	return nil
+}
+
+// Tracks returns a copy of all tracks in the queue
+func (q *Queue) Tracks() []media.Track {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	tracks := make([]media.Track, len(q.tracks))
+	copy(tracks, q.tracks)
+	return tracks
+}
+
+// Len returns the number of tracks in the queue
+func (q *Queue) Len() int {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return len(q.tracks)
+}
+
+// CurrentIndex returns the index of the current track, or -1 if none
+func (q *Queue) CurrentIndex() int {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return q.current
+}
+
+// Current returns the current track
+func (q *Queue) Current() (media.Track, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.current < 0 || q.current >= len(q.tracks) {
+		return media.Track{}, false
+	}
+	return q.tracks[q.current], true
+}
+
+// Upcoming returns a copy of the tracks after the current one
+func (q *Queue) Upcoming() []media.Track {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	start := q.current + 1
+	if start >= len(q.tracks) {
+		return nil
+	}
+	tracks := make([]media.Track, len(q.tracks)-start)
+	copy(tracks, q.tracks[start:])
+	return tracks
+}
+
+// Replace replaces the whole queue and makes start the current track
+func (q *Queue) Replace(tracks []media.Track, start int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.tracks = make([]media.Track, len(tracks))
+	copy(q.tracks, tracks)
+	q.current = start
+	if q.current >= len(q.tracks) {
+		q.current = len(q.tracks) - 1
+	}
+	return q.save()
+}
+
+// Next advances to the next track and returns it
+func (q *Queue) Next() (media.Track, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.current+1 >= len(q.tracks) {
+		return media.Track{}, ErrEndOfQueue
+	}
+	q.current++
+	return q.tracks[q.current], q.save()
+}
+
+// Previous moves back to the previous track and returns it
+func (q *Queue) Previous() (media.Track, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.current <= 0 {
+		return media.Track{}, ErrEndOfQueue
+	}
+	q.current--
+	return q.tracks[q.current], q.save()
+}
+
+// JumpTo makes the track at index the current one and returns it
+func (q *Queue) JumpTo(index int) (media.Track, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if index < 0 || index >= len(q.tracks) {
+		return media.Track{}, fmt.Errorf("queue index out of range: %d", index)
+	}
+	q.current = index
+	return q.tracks[q.current], q.save()
+}
+
+// EnqueueNext inserts a track right after the current one
+func (q *Queue) EnqueueNext(track media.Track) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	pos := q.current + 1
+	q.tracks = append(q.tracks, media.Track{})
+	copy(q.tracks[pos+1:], q.tracks[pos:])
+	q.tracks[pos] = track
+	return q.save()
+}
+
+// EnqueueLast appends a track to the end of the queue
+func (q *Queue) EnqueueLast(track media.Track) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.tracks = append(q.tracks, track)
+	return q.save()
+}
+
+// Remove removes the track at index. Removing the current track makes the
+// following one current.
+func (q *Queue) Remove(index int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if index < 0 || index >= len(q.tracks) {
+		return fmt.Errorf("queue index out of range: %d", index)
+	}
+	q.tracks = append(q.tracks[:index], q.tracks[index+1:]...)
+	if index < q.current || q.current >= len(q.tracks) {
+		q.current--
+	}
+	return q.save()
+}
+
+// RemoveSource removes the tracks of a source. The current track stays
+// current. When it is removed, the first remaining track after it becomes
+// current, or the last track of the queue when none follows.
+func (q *Queue) RemoveSource(sourceID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	tracks := q.tracks[:0]
+	current := -1
+	for i, track := range q.tracks {
+		if track.SourceID == sourceID {
+			continue
+		}
+		if current == -1 && i >= q.current && q.current >= 0 {
+			current = len(tracks)
+		}
+		tracks = append(tracks, track)
+	}
+	if q.current >= 0 && current == -1 {
+		current = len(tracks) - 1
+	}
+	clear(q.tracks[len(tracks):])
+	q.tracks = tracks
+	q.current = current
+	return q.save()
+}
+
+// Move moves the track at index from to index to, keeping the current track
+// pointing at the same entry
+func (q *Queue) Move(from, to int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if from < 0 || from >= len(q.tracks) || to < 0 || to >= len(q.tracks) {
+		return fmt.Errorf("queue index out of range: %d -> %d", from, to)
+	}
+	if from == to {
+		return nil
+	}
+
+	track := q.tracks[from]
+	if from < to {
+		copy(q.tracks[from:to], q.tracks[from+1:to+1])
+	} else {
+		copy(q.tracks[to+1:from+1], q.tracks[to:from])
+	}
+	q.tracks[to] = track
+
+	switch {
+	case q.current == from:
+		q.current = to
+	case from < q.current && to >= q.current:
+		q.current--
+	case from > q.current && to <= q.current:
+		q.current++
+	}
+	return q.save()
+}
+
+// Clear removes every track from the queue
+func (q *Queue) Clear() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.tracks = nil
+	q.current = -1
+	return q.save()
+}
@@ -0,0 +1,188 @@
+package queue
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+)
+
+// memStore keeps the last saved queue in memory
+type memStore struct {
+	ids     []string
+	current int
+}
+
+func (s *memStore) SaveQueue(trackIDs []string, current int) error {
+	s.ids = trackIDs
+	s.current = current
+	return nil
+}
+
+func (s *memStore) LoadQueue() ([]media.Track, int, error) {
+	return nil, -1, nil
+}
+
+// newQueue returns a queue of tracks named by the letters of ids, the track
+// at current being the current one
+func newQueue(t *testing.T, ids string, current int) (*Queue, *memStore) {
+	t.Helper()
+	store := &memStore{}
+	q := New(store)
+	var tracks []media.Track
+	for _, id := range strings.Split(ids, "") {
+		tracks = append(tracks, media.Track{ID: id, SourceID: "src-" + strings.ToLower(id)})
+	}
+	if err := q.Replace(tracks, current); err != nil {
+		t.Fatal(err)
+	}
+	return q, store
+}
+
+// checkQueue compares the tracks and the current index of the queue, and
+// checks that they were saved
+func checkQueue(t *testing.T, q *Queue, store *memStore, ids string, current int) {
+	t.Helper()
+	var got []string
+	for _, track := range q.Tracks() {
+		got = append(got, track.ID)
+	}
+	want := strings.Split(ids, "")
+	if ids == "" {
+		want = nil
+	}
+	if !reflect.DeepEqual(got, want) || q.CurrentIndex() != current {
+		t.Errorf("queue = %v current %d, want %v current %d", got, q.CurrentIndex(), want, current)
+	}
+	saved := store.ids
+	if len(saved) == 0 {
+		saved = nil
+	}
+	if !reflect.DeepEqual(saved, got) || store.current != current {
+		t.Errorf("saved %v current %d, want %v current %d", store.ids, store.current, got, current)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	tests := []struct {
+		name    string
+		ids     string
+		current int
+		index   int
+		want    string
+		// wantCurrent is the index of the current track after the removal
+		wantCurrent int
+	}{
+		{"before current", "abcd", 2, 0, "bcd", 1},
+		{"after current", "abcd", 1, 3, "abc", 1},
+		{"current moves to the following", "abcd", 1, 1, "acd", 1},
+		{"last current moves to the previous", "abcd", 3, 3, "abc", 2},
+		{"only track", "a", 0, 0, "", -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, store := newQueue(t, tt.ids, tt.current)
+			if err := q.Remove(tt.index); err != nil {
+				t.Fatal(err)
+			}
+			checkQueue(t, q, store, tt.want, tt.wantCurrent)
+		})
+	}
+
+	q, _ := newQueue(t, "ab", 0)
+	if err := q.Remove(2); err == nil {
+		t.Error("removing past the end succeeded")
+	}
+}
+
+func TestRemoveSource(t *testing.T) {
+	// Tracks named by the same letter in both cases share their source
+	tests := []struct {
+		name        string
+		ids         string
+		current     int
+		want        string
+		wantCurrent int
+	}{
+		{"before current", "aAbc", 2, "bc", 0},
+		{"after current", "bcaA", 0, "bc", 0},
+		{"around current", "abAc", 1, "bc", 0},
+		{"current moves to the following", "baAc", 1, "bc", 1},
+		{"last current moves to the previous", "bcaA", 2, "bc", 1},
+		{"whole queue", "aA", 1, "", -1},
+		{"nothing current", "abc", -1, "bc", -1},
+		{"other source", "bcd", 1, "bcd", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, store := newQueue(t, tt.ids, tt.current)
+			if err := q.RemoveSource("src-a"); err != nil {
+				t.Fatal(err)
+			}
+			checkQueue(t, q, store, tt.want, tt.wantCurrent)
+		})
+	}
+}
+
+func TestMove(t *testing.T) {
+	tests := []struct {
+		name        string
+		current     int
+		from, to    int
+		want        string
+		wantCurrent int
+	}{
+		{"current down", 1, 1, 3, "acdb", 3},
+		{"current up", 2, 2, 0, "cabd", 0},
+		{"over current downwards", 2, 0, 3, "bcda", 1},
+		{"over current upwards", 1, 3, 0, "dabc", 2},
+		{"onto current from before", 2, 0, 2, "bcad", 1},
+		{"onto current from after", 1, 3, 1, "adbc", 2},
+		{"away from current", 0, 2, 3, "abdc", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, store := newQueue(t, "abcd", tt.current)
+			if err := q.Move(tt.from, tt.to); err != nil {
+				t.Fatal(err)
+			}
+			checkQueue(t, q, store, tt.want, tt.wantCurrent)
+		})
+	}
+
+	q, _ := newQueue(t, "ab", 0)
+	if err := q.Move(0, 2); err == nil {
+		t.Error("moving past the end succeeded")
+	}
+}
+
+func TestEnqueueNext(t *testing.T) {
+	tests := []struct {
+		name    string
+		ids     string
+		current int
+		want    string
+	}{
+		{"after current", "abc", 0, "axbc"},
+		{"at the end", "abc", 2, "abcx"},
+		{"nothing current", "abc", -1, "xabc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, store := newQueue(t, tt.ids, tt.current)
+			if err := q.EnqueueNext(media.Track{ID: "x"}); err != nil {
+				t.Fatal(err)
+			}
+			checkQueue(t, q, store, tt.want, tt.current)
+		})
+	}
+
+	q := New(&memStore{})
+	if err := q.EnqueueNext(media.Track{ID: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := q.Current(); ok || q.Len() != 1 {
+		t.Errorf("empty queue: len %d, current %d", q.Len(), q.CurrentIndex())
+	}
+}
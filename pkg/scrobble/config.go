@@ -0,0 +1,49 @@
+package scrobble
+
+// Settings keys of the scrobbling services. A service is enabled once its
+// credentials are set, the URLs replace the default endpoints.
+const (
+	ListenBrainzTokenKey = "scrobble.listenbrainz.token"
+	ListenBrainzURLKey   = "scrobble.listenbrainz.url"
+	LastFMAPIKeyKey      = "scrobble.lastfm.api_key"
+	LastFMSecretKey      = "scrobble.lastfm.secret"
+	LastFMSessionKey     = "scrobble.lastfm.session"
+	LastFMURLKey         = "scrobble.lastfm.url"
+)
+
+// Settings reads the configuration of the services
+type Settings interface {
+	GetSetting(key string) (string, error)
+}
+
+// Services returns the services enabled in the settings
+func Services(settings Settings) ([]Service, error) {
+	values := make(map[string]string)
+	for _, key := range []string{
+		ListenBrainzTokenKey, ListenBrainzURLKey,
+		LastFMAPIKeyKey, LastFMSecretKey, LastFMSessionKey, LastFMURLKey,
+	} {
+		value, err := settings.GetSetting(key)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+
+	var services []Service
+	if values[ListenBrainzTokenKey] != "" {
+		services = append(services, &ListenBrainz{
+			URL:   values[ListenBrainzURLKey],
+			Token: values[ListenBrainzTokenKey],
+		})
+	}
+	if values[LastFMSessionKey] != "" {
+		services = append(services, &LastFM{
+			URL:        values[LastFMURLKey],
+			APIKey:     values[LastFMAPIKeyKey],
+			Secret:     values[LastFMSecretKey],
+			SessionKey: values[LastFMSessionKey],
+		})
+	}
+	return services, nil
+}
@@ -0,0 +1,47 @@
+package scrobble
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// requestTimeout bounds a submission when the service has no HTTP client
+const requestTimeout = 30 * time.Second
+
+// maxErrorBody bounds the part of an error response kept in the error
+const maxErrorBody = 512
+
+var defaultClient = &http.Client{Timeout: requestTimeout}
+
+// post sends a request body and returns the response, which the caller
+// closes
+func post(ctx context.Context, client *http.Client, url, contentType string, body io.Reader, header http.Header) (*http.Response, error) {
+	if client == nil {
+		client = defaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "pulsar")
+	return client.Do(req)
+}
+
+// statusError returns the error of a failed response, with the start of its
+// body
+func statusError(service string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	message := strings.TrimSpace(string(body))
+	if message == "" {
+		return fmt.Errorf("%s: %s", service, resp.Status)
+	}
+	return fmt.Errorf("%s: %s: %s", service, resp.Status, message)
+}
@@ -0,0 +1,150 @@
+package scrobble
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+)
+
+// DefaultLastFMURL is the endpoint of the Last.fm API
+const DefaultLastFMURL = "https://ws.audioscrobbler.com/2.0/"
+
+// lastFMInvalidParameters is the Last.fm error code of requests it refuses
+// whatever the retries
+const lastFMInvalidParameters = 6
+
+// LastFM scrobbles with the Last.fm API, on behalf of the user of a session
+type LastFM struct {
+	// URL is the endpoint of the API, DefaultLastFMURL when empty
+	URL string
+	// APIKey and Secret identify the API account of the application
+	APIKey string
+	Secret string
+	// SessionKey authorizes scrobbling for a user, see Login
+	SessionKey string
+	Client     *http.Client
+}
+
+func (l *LastFM) Name() string {
+	return "lastfm"
+}
+
+// lastFMResponse holds the fields of the Last.fm responses pulsar reads
+type lastFMResponse struct {
+	Error   int    `json:"error"`
+	Message string `json:"message"`
+	Session struct {
+		Key string `json:"key"`
+	} `json:"session"`
+}
+
+// Submit scrobbles the plays, at most 50
+func (l *LastFM) Submit(ctx context.Context, scrobbles []media.Scrobble) error {
+	params := url.Values{
+		"method": {"track.scrobble"},
+		"sk":     {l.SessionKey},
+	}
+	for i, s := range scrobbles {
+		set := func(name, value string) {
+			params.Set(fmt.Sprintf("%s[%d]", name, i), value)
+		}
+		set("artist", s.Artist)
+		set("track", s.Title)
+		set("timestamp", strconv.FormatInt(s.PlayedAt.Unix(), 10))
+		if s.Album != "" {
+			set("album", s.Album)
+		}
+		if s.AlbumArtist != "" {
+			set("albumArtist", s.AlbumArtist)
+		}
+		if s.TrackNumber > 0 {
+			set("trackNumber", strconv.Itoa(s.TrackNumber))
+		}
+		if s.Duration > 0 {
+			set("duration", strconv.Itoa(int(s.Duration.Seconds())))
+		}
+	}
+	_, err := l.call(ctx, params)
+	return err
+}
+
+// Login opens a session for a user and returns its key, to store as
+// SessionKey
+func (l *LastFM) Login(ctx context.Context, username, password string) (string, error) {
+	response, err := l.call(ctx, url.Values{
+		"method":   {"auth.getMobileSession"},
+		"username": {username},
+		"password": {password},
+	})
+	if err != nil {
+		return "", err
+	}
+	if response.Session.Key == "" {
+		return "", fmt.Errorf("%s: no session in the response", l.Name())
+	}
+	return response.Session.Key, nil
+}
+
+// call signs and posts an API method call
+func (l *LastFM) call(ctx context.Context, params url.Values) (lastFMResponse, error) {
+	params.Set("api_key", l.APIKey)
+	params.Set("api_sig", l.sign(params))
+	params.Set("format", "json")
+
+	endpoint := l.URL
+	if endpoint == "" {
+		endpoint = DefaultLastFMURL
+	}
+	resp, err := post(
+		ctx, l.Client, endpoint, "application/x-www-form-urlencoded",
+		strings.NewReader(params.Encode()), nil,
+	)
+	if err != nil {
+		return lastFMResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	var response lastFMResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return lastFMResponse{}, fmt.Errorf("%s: %s", l.Name(), resp.Status)
+		}
+		return lastFMResponse{}, fmt.Errorf("%s: decode response: %w", l.Name(), err)
+	}
+	switch {
+	case response.Error == lastFMInvalidParameters:
+		return response, fmt.Errorf("%w: %s: %s", ErrRejected, l.Name(), response.Message)
+	// Authentication errors and outages are worth retrying once fixed
+	case response.Error != 0:
+		return response, fmt.Errorf("%s: error %d: %s", l.Name(), response.Error, response.Message)
+	case resp.StatusCode != http.StatusOK:
+		return response, fmt.Errorf("%s: %s", l.Name(), resp.Status)
+	}
+	return response, nil
+}
+
+// sign returns the signature of the parameters: the MD5 of their names and
+// values sorted by name, followed by the secret
+func (l *LastFM) sign(params url.Values) string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hash := md5.New()
+	for _, name := range names {
+		hash.Write([]byte(name + params.Get(name)))
+	}
+	hash.Write([]byte(l.Secret))
+	return hex.EncodeToString(hash.Sum(nil))
+}
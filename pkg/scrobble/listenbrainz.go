@@ -0,0 +1,105 @@
+package scrobble
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+)
+
+// DefaultListenBrainzURL is the root of the ListenBrainz API
+const DefaultListenBrainzURL = "https://api.listenbrainz.org"
+
+// ListenBrainz submits listens with the ListenBrainz JSON API
+type ListenBrainz struct {
+	// URL is the root of the API, DefaultListenBrainzURL when empty
+	URL string
+	// Token is the user token shown in the ListenBrainz settings
+	Token  string
+	Client *http.Client
+}
+
+func (l *ListenBrainz) Name() string {
+	return "listenbrainz"
+}
+
+type listenPayload struct {
+	ListenType string   `json:"listen_type"`
+	Payload    []listen `json:"payload"`
+}
+
+type listen struct {
+	ListenedAt int64          `json:"listened_at"`
+	Metadata   listenMetadata `json:"track_metadata"`
+}
+
+type listenMetadata struct {
+	ArtistName     string         `json:"artist_name"`
+	TrackName      string         `json:"track_name"`
+	ReleaseName    string         `json:"release_name,omitempty"`
+	AdditionalInfo map[string]any `json:"additional_info"`
+}
+
+// Submit posts the plays as a single listen, or an import of several
+func (l *ListenBrainz) Submit(ctx context.Context, scrobbles []media.Scrobble) error {
+	payload := listenPayload{ListenType: "single"}
+	if len(scrobbles) > 1 {
+		payload.ListenType = "import"
+	}
+	for _, s := range scrobbles {
+		info := map[string]any{
+			"media_player":      "pulsar",
+			"submission_client": "pulsar",
+		}
+		if s.Duration > 0 {
+			info["duration_ms"] = s.Duration.Milliseconds()
+		}
+		if s.TrackNumber > 0 {
+			info["tracknumber"] = s.TrackNumber
+		}
+		if s.AlbumArtist != "" {
+			info["release_artist_name"] = s.AlbumArtist
+		}
+		payload.Payload = append(payload.Payload, listen{
+			ListenedAt: s.PlayedAt.Unix(),
+			Metadata: listenMetadata{
+				ArtistName:     s.Artist,
+				TrackName:      s.Title,
+				ReleaseName:    s.Album,
+				AdditionalInfo: info,
+			},
+		})
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	root := l.URL
+	if root == "" {
+		root = DefaultListenBrainzURL
+	}
+	resp, err := post(
+		ctx, l.Client, strings.TrimSuffix(root, "/")+"/1/submit-listens",
+		"application/json", bytes.NewReader(body),
+		http.Header{"Authorization": {"Token " + l.Token}},
+	)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return nil
+	// Invalid listens are refused for good, a wrong token or an outage is
+	// worth retrying once fixed
+	case resp.StatusCode == http.StatusBadRequest:
+		return fmt.Errorf("%w: %w", ErrRejected, statusError(l.Name(), resp))
+	}
+	return statusError(l.Name(), resp)
+}
@@ -0,0 +1,218 @@
+// Package scrobble submits the plays of the listening history to scrobbling
+// services such as ListenBrainz and Last.fm. Plays wait in a durable outbox
+// until a service accepts them, so none is lost while offline.
+package scrobble
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+)
+
+const (
+	// batchSize is the number of plays submitted at once, Last.fm taking
+	// at most 50 per request
+	batchSize = 50
+	// minDuration is the length under which tracks are not scrobbled, as
+	// Last.fm requires
+	minDuration = 30 * time.Second
+	firstRetry  = 30 * time.Second
+	maxRetry    = time.Hour
+)
+
+// ErrRejected is wrapped by the errors of services refusing plays for good,
+// such plays are dropped instead of being submitted again
+var ErrRejected = errors.New("scrobble rejected")
+
+// Service is a scrobbling service
+type Service interface {
+	// Name identifies the service in the outbox and the settings
+	Name() string
+	// Submit sends plays to the service
+	Submit(ctx context.Context, scrobbles []media.Scrobble) error
+}
+
+// Store holds the outbox of the plays waiting to be submitted
+type Store interface {
+	QueueScrobble(play media.Play, services []string) error
+	GetScrobbles(service string, limit int) ([]media.Scrobble, error)
+	RemoveScrobbles(ids []int64) error
+	FailScrobbles(ids []int64, reason string) error
+}
+
+// Scrobbler queues plays in the outbox and submits them from its Run loop,
+// retrying with an exponential backoff while a service fails
+type Scrobbler struct {
+	store    Store
+	services []Service
+	wake     chan struct{}
+	// retries holds the services whose last submission failed, only Run
+	// uses it
+	retries    map[string]retry
+	firstRetry time.Duration
+	maxRetry   time.Duration
+}
+
+// retry tells when to submit again to a failing service
+type retry struct {
+	failures int
+	at       time.Time
+}
+
+func New(store Store, services ...Service) *Scrobbler {
+	return &Scrobbler{
+		store:      store,
+		services:   services,
+		wake:       make(chan struct{}, 1),
+		retries:    make(map[string]retry),
+		firstRetry: firstRetry,
+		maxRetry:   maxRetry,
+	}
+}
+
+// Enabled reports whether any service is configured
+func (s *Scrobbler) Enabled() bool {
+	return len(s.services) > 0
+}
+
+// Queue adds a play to the outbox of every service and wakes the worker up.
+// Tracks without artist or title, or shorter than 30 seconds, are skipped.
+func (s *Scrobbler) Queue(play media.Play) error {
+	track := play.Track
+	if !s.Enabled() || track.Artist == "" || track.Title == "" {
+		return nil
+	}
+	if track.Duration > 0 && track.Duration < minDuration {
+		return nil
+	}
+	names := make([]string, len(s.services))
+	for i, service := range s.services {
+		names[i] = service.Name()
+	}
+	if err := s.store.QueueScrobble(play, names); err != nil {
+		return err
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run submits the plays of the outbox as they are queued, until the context
+// is canceled. It first submits the plays left by previous sessions.
+func (s *Scrobbler) Run(ctx context.Context) {
+	if !s.Enabled() {
+		return
+	}
+	for {
+		if !s.wait(ctx, s.flush(ctx)) {
+			return
+		}
+	}
+}
+
+// wait waits for a play to be queued or for the delay to pass, forever when
+// zero, and returns false once the context is canceled
+func (s *Scrobbler) wait(ctx context.Context, delay time.Duration) bool {
+	var retry <-chan time.Time
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		retry = timer.C
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-s.wake:
+	case <-retry:
+	}
+	return true
+}
+
+// flush submits the outbox of the services not waiting for a retry, and
+// returns the time until the next retry, zero when none is due
+func (s *Scrobbler) flush(ctx context.Context) time.Duration {
+	var wait time.Duration
+	until := func(at time.Time) {
+		if d := time.Until(at); wait == 0 || d < wait {
+			wait = max(d, time.Millisecond)
+		}
+	}
+	for _, service := range s.services {
+		name := service.Name()
+		if r, ok := s.retries[name]; ok && time.Now().Before(r.at) {
+			until(r.at)
+			continue
+		}
+		err := s.submitAll(ctx, service)
+		if ctx.Err() != nil {
+			return 0
+		}
+		if err == nil {
+			delete(s.retries, name)
+			continue
+		}
+		r := s.retries[name]
+		r.failures++
+		r.at = time.Now().Add(s.backoff(r.failures))
+		s.retries[name] = r
+		until(r.at)
+	}
+	return wait
+}
+
+// backoff returns the delay before retrying after consecutive failures
+func (s *Scrobbler) backoff(failures int) time.Duration {
+	delay := s.firstRetry
+	for i := 1; i < failures && delay < s.maxRetry; i++ {
+		delay *= 2
+	}
+	return min(delay, s.maxRetry)
+}
+
+// submitAll submits the outbox of a service until it is empty
+func (s *Scrobbler) submitAll(ctx context.Context, service Service) error {
+	for {
+		batch, err := s.store.GetScrobbles(service.Name(), batchSize)
+		if err != nil || len(batch) == 0 {
+			return err
+		}
+		if err := s.submit(ctx, service, batch); err != nil {
+			return err
+		}
+	}
+}
+
+// submit sends plays and takes them out of the outbox once accepted or
+// rejected. A rejected batch is sent again play by play to drop only the
+// plays at fault.
+func (s *Scrobbler) submit(ctx context.Context, service Service, batch []media.Scrobble) error {
+	ids := make([]int64, len(batch))
+	for i, scrobble := range batch {
+		ids[i] = scrobble.ID
+	}
+
+	err := service.Submit(ctx, batch)
+	switch {
+	case err == nil:
+		return s.store.RemoveScrobbles(ids)
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case errors.Is(err, ErrRejected) && len(batch) > 1:
+		for i := range batch {
+			if err := s.submit(ctx, service, batch[i:i+1]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case errors.Is(err, ErrRejected):
+		return s.store.RemoveScrobbles(ids)
+	}
+	if failErr := s.store.FailScrobbles(ids, err.Error()); failErr != nil {
+		return failErr
+	}
+	return err
+}
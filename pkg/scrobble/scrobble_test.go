@@ -0,0 +1,213 @@
+package scrobble
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/llehouerou/pulsar/pkg/db"
+	"github.com/llehouerou/pulsar/pkg/media"
+)
+
+var played = time.Date(2024, 5, 1, 20, 30, 0, 0, time.UTC)
+
+func TestListenBrainz(t *testing.T) {
+	var got listenPayload
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/1/submit-listens" || r.Header.Get("Authorization") != "Token secret" {
+			t.Errorf("request to %s with %q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(`{"code": 0, "error": "nope"}`))
+	}))
+	defer server.Close()
+
+	service := &ListenBrainz{URL: server.URL + "/", Token: "secret"}
+	scrobbles := []media.Scrobble{
+		{Artist: "Miles Davis", Title: "So What", Album: "Kind of Blue", TrackNumber: 1, Duration: 9 * time.Minute, PlayedAt: played},
+		{Artist: "John Coltrane", Title: "Naima", PlayedAt: played.Add(10 * time.Minute)},
+	}
+	if err := service.Submit(context.Background(), scrobbles); err != nil {
+		t.Fatal(err)
+	}
+	if got.ListenType != "import" || len(got.Payload) != 2 {
+		t.Fatalf("payload = %+v, want an import of 2 listens", got)
+	}
+	first := got.Payload[0]
+	if first.ListenedAt != played.Unix() || first.Metadata.TrackName != "So What" ||
+		first.Metadata.ReleaseName != "Kind of Blue" || first.Metadata.AdditionalInfo["duration_ms"] != float64(540000) {
+		t.Errorf("first listen = %+v", first)
+	}
+
+	if err := service.Submit(context.Background(), scrobbles[1:]); err != nil || got.ListenType != "single" {
+		t.Errorf("single listen: %v, type %q", err, got.ListenType)
+	}
+
+	status = http.StatusBadRequest
+	if err := service.Submit(context.Background(), scrobbles); !errors.Is(err, ErrRejected) {
+		t.Errorf("bad request error = %v, want rejected", err)
+	}
+	status = http.StatusUnauthorized
+	if err := service.Submit(context.Background(), scrobbles); err == nil || errors.Is(err, ErrRejected) {
+		t.Errorf("unauthorized error = %v, want an error to retry", err)
+	}
+}
+
+func TestLastFM(t *testing.T) {
+	var got url.Values
+	response := `{"scrobbles": {"@attr": {"accepted": 1, "ignored": 0}}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		got = r.PostForm
+		w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	service := &LastFM{URL: server.URL, APIKey: "key", Secret: "shh", SessionKey: "session"}
+	err := service.Submit(context.Background(), []media.Scrobble{
+		{Artist: "Miles Davis", Title: "So What", Album: "Kind of Blue", Duration: 9 * time.Minute, PlayedAt: played},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := url.Values{
+		"method":       {"track.scrobble"},
+		"api_key":      {"key"},
+		"sk":           {"session"},
+		"artist[0]":    {"Miles Davis"},
+		"track[0]":     {"So What"},
+		"album[0]":     {"Kind of Blue"},
+		"timestamp[0]": {"1714595400"},
+		"duration[0]":  {"540"},
+		"format":       {"json"},
+	}
+	signature := got.Get("api_sig")
+	got.Del("api_sig")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("form = %v, want %v", got, want)
+	}
+	unsigned := url.Values{}
+	for name, values := range want {
+		if name != "format" {
+			unsigned[name] = values
+		}
+	}
+	if want := service.sign(unsigned); signature != want {
+		t.Errorf("api_sig = %q, want %q", signature, want)
+	}
+
+	response = `{"error": 6, "message": "Invalid parameters"}`
+	if err := service.Submit(context.Background(), nil); !errors.Is(err, ErrRejected) {
+		t.Errorf("invalid parameters error = %v, want rejected", err)
+	}
+	response = `{"error": 9, "message": "Invalid session key"}`
+	if err := service.Submit(context.Background(), nil); err == nil || errors.Is(err, ErrRejected) {
+		t.Errorf("invalid session error = %v, want an error to retry", err)
+	}
+
+	response = `{"session": {"name": "miles", "key": "new-session"}}`
+	session, err := service.Login(context.Background(), "miles", "blue")
+	if err != nil || session != "new-session" {
+		t.Errorf("Login = %q, %v", session, err)
+	}
+	if got.Get("method") != "auth.getMobileSession" || got.Get("password") != "blue" {
+		t.Errorf("login form = %v", got)
+	}
+}
+
+func TestScrobbler(t *testing.T) {
+	d, err := db.New(filepath.Join(t.TempDir(), "pulsar.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	// The stand-in server is offline at first, then accepts every listen
+	// but those of a bad track
+	var mu sync.Mutex
+	var requests int
+	var accepted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if requests <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var payload listenPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Error(err)
+		}
+		for _, listen := range payload.Payload {
+			if listen.Metadata.TrackName == "bad" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		for _, listen := range payload.Payload {
+			accepted = append(accepted, listen.Metadata.TrackName)
+		}
+	}))
+	defer server.Close()
+
+	scrobbler := New(d, &ListenBrainz{URL: server.URL, Token: "secret"})
+	scrobbler.firstRetry = 10 * time.Millisecond
+	queue := func(id int64, title string, duration time.Duration) {
+		t.Helper()
+		err := scrobbler.Queue(media.Play{
+			ID:        id,
+			Track:     media.Track{Artist: "Artist", Title: title, Duration: duration},
+			StartedAt: played.Add(time.Duration(id) * time.Minute),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	queue(1, "one", time.Minute)
+	// Too short to scrobble
+	queue(2, "jingle", 10*time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go scrobbler.Run(ctx)
+
+	queue(3, "bad", time.Minute)
+	queue(4, "two", time.Minute)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		waiting, err := d.CountScrobbles("listenbrainz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if waiting == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			scrobbles, _ := d.GetScrobbles("listenbrainz", 10)
+			t.Fatalf("%d plays still waiting: %+v", waiting, scrobbles)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"one", "two"}; !reflect.DeepEqual(accepted, want) {
+		t.Errorf("accepted = %v, want %v", accepted, want)
+	}
+}
@@ -13,6 +13,11 @@ import (
 	"github.com/llehouerou/pulsar/pkg/ui/common"
 )
 
+// sourceSavedMsg reports the end of saving a source and its scan
+type sourceSavedMsg struct {
+	err error
+}
+
 type AddSourceModel struct {
 	nameInput  textinput.Model
 	pathsInput textinput.Model
@@ -22,7 +27,15 @@ type AddSourceModel struct {
 	err        error
 	manager    *media.SourceManager
 	scanning   bool
-	styles     struct {
+	// editing is the source being edited, nil when adding one
+	editing *media.SourceConfig
+	// confirm asks before saving changes that remove tracks, nil otherwise
+	confirm *ConfirmModel
+	// known holds the sources existing before the new one, to tell its
+	// scan apart from the others
+	known    map[string]bool
+	progress *common.ScanProgressMsg
+	styles   struct {
 		title lipgloss.Style
 		label lipgloss.Style
 		error lipgloss.Style
@@ -52,6 +65,15 @@ func NewAddSourceModel(manager *media.SourceManager) AddSourceModel {
 	return m
 }
 
+// NewEditSourceModel returns the form editing the name and paths of a source
+func NewEditSourceModel(manager *media.SourceManager, source media.SourceConfig) AddSourceModel {
+	m := NewAddSourceModel(manager)
+	m.editing = &source
+	m.nameInput.SetValue(source.Name)
+	m.pathsInput.SetValue(source.Config["paths"])
+	return m
+}
+
 func (m *AddSourceModel) Update(msg tea.Msg) (AddSourceModel, tea.Cmd) {
 	var cmds []tea.Cmd
 
@@ -66,24 +88,38 @@ func (m *AddSourceModel) Update(msg tea.Msg) (AddSourceModel, tea.Cmd) {
 			m.viewport.Height = msg.Height
 		}
 
-	case common.ScanTickMsg:
-		if m.scanning {
-			if progress := m.manager.GetScanProgress(); progress != nil {
-				var content strings.Builder
-				content.WriteString(m.styles.title.Render("Adding Music Source") + "\n\n")
-				content.WriteString(fmt.Sprintf("Scanning files: %d found\n", progress.Current))
-				m.viewport.SetContent(content.String())
-				return *m, common.ScanTick()
-			}
-			// Scanning finished
-			m.scanning = false
-			m.done = true
+	case common.ScanProgressMsg:
+		if m.scanning && m.isOwnScan(msg.SourceID) {
+			m.progress = &msg
+		}
+		return *m, nil
+
+	case sourceSavedMsg:
+		m.scanning = false
+		m.progress = nil
+		if msg.err != nil {
+			m.err = msg.err
+			return *m, nil
 		}
+		m.done = true
+		return *m, nil
 
 	case tea.KeyMsg:
 		if m.scanning {
 			return *m, nil // Ignore key presses while scanning
 		}
+		if m.confirm != nil {
+			confirm := m.confirm.Update(msg)
+			if !confirm.Answered() {
+				m.confirm = &confirm
+				return *m, nil
+			}
+			m.confirm = nil
+			if !confirm.Confirmed() {
+				return *m, nil
+			}
+			return *m, m.save()
+		}
 
 		switch msg.String() {
 		case "tab", "shift+tab", "enter", "up", "down":
@@ -112,23 +148,15 @@ func (m *AddSourceModel) Update(msg tea.Msg) (AddSourceModel, tea.Cmd) {
 			}
 
 			m.err = nil
-			m.scanning = true
-
-			// Add source in a goroutine to avoid blocking the UI
-			go func() {
-				err := m.manager.AddSource(
-					m.nameInput.Value(),
-					"filesystem",
-					map[string]string{
-						"paths": m.pathsInput.Value(),
-					},
+			// Tracks outside the new paths are removed by the rescan
+			if m.editing != nil && m.pathsInput.Value() != m.editing.Config["paths"] {
+				confirm := NewConfirmModel(
+					"The paths changed, tracks outside the new paths will be removed. Save?",
 				)
-				if err != nil {
-					m.err = err
-					m.scanning = false
-				}
-			}()
-			return *m, common.ScanTick()
+				m.confirm = &confirm
+				return *m, nil
+			}
+			return *m, m.save()
 		}
 	}
 
@@ -146,18 +174,62 @@ func (m *AddSourceModel) Update(msg tea.Msg) (AddSourceModel, tea.Cmd) {
 	return *m, tea.Batch(cmds...)
 }
 
+// save adds or updates the source in the background to avoid blocking the
+// UI, along with its scan
+func (m *AddSourceModel) save() tea.Cmd {
+	m.scanning = true
+	m.progress = nil
+	m.known = make(map[string]bool)
+	for _, source := range m.manager.GetSources() {
+		m.known[source.ID] = true
+	}
+
+	manager := m.manager
+	name := m.nameInput.Value()
+	config := map[string]string{
+		"paths": m.pathsInput.Value(),
+	}
+	if m.editing != nil {
+		editing := *m.editing
+		// Keep the settings the form does not show
+		for key, value := range editing.Config {
+			if _, ok := config[key]; !ok {
+				config[key] = value
+			}
+		}
+		return func() tea.Msg {
+			return sourceSavedMsg{err: manager.UpdateSource(editing.ID, name, config)}
+		}
+	}
+	return func() tea.Msg {
+		return sourceSavedMsg{err: manager.AddSource(name, "filesystem", config)}
+	}
+}
+
+// isOwnScan reports whether a scan is the one started by saving the form
+func (m AddSourceModel) isOwnScan(sourceID string) bool {
+	if m.editing != nil {
+		return sourceID == m.editing.ID
+	}
+	return !m.known[sourceID]
+}
+
 func (m AddSourceModel) View() string {
 	if !m.ready {
 		return "\n  Initializing..."
 	}
 
+	title := "Add Music Source"
+	if m.editing != nil {
+		title = "Edit Music Source"
+	}
 	var content strings.Builder
-	content.WriteString(m.styles.title.Render("Add Music Source") + "\n\n")
+	content.WriteString(m.styles.title.Render(title) + "\n\n")
 
 	if m.scanning {
-		if progress := m.manager.GetScanProgress(); progress != nil {
+		if progress := m.progress; progress != nil {
 			content.WriteString(
-				fmt.Sprintf("Scanning files: %d found\n", progress.Current),
+				fmt.Sprintf("Scanning files: %d found\n", progress.Total),
 			)
 		} else {
 			content.WriteString("Starting scan...\n")
@@ -181,6 +253,12 @@ func (m AddSourceModel) View() string {
 		content.WriteString(m.styles.error.Render(m.err.Error()) + "\n\n")
 	}
 
+	if m.confirm != nil {
+		content.WriteString(m.confirm.View())
+		m.viewport.SetContent(content.String())
+		return m.viewport.View()
+	}
+
 	// Help text
 	content.WriteString(m.styles.help.Render(
 		"tab: Switch fields • ctrl+s: Save • esc: Cancel",
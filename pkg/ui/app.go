@@ -1,10 +1,20 @@
 package ui
 
 import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
 	tea "github.com/charmbracelet/bubbletea"
 
 	"github.com/llehouerou/pulsar/pkg/db"
+	"github.com/llehouerou/pulsar/pkg/loudness"
 	"github.com/llehouerou/pulsar/pkg/media"
+	"github.com/llehouerou/pulsar/pkg/player"
+	"github.com/llehouerou/pulsar/pkg/queue"
+	"github.com/llehouerou/pulsar/pkg/scrobble"
+	"github.com/llehouerou/pulsar/pkg/ui/common"
 )
 
 type Screen int
@@ -13,6 +23,21 @@ const (
 	BrowserScreen Screen = iota
 	PlayerScreen
 	AddSourceScreen
+	IssuesScreen
+	PlaylistsScreen
+	HistoryScreen
+)
+
+const (
+	// WatchKey is the settings key enabling live library updates, "true" or
+	// "false"
+	WatchKey = "library.watch"
+	// ScanBatchKey is the settings key of the number of tracks saved per
+	// transaction during a scan
+	ScanBatchKey = "library.scan_batch"
+	// ScanFlushKey is the settings key of the longest time scanned tracks
+	// wait to be saved, in ms
+	ScanFlushKey = "library.scan_flush_ms"
 )
 
 type Model struct {
@@ -20,29 +45,116 @@ type Model struct {
 	browser       BrowserModel
 	player        PlayerModel
 	addSource     AddSourceModel
+	issues        IssuesModel
+	playlists     PlaylistsModel
+	history       HistoryModel
 	manager       *media.SourceManager
+	queue         *queue.Queue
+	// stopAnalysis cancels the running loudness analysis, nil when idle
+	stopAnalysis context.CancelFunc
+	// stopWatch stops watching the sources, nil when not watching
+	stopWatch context.CancelFunc
+	// stopScrobbler stops submitting plays to the scrobbling services
+	stopScrobbler context.CancelFunc
+	// scanEvents receives the progress of every scan until unsubscribeScans
+	// is called
+	scanEvents       <-chan media.ScanProgress
+	unsubscribeScans func()
+}
+
+type analysisTickMsg struct{}
+
+type analysisDoneMsg struct {
+	err error
+}
+
+func analysisTick() tea.Cmd {
+	return tea.Tick(time.Second/2, func(time.Time) tea.Msg {
+		return analysisTickMsg{}
+	})
 }
 
 func NewModel(database *db.DB) Model {
 	manager := media.NewSourceManager(database)
-	// Register filesystem source type
-	manager.RegisterSourceType("filesystem", media.NewFilesystemSourceFactory())
+	// Register filesystem source type, indexing the formats the player decodes
+	manager.RegisterSourceType("filesystem", media.NewFilesystemSourceFactory(player.SupportedExtensions()))
+	manager.SetScanBatching(scanBatching(database))
 	// Load existing sources
 	if err := manager.LoadSources(); err != nil {
 		panic(err)
 	}
 
-	return Model{
-		currentScreen: BrowserScreen,
-		browser:       NewBrowserModel(manager),
-		player:        NewPlayerModel(),
-		addSource:     NewAddSourceModel(manager),
-		manager:       manager,
+	// Restore the play queue from the previous session
+	q := queue.New(database)
+	if err := q.Load(); err != nil {
+		panic(err)
+	}
+
+	// Submit the plays to the scrobbling services in the background
+	services, err := scrobble.Services(database)
+	if err != nil {
+		panic(err)
+	}
+	scrobbler := scrobble.New(database, services...)
+	scrobbleCtx, stopScrobbler := context.WithCancel(context.Background())
+	go scrobbler.Run(scrobbleCtx)
+
+	scanEvents, unsubscribeScans := manager.Subscribe()
+	m := Model{
+		currentScreen:    BrowserScreen,
+		browser:          NewBrowserModel(manager, q),
+		player:           NewPlayerModel(q, database, database, scrobbler),
+		addSource:        NewAddSourceModel(manager),
+		issues:           NewIssuesModel(manager),
+		playlists:        NewPlaylistsModel(database, q),
+		history:          NewHistoryModel(database, q),
+		manager:          manager,
+		queue:            q,
+		stopScrobbler:    stopScrobbler,
+		scanEvents:       scanEvents,
+		unsubscribeScans: unsubscribeScans,
+	}
+
+	if watch, err := database.GetSetting(WatchKey); err == nil && watch == "true" {
+		ctx, cancel := context.WithCancel(context.Background())
+		m.stopWatch = cancel
+		go manager.WatchSources(ctx)
+	}
+	return m
+}
+
+// scanBatching reads how scanned tracks are saved from the settings, zero
+// values keeping the defaults of the manager
+func scanBatching(settings settingsStore) (size int, interval time.Duration) {
+	if value, err := settings.GetSetting(ScanBatchKey); err == nil && value != "" {
+		size, _ = strconv.Atoi(value)
+	}
+	if value, err := settings.GetSetting(ScanFlushKey); err == nil && value != "" {
+		if ms, err := strconv.Atoi(value); err == nil {
+			interval = time.Duration(ms) * time.Millisecond
+		}
 	}
+	return size, interval
 }
 
 func (m Model) Init() tea.Cmd {
-	return nil
+	return tea.Batch(
+		m.player.Init(),
+		m.waitForLibraryChange(),
+		common.WaitForScanProgress(m.scanEvents),
+	)
+}
+
+type libraryChangedMsg struct {
+	sourceID string
+}
+
+// waitForLibraryChange waits for watching to update a source
+func (m Model) waitForLibraryChange() tea.Cmd {
+	changes := m.manager.Changes()
+	return func() tea.Msg {
+		return libraryChangedMsg{<-changes}
+	}
 }
 
 func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -52,6 +164,58 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.browser, _ = m.browser.Update(msg)
 		m.player, _ = m.player.Update(msg)
 		m.addSource, _ = m.addSource.Update(msg)
+		m.issues, _ = m.issues.Update(msg)
+		m.playlists, _ = m.playlists.Update(msg)
+		m.history, _ = m.history.Update(msg)
+	}
+
+	// Playback messages reach the player whatever the current screen
+	switch msg := msg.(type) {
+	case tickMsg, playerStartedMsg, playerErrorMsg, trackEndedMsg:
+		m.player, cmd = m.player.Update(msg)
+		return m, cmd
+	case libraryChangedMsg:
+		// Refresh the browser whatever the current screen
+		m.browser.Refresh(msg.sourceID)
+		return m, m.waitForLibraryChange()
+	case common.ScanProgressMsg:
+		// Scans run in the background, keep every screen up to date
+		var browserCmd, addSourceCmd tea.Cmd
+		m.browser, browserCmd = m.browser.Update(msg)
+		m.addSource, addSourceCmd = m.addSource.Update(msg)
+		m.issues, _ = m.issues.Update(msg)
+		return m, tea.Batch(
+			browserCmd,
+			addSourceCmd,
+			common.WaitForScanProgress(m.scanEvents),
+		)
+	case sourceRemovedMsg:
+		if msg.err != nil {
+			m.browser.err = msg.err
+			return m, nil
+		}
+		// The queue drops the tracks that no longer exist
+		cmd, err := m.player.removeSource(msg.sourceID)
+		if err != nil {
+			m.browser.err = err
+		}
+		m.browser.loadSources()
+		return m, cmd
+	case analysisTickMsg:
+		// Keep refreshing the progress while the analysis runs
+		if m.stopAnalysis != nil {
+			return m, analysisTick()
+		}
+		return m, nil
+	case analysisDoneMsg:
+		if m.stopAnalysis != nil {
+			m.stopAnalysis()
+			m.stopAnalysis = nil
+		}
+		if msg.err != nil && !errors.Is(msg.err, context.Canceled) {
+			m.browser.err = msg.err
+		}
+		return m, nil
 	}
 
 	switch m.currentScreen {
@@ -61,6 +225,12 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m.updatePlayer(msg)
 	case AddSourceScreen:
 		return m.updateAddSource(msg)
+	case IssuesScreen:
+		return m.updateIssues(msg)
+	case PlaylistsScreen:
+		return m.updatePlaylists(msg)
+	case HistoryScreen:
+		return m.updateHistory(msg)
 	}
 	return m, cmd
 }
@@ -76,38 +246,123 @@ func (m Model) updateBrowser(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if path == "ADD_SOURCE" {
 			m.currentScreen = AddSourceScreen
 			m.browser.ClearSelection()
+			m.openSourceForm(NewAddSourceModel(m.manager))
+			return m, cmd
+		}
+		if path == "EDIT_SOURCE" {
+			m.browser.ClearSelection()
+			if source, ok := m.browser.SelectedSource(); ok {
+				m.currentScreen = AddSourceScreen
+				m.openSourceForm(NewEditSourceModel(m.manager, source))
+			}
+			return m, cmd
+		}
+		if path == "SCAN_ISSUES" {
+			m.browser.ClearSelection()
+			if source, ok := m.browser.SelectedSource(); ok {
+				m.issues.Open(source)
+				m.currentScreen = IssuesScreen
+			}
+			return m, cmd
+		}
+		if path == "PLAYLISTS" {
+			m.browser.ClearSelection()
+			m.playlists.Open()
+			m.currentScreen = PlaylistsScreen
+			return m, cmd
+		}
+		if path == "HISTORY" {
+			m.browser.ClearSelection()
+			m.history.Open()
+			m.currentScreen = HistoryScreen
+			return m, cmd
+		}
+		if path == "ADD_TO_PLAYLIST" {
+			m.browser.ClearSelection()
+			tracks := m.browser.TracksToAdd()
+			target, ok := m.playlists.Target()
+			if !ok {
+				// Pick the playlist, it receives the next tracks too
+				m.playlists.Pick(tracks)
+				m.currentScreen = PlaylistsScreen
+				return m, cmd
+			}
+			if err := m.playlists.AddTracks(tracks); err != nil {
+				m.browser.err = err
+				return m, cmd
+			}
+			m.browser.SetStatus(addedStatus(len(tracks), target.Name))
 			return m, cmd
 		}
 
-		// Stop the current player before starting a new one
-		m.player.Stop()
 		m.currentScreen = PlayerScreen
-		m.player = NewPlayerModel()
-		// Initialize the new player with the current window size
-		if m.browser.ready {
-			m.player, _ = m.player.Update(tea.WindowSizeMsg{
-				Width:  m.browser.viewport.Width,
-				Height: m.browser.viewport.Height,
-			})
-		}
 		// Clear the selection so we don't keep triggering it
 		m.browser.ClearSelection()
 		// Return a batch of commands - both the browser update and starting playback
-		return m, tea.Batch(cmd, m.player.StartPlayback(path))
+		return m, tea.Batch(cmd, m.player.PlayCurrent())
 	}
 
-	// Handle other key events
+	// Handle other key events, unless they are typed into the search
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if m.browser.Typing() && msg.String() != "ctrl+c" {
+			return m, cmd
+		}
 		switch msg.String() {
 		case "ctrl+c", "q":
+			m.shutdown()
 			return m, tea.Quit
+		case "p":
+			m.currentScreen = PlayerScreen
+			return m, cmd
+		case "L":
+			if m.stopAnalysis != nil {
+				m.cancelAnalysis()
+				return m, cmd
+			}
+			return m, tea.Batch(cmd, m.startAnalysis())
 		}
 	}
 
 	return m, cmd
 }
 
+// startAnalysis measures the loudness of the tracks not analyzed yet in the
+// background
+func (m *Model) startAnalysis() tea.Cmd {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.stopAnalysis = cancel
+	manager := m.manager
+	return tea.Batch(
+		func() tea.Msg {
+			return analysisDoneMsg{err: manager.AnalyzeLoudness(ctx, loudness.Analyze)}
+		},
+		analysisTick(),
+	)
+}
+
+// shutdown stops the background jobs and saves the listen in progress before
+// quitting
+func (m *Model) shutdown() {
+	m.player.endListen()
+	m.stopScrobbler()
+	m.cancelAnalysis()
+	m.unsubscribeScans()
+	if m.stopWatch != nil {
+		m.stopWatch()
+		m.stopWatch = nil
+	}
+}
+
+// cancelAnalysis stops the running loudness analysis, the measured tracks
+// are kept and the next run resumes with the others
+func (m *Model) cancelAnalysis() {
+	if m.stopAnalysis != nil {
+		m.stopAnalysis()
+		m.stopAnalysis = nil
+	}
+}
+
 func (m Model) updatePlayer(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmd tea.Cmd
 	switch msg := msg.(type) {
@@ -117,6 +372,7 @@ func (m Model) updatePlayer(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.currentScreen = BrowserScreen
 			return m, nil
 		case "ctrl+c", "q":
+			m.shutdown()
 			return m, tea.Quit
 		}
 	}
@@ -125,6 +381,17 @@ func (m Model) updatePlayer(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, cmd
 }
 
+// openSourceForm shows a new add or edit source form at the window size
+func (m *Model) openSourceForm(form AddSourceModel) {
+	if m.addSource.ready {
+		form, _ = form.Update(tea.WindowSizeMsg{
+			Width:  m.addSource.viewport.Width,
+			Height: m.addSource.viewport.Height,
+		})
+	}
+	m.addSource = form
+}
+
 func (m Model) updateAddSource(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmd tea.Cmd
 	m.addSource, cmd = m.addSource.Update(msg)
@@ -132,7 +399,7 @@ func (m Model) updateAddSource(msg tea.Msg) (tea.Model, tea.Cmd) {
 	if m.addSource.Done() {
 		m.currentScreen = BrowserScreen
 		// Create a new browser model to refresh the sources
-		m.browser = NewBrowserModel(m.manager)
+		m.browser = NewBrowserModel(m.manager, m.queue)
 		// Initialize the browser with the current window size
 		if m.addSource.ready {
 			m.browser, _ = m.browser.Update(tea.WindowSizeMsg{
@@ -145,6 +412,54 @@ func (m Model) updateAddSource(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, cmd
 }
 
+func (m Model) updateIssues(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+	m.issues, cmd = m.issues.Update(msg)
+	if m.issues.Done() {
+		m.currentScreen = BrowserScreen
+	}
+	return m, cmd
+}
+
+func (m Model) updatePlaylists(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+	if msg, ok := msg.(tea.KeyMsg); ok && msg.String() == "ctrl+c" {
+		m.shutdown()
+		return m, tea.Quit
+	}
+
+	m.playlists, cmd = m.playlists.Update(msg)
+	if m.playlists.Playing() {
+		m.playlists.Open()
+		m.currentScreen = PlayerScreen
+		return m, tea.Batch(cmd, m.player.PlayCurrent())
+	}
+	if m.playlists.Done() {
+		m.currentScreen = BrowserScreen
+		m.browser.SetStatus(m.playlists.Status())
+	}
+	return m, cmd
+}
+
+func (m Model) updateHistory(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+	if msg, ok := msg.(tea.KeyMsg); ok && msg.String() == "ctrl+c" {
+		m.shutdown()
+		return m, tea.Quit
+	}
+
+	m.history, cmd = m.history.Update(msg)
+	if m.history.Playing() {
+		m.history.Open()
+		m.currentScreen = PlayerScreen
+		return m, tea.Batch(cmd, m.player.PlayCurrent())
+	}
+	if m.history.Done() {
+		m.currentScreen = BrowserScreen
+	}
+	return m, cmd
+}
+
 func (m Model) View() string {
 	switch m.currentScreen {
 	case BrowserScreen:
@@ -153,6 +468,12 @@ func (m Model) View() string {
 		return m.player.View()
 	case AddSourceScreen:
 		return m.addSource.View()
+	case IssuesScreen:
+		return m.issues.View()
+	case PlaylistsScreen:
+		return m.playlists.View()
+	case HistoryScreen:
+		return m.history.View()
 	default:
 		return "Unknown screen"
 	}
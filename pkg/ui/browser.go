@@ -7,11 +7,13 @@ import (
 	"time"
 
 	"github.com/charmbracelet/bubbles/progress"
+	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 
 	"github.com/llehouerou/pulsar/pkg/media"
+	"github.com/llehouerou/pulsar/pkg/queue"
 	"github.com/llehouerou/pulsar/pkg/ui/common"
 )
 
@@ -20,6 +22,13 @@ type BrowserMode int
 const (
 	SourcesMode BrowserMode = iota
 	TracksMode
+	// ArtistsMode, AlbumsMode and AlbumTracksMode browse the library of all
+	// sources
+	ArtistsMode
+	AlbumsMode
+	AlbumTracksMode
+	// SearchMode shows the tracks of all sources matching a query
+	SearchMode
 )
 
 const (
@@ -33,14 +42,30 @@ type BrowserModel struct {
 	currentSource string
 	sourceCursor  int
 	trackCursor   int
+	artists       []media.ArtistSummary
+	albums        []media.AlbumSummary
+	artistCursor  int
+	albumCursor   int
+	searchInput   textinput.Model
+	results       []media.Track
+	resultCursor  int
+	// searchReturn is the mode shown again when the search is closed
+	searchReturn  BrowserMode
 	selectedTrack string
 	err           error
 	viewport      viewport.Model
 	ready         bool
 	manager       *media.SourceManager
+	queue         *queue.Queue
 	progress      progress.Model
-	scanning      bool
-	styles        struct {
+	// confirm asks before removing the source in removing, nil otherwise
+	confirm  *ConfirmModel
+	removing media.SourceConfig
+	// adding holds the tracks to add to a playlist, status reports the
+	// result
+	adding []media.Track
+	status string
+	styles struct {
 		title    lipgloss.Style
 		source   lipgloss.Style
 		track    lipgloss.Style
@@ -51,6 +76,12 @@ type BrowserModel struct {
 	}
 }
 
+// sourceRemovedMsg reports the end of removing a source
+type sourceRemovedMsg struct {
+	sourceID string
+	err      error
+}
+
 type scanTickMsg struct{}
 
 func scanTick() tea.Cmd {
@@ -59,12 +90,13 @@ func scanTick() tea.Cmd {
 	})
 }
 
-func NewBrowserModel(manager *media.SourceManager) BrowserModel {
+func NewBrowserModel(manager *media.SourceManager, q *queue.Queue) BrowserModel {
 	m := BrowserModel{
 		mode:         SourcesMode,
 		sourceCursor: 0,
 		trackCursor:  0,
 		manager:      manager,
+		queue:        q,
 		progress: progress.New(
 			progress.WithScaledGradient("#FF7CCB", "#FDFF8C"),
 		),
@@ -100,6 +132,31 @@ func (m *BrowserModel) loadTracks() error {
 	return nil
 }
 
+// Refresh reloads the sources and, when a source's tracks are shown and it
+// changed, its tracks, keeping the cursor where it was
+func (m *BrowserModel) Refresh(sourceID string) {
+	m.sources = m.manager.GetSources()
+	m.sourceCursor = min(m.sourceCursor, max(0, len(m.sources)-1))
+	if m.mode == SearchMode {
+		m.runSearch()
+		return
+	}
+	if m.isLibraryMode() {
+		m.refreshLibrary()
+		return
+	}
+	if m.mode != TracksMode || m.currentSource != sourceID {
+		return
+	}
+
+	cursor := m.trackCursor
+	if err := m.loadTracks(); err != nil {
+		m.err = err
+		return
+	}
+	m.trackCursor = min(cursor, max(0, len(m.tracks)-1))
+}
+
 func (m *BrowserModel) Update(msg tea.Msg) (BrowserModel, tea.Cmd) {
 	var cmd tea.Cmd
 	switch msg := msg.(type) {
@@ -116,71 +173,38 @@ func (m *BrowserModel) Update(msg tea.Msg) (BrowserModel, tea.Cmd) {
 			m.progress.Width = msg.Width - 20
 		}
 
-	case common.ScanTickMsg:
-		if m.scanning {
-			if progress := m.manager.GetScanProgress(); progress != nil {
-				return *m, common.ScanTick()
-			}
-			// Scanning finished
-			m.scanning = false
-			if err := m.loadTracks(); err != nil {
-				m.err = err
-			}
+	case common.ScanProgressMsg:
+		// Show the tracks found once the scan of the open source is over
+		if msg.Done && msg.Err == nil {
+			m.Refresh(msg.SourceID)
 		}
 
 	case tea.KeyMsg:
+		m.status = ""
+		if m.confirm != nil {
+			return *m, m.updateConfirm(msg)
+		}
+		if m.mode == SearchMode {
+			return *m, m.updateSearch(msg)
+		}
+
 		switch msg.String() {
 		case "up":
-			switch m.mode {
-			case SourcesMode:
-				if m.sourceCursor > 0 {
-					m.sourceCursor--
-					// Update viewport position
-					if m.sourceCursor < m.viewport.YOffset+scrollMargin {
-						m.viewport.YOffset = max(0, m.sourceCursor-scrollMargin)
-					}
-				}
-			case TracksMode:
-				if m.trackCursor > 0 {
-					m.trackCursor--
-					// Update viewport position
-					if m.trackCursor < m.viewport.YOffset+scrollMargin {
-						m.viewport.YOffset = max(0, m.trackCursor-scrollMargin)
-					}
-				}
-			}
+			m.moveCursor(-1)
 		case "down":
+			m.moveCursor(1)
+		case "tab":
+			m.toggleLibrary()
+		case "/":
+			return *m, m.startSearch()
+		case "backspace", "esc":
 			switch m.mode {
-			case SourcesMode:
-				if m.sourceCursor < len(m.sources)-1 {
-					m.sourceCursor++
-					// Update viewport position
-					if m.sourceCursor >= m.viewport.YOffset+m.viewport.Height-scrollMargin {
-						maxOffset := max(0, len(m.sources)-m.viewport.Height+scrollMargin)
-						m.viewport.YOffset = min(
-							m.sourceCursor-m.viewport.Height+1+scrollMargin,
-							maxOffset,
-						)
-					}
-				}
 			case TracksMode:
-				if m.trackCursor < len(m.tracks)-1 {
-					m.trackCursor++
-					// Update viewport position
-					if m.trackCursor >= m.viewport.YOffset+m.viewport.Height-scrollMargin {
-						maxOffset := max(0, len(m.tracks)-m.viewport.Height+scrollMargin)
-						m.viewport.YOffset = min(
-							m.trackCursor-m.viewport.Height+1+scrollMargin,
-							maxOffset,
-						)
-					}
-				}
-			}
-		case "backspace", "esc":
-			if m.mode == TracksMode {
 				m.mode = SourcesMode
 				m.currentSource = ""
 				m.viewport.YOffset = 0
+			case AlbumsMode, AlbumTracksMode:
+				m.libraryBack()
 			}
 		case "enter":
 			switch m.mode {
@@ -193,26 +217,172 @@ func (m *BrowserModel) Update(msg tea.Msg) (BrowserModel, tea.Cmd) {
 						m.err = err
 					}
 				}
-			case TracksMode:
+			case ArtistsMode, AlbumsMode:
+				m.libraryOpen()
+			case TracksMode, AlbumTracksMode:
 				if len(m.tracks) > 0 && m.trackCursor < len(m.tracks) {
+					// Queue the whole list so playback continues after this track
+					if err := m.queue.Replace(m.tracks, m.trackCursor); err != nil {
+						m.err = err
+						break
+					}
 					m.selectedTrack = m.tracks[m.trackCursor].Path
 				}
 			}
+		case "n":
+			if m.showsTracks() && m.trackCursor < len(m.tracks) {
+				if err := m.queue.EnqueueNext(m.tracks[m.trackCursor]); err != nil {
+					m.err = err
+				}
+			}
+		case "e":
+			if m.mode == SourcesMode && len(m.sources) > 0 {
+				m.selectedTrack = "EDIT_SOURCE"
+			}
+			if m.showsTracks() && m.trackCursor < len(m.tracks) {
+				if err := m.queue.EnqueueLast(m.tracks[m.trackCursor]); err != nil {
+					m.err = err
+				}
+			}
 		case "a":
 			if m.mode == SourcesMode {
 				m.selectedTrack = "ADD_SOURCE"
 			}
+		case "P":
+			m.selectedTrack = "PLAYLISTS"
+		case "H":
+			m.selectedTrack = "HISTORY"
+		case "+":
+			m.addToPlaylist()
+		case "d":
+			if source, ok := m.SelectedSource(); ok && m.mode == SourcesMode {
+				confirm := NewConfirmModel(fmt.Sprintf(
+					"Remove %q and its tracks from the library?",
+					source.Name,
+				))
+				m.confirm = &confirm
+				m.removing = source
+			}
+		case "i":
+			if _, ok := m.SelectedSource(); ok && !m.isLibraryMode() {
+				m.selectedTrack = "SCAN_ISSUES"
+			}
 		case "r":
-			if m.mode == TracksMode && !m.scanning {
-				m.scanning = true
+			if m.mode == TracksMode && !m.isScanning(m.currentSource) {
+				// Failures show in the scan progress
 				go m.manager.ScanSource(context.Background(), m.currentSource)
-				return *m, common.ScanTick()
+			}
+		case "R":
+			if m.mode == SourcesMode {
+				go m.manager.ScanAll(context.Background())
+			}
+		case "x":
+			if m.mode == TracksMode {
+				m.manager.CancelScan(m.currentSource)
 			}
 		}
 	}
 	return *m, cmd
 }
 
+// cursor returns the cursor of the list shown and the length of the list
+func (m *BrowserModel) cursor() (*int, int) {
+	switch m.mode {
+	case TracksMode, AlbumTracksMode:
+		return &m.trackCursor, len(m.tracks)
+	case ArtistsMode:
+		return &m.artistCursor, len(m.artists)
+	case AlbumsMode:
+		return &m.albumCursor, len(m.albums)
+	case SearchMode:
+		return &m.resultCursor, len(m.results)
+	default:
+		return &m.sourceCursor, len(m.sources)
+	}
+}
+
+// moveCursor moves the cursor of the list shown by delta, scrolling to keep
+// a margin around it
+func (m *BrowserModel) moveCursor(delta int) {
+	cursor, count := m.cursor()
+	next := *cursor + delta
+	if next < 0 || next >= count {
+		return
+	}
+	*cursor = next
+
+	// Update viewport position
+	if delta < 0 && next < m.viewport.YOffset+scrollMargin {
+		m.viewport.YOffset = max(0, next-scrollMargin)
+	}
+	if delta > 0 && next >= m.viewport.YOffset+m.viewport.Height-scrollMargin {
+		maxOffset := max(0, count-m.viewport.Height+scrollMargin)
+		m.viewport.YOffset = min(next-m.viewport.Height+1+scrollMargin, maxOffset)
+	}
+}
+
+// showsTracks reports whether the list shown is a list of tracks
+func (m BrowserModel) showsTracks() bool {
+	return m.mode == TracksMode || m.mode == AlbumTracksMode
+}
+
+// addToPlaylist selects the track or the album under the cursor to be added
+// to a playlist
+func (m *BrowserModel) addToPlaylist() {
+	switch {
+	case m.showsTracks() && m.trackCursor < len(m.tracks):
+		m.adding = []media.Track{m.tracks[m.trackCursor]}
+	case m.mode == AlbumsMode && m.albumCursor < len(m.albums):
+		tracks, err := m.manager.GetAlbumTracks(m.albums[m.albumCursor])
+		if err != nil {
+			m.err = err
+			return
+		}
+		m.adding = tracks
+	default:
+		return
+	}
+	m.selectedTrack = "ADD_TO_PLAYLIST"
+}
+
+// TracksToAdd returns the tracks selected to be added to a playlist and
+// clears them
+func (m *BrowserModel) TracksToAdd() []media.Track {
+	tracks := m.adding
+	m.adding = nil
+	return tracks
+}
+
+// SetStatus shows a message until the next key press
+func (m *BrowserModel) SetStatus(status string) {
+	m.status = status
+}
+
+// updateConfirm handles the answer to the removal prompt
+func (m *BrowserModel) updateConfirm(msg tea.Msg) tea.Cmd {
+	confirm := m.confirm.Update(msg)
+	if !confirm.Answered() {
+		m.confirm = &confirm
+		return nil
+	}
+	m.confirm = nil
+	if !confirm.Confirmed() {
+		return nil
+	}
+
+	// Removing waits for the running scan of the source to stop
+	manager, sourceID := m.manager, m.removing.ID
+	return func() tea.Msg {
+		return sourceRemovedMsg{sourceID: sourceID, err: manager.RemoveSource(sourceID)}
+	}
+}
+
+// isScanning reports whether a scan of the source is running
+func (m BrowserModel) isScanning(sourceID string) bool {
+	progress := m.manager.GetScanProgress(sourceID)
+	return progress != nil && !progress.Done
+}
+
 func max(a, b int) int {
 	if a > b {
 		return a
@@ -247,36 +417,70 @@ func (m BrowserModel) View() string {
 					cursor = m.styles.cursor.Render(">")
 				}
 				name := m.styles.source.Render(source.Name)
-				list.WriteString(fmt.Sprintf("%s %s\n", cursor, name))
+				status := ""
+				if progress := m.manager.GetScanProgress(source.ID); progress != nil {
+					status = m.styles.status.Render(fmt.Sprintf(
+						" %s (%d/%d files)",
+						progress.Status,
+						progress.Current,
+						progress.Total,
+					))
+				}
+				list.WriteString(fmt.Sprintf("%s %s%s\n", cursor, name, status))
 			}
 			if len(m.sources) == 0 {
 				list.WriteString("No sources configured. Press 'a' to add a source.")
+			} else {
+				list.WriteString(m.styles.status.Render(
+					"\ntab: Library • /: Search • P: Playlists • H: History • e: Edit • d: Remove • i: Scan issues • R: Rescan all sources",
+				))
+			}
+			if m.confirm != nil {
+				list.WriteString("\n\n" + m.confirm.View())
 			}
 			content = list.String()
 
 		case TracksMode:
-			if m.sourceCursor >= len(m.sources) {
+			source, ok := m.SelectedSource()
+			if !ok {
 				content = "No source selected."
 				break
 			}
-
-			source := m.sources[m.sourceCursor]
 			title = source.Name
 
 			var list strings.Builder
 			// Show scanning progress if active
-			if progress := m.manager.GetScanProgress(); progress != nil && progress.SourceID == m.currentSource {
+			if progress := m.manager.GetScanProgress(m.currentSource); progress != nil {
 				var percent float64
 				if progress.Total > 0 {
 					percent = float64(progress.Current) / float64(progress.Total)
 				}
+				help := ""
+				if !progress.Done {
+					help = " • x: Cancel"
+				}
 				list.WriteString(m.styles.progress.Render(m.progress.ViewAs(percent)) + "\n")
 				list.WriteString(m.styles.status.Render(
 					fmt.Sprintf(
-						"%s (%d/%d files)\n\n",
+						"%s (%d/%d files, %d added, %d updated, %d issues)%s\n\n",
 						progress.Status,
 						progress.Current,
 						progress.Total,
+						progress.Added,
+						progress.Updated,
+						progress.Issues,
+						help,
+					),
+				))
+			} else if last := m.manager.GetLastScan(m.currentSource); last != nil {
+				list.WriteString(m.styles.status.Render(
+					fmt.Sprintf(
+						"Last scan: %d added, %d updated, %d moved, %d removed, %d issues (i: Show)\n\n",
+						last.Added,
+						last.Updated,
+						last.Moved,
+						last.Removed,
+						last.Issues,
 					),
 				))
 			}
@@ -303,6 +507,26 @@ func (m BrowserModel) View() string {
 				list.WriteString("No tracks found. Press 'r' to rescan.")
 			}
 			content = list.String()
+
+		case ArtistsMode, AlbumsMode, AlbumTracksMode:
+			title, content = m.viewLibrary()
+
+		case SearchMode:
+			title = "Search"
+			content = m.viewSearch()
+		}
+
+		if progress := m.manager.GetAnalysisProgress(); progress != nil {
+			content = m.styles.status.Render(fmt.Sprintf(
+				"Analyzing loudness (%d/%d tracks, %d failed)",
+				progress.Current,
+				progress.Total,
+				progress.Failed,
+			)) + "\n\n" + content
+		}
+
+		if m.status != "" {
+			content += "\n\n" + m.styles.status.Render(m.status)
 		}
 
 		// Add title above viewport
@@ -317,6 +541,24 @@ func (m *BrowserModel) SelectedFile() (bool, string) {
 	return m.selectedTrack != "", m.selectedTrack
 }
 
+// SelectedSource returns the source under the cursor, or the one whose
+// tracks are shown
+func (m *BrowserModel) SelectedSource() (media.SourceConfig, bool) {
+	if m.mode == TracksMode {
+		// The list may have been reloaded since the source was opened
+		for _, source := range m.sources {
+			if source.ID == m.currentSource {
+				return source, true
+			}
+		}
+		return media.SourceConfig{}, false
+	}
+	if m.sourceCursor >= len(m.sources) {
+		return media.SourceConfig{}, false
+	}
+	return m.sources[m.sourceCursor], true
+}
+
 func (m *BrowserModel) ClearSelection() {
 	m.selectedTrack = ""
 }
@@ -1,15 +1,22 @@
 package common
 
 import (
-	"time"
-
 	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/llehouerou/pulsar/pkg/media"
 )
 
-type ScanTickMsg struct{}
+// ScanProgressMsg carries a progress event of a source scan
+type ScanProgressMsg media.ScanProgress
 
-func ScanTick() tea.Cmd {
-	return tea.Tick(time.Second/10, func(time.Time) tea.Msg {
-		return ScanTickMsg{}
-	})
+// WaitForScanProgress waits for the next scan progress event, it returns no
+// message once the subscription is closed
+func WaitForScanProgress(events <-chan media.ScanProgress) tea.Cmd {
+	return func() tea.Msg {
+		progress, ok := <-events
+		if !ok {
+			return nil
+		}
+		return ScanProgressMsg(progress)
+	}
 }
@@ -0,0 +1,54 @@
+package ui
+
+import (
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// ConfirmModel asks a yes or no question before a destructive action
+type ConfirmModel struct {
+	prompt    string
+	answered  bool
+	confirmed bool
+	styles    struct {
+		prompt lipgloss.Style
+		help   lipgloss.Style
+	}
+}
+
+func NewConfirmModel(prompt string) ConfirmModel {
+	m := ConfirmModel{
+		prompt: prompt,
+	}
+	m.styles.prompt = lipgloss.NewStyle().Foreground(lipgloss.Color("11"))
+	m.styles.help = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+	return m
+}
+
+func (m *ConfirmModel) Update(msg tea.Msg) ConfirmModel {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "y", "Y":
+			m.answered = true
+			m.confirmed = true
+		case "n", "N", "esc":
+			m.answered = true
+		}
+	}
+	return *m
+}
+
+func (m ConfirmModel) View() string {
+	return m.styles.prompt.Render(m.prompt) + "\n" +
+		m.styles.help.Render("y: Yes • n: No")
+}
+
+// Answered reports whether the question was answered
+func (m ConfirmModel) Answered() bool {
+	return m.answered
+}
+
+// Confirmed reports whether the answer was yes
+func (m ConfirmModel) Confirmed() bool {
+	return m.confirmed
+}
@@ -0,0 +1,233 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+	"github.com/llehouerou/pulsar/pkg/queue"
+)
+
+// historyLimit bounds the number of listens shown in the history
+const historyLimit = 500
+
+// errTrackRemoved is shown when playing a listen of a track no longer in
+// the library
+var errTrackRemoved = errors.New("track removed from the library")
+
+// historyStore reads the listening history
+type historyStore interface {
+	GetPlays(limit int, withSkips bool) ([]media.Play, error)
+}
+
+// HistoryModel lists the latest listens, most recent first
+type HistoryModel struct {
+	store  historyStore
+	queue  *queue.Queue
+	plays  []media.Play
+	cursor int
+	// skips shows the listens left before counting as plays
+	skips    bool
+	play     bool
+	done     bool
+	err      error
+	viewport viewport.Model
+	ready    bool
+	styles   struct {
+		title    lipgloss.Style
+		track    lipgloss.Style
+		cursor   lipgloss.Style
+		metadata lipgloss.Style
+		skipped  lipgloss.Style
+		error    lipgloss.Style
+		help     lipgloss.Style
+	}
+}
+
+func NewHistoryModel(store historyStore, q *queue.Queue) HistoryModel {
+	m := HistoryModel{
+		store: store,
+		queue: q,
+	}
+
+	m.styles.title = lipgloss.NewStyle().
+		Bold(true).
+		Underline(true).
+		MarginBottom(1)
+	m.styles.track = lipgloss.NewStyle().Foreground(lipgloss.Color("7"))
+	m.styles.cursor = lipgloss.NewStyle().Foreground(lipgloss.Color("11"))
+	m.styles.metadata = lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+	m.styles.skipped = lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+	m.styles.error = lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+	m.styles.help = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+
+	return m
+}
+
+// Open shows the history from its latest listen
+func (m *HistoryModel) Open() {
+	m.play = false
+	m.done = false
+	m.err = nil
+	m.cursor = 0
+	m.viewport.YOffset = 0
+	m.load()
+}
+
+func (m *HistoryModel) load() {
+	m.plays, m.err = m.store.GetPlays(historyLimit, m.skips)
+	m.cursor = min(m.cursor, max(0, len(m.plays)-1))
+}
+
+func (m *HistoryModel) Update(msg tea.Msg) (HistoryModel, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		if !m.ready {
+			m.viewport = viewport.New(msg.Width, msg.Height)
+			m.ready = true
+		} else {
+			m.viewport.Width = msg.Width
+			m.viewport.Height = msg.Height
+		}
+
+	case tea.KeyMsg:
+		m.err = nil
+		switch msg.String() {
+		case "up":
+			m.cursor = max(0, m.cursor-1)
+		case "down":
+			m.cursor = min(m.cursor+1, max(0, len(m.plays)-1))
+		case "pgup":
+			m.cursor = max(0, m.cursor-m.viewport.Height/2)
+		case "pgdown":
+			m.cursor = min(m.cursor+m.viewport.Height/2, max(0, len(m.plays)-1))
+		case "esc", "backspace":
+			m.done = true
+		case "s":
+			m.skips = !m.skips
+			m.load()
+		case "enter":
+			if m.cursor < len(m.plays) {
+				if m.plays[m.cursor].Removed {
+					m.err = errTrackRemoved
+					break
+				}
+				// Queue the listed tracks still in the library so playback
+				// goes on back in time
+				var tracks []media.Track
+				current := 0
+				for i, play := range m.plays {
+					if play.Removed {
+						continue
+					}
+					if i == m.cursor {
+						current = len(tracks)
+					}
+					tracks = append(tracks, play.Track)
+				}
+				if err := m.queue.Replace(tracks, current); err != nil {
+					m.err = err
+					break
+				}
+				m.play = true
+			}
+		}
+	}
+	m.follow()
+	return *m, nil
+}
+
+// follow scrolls to keep the line under the cursor visible
+func (m *HistoryModel) follow() {
+	const header = 3 // Title and spacing
+	line := header + m.cursor
+	if line-scrollMargin < m.viewport.YOffset {
+		m.viewport.YOffset = max(0, line-scrollMargin)
+	}
+	if line+scrollMargin >= m.viewport.YOffset+m.viewport.Height {
+		m.viewport.YOffset = max(0, line+scrollMargin-m.viewport.Height+1)
+	}
+}
+
+func (m HistoryModel) View() string {
+	if !m.ready {
+		return "\n  Initializing..."
+	}
+
+	var content strings.Builder
+	content.WriteString(m.styles.title.Render("History") + "\n\n")
+
+	for i, play := range m.plays {
+		cursor := " "
+		if i == m.cursor {
+			cursor = m.styles.cursor.Render(">")
+		}
+		track := play.Track
+		title := track.Title
+		if title == "" {
+			title = "Unknown Title"
+		}
+		artist := track.Artist
+		if artist == "" {
+			artist = "Unknown Artist"
+		}
+		listened := m.styles.metadata.Render(fmt.Sprintf(
+			" (%s, %s)", formatDuration(play.Listened), playCount(track.PlayCount),
+		))
+		if play.Removed {
+			listened = m.styles.metadata.Render(fmt.Sprintf(
+				" (%s, removed from the library)", formatDuration(play.Listened),
+			))
+		}
+		if play.Skipped {
+			listened = m.styles.skipped.Render(fmt.Sprintf(
+				" (skipped after %s)", formatDuration(play.Listened),
+			))
+		}
+		content.WriteString(fmt.Sprintf(
+			"%s %s %s%s%s\n",
+			cursor,
+			m.styles.metadata.Render(play.StartedAt.Format("2006-01-02 15:04")),
+			m.styles.track.Render(title),
+			m.styles.metadata.Render(" - "+artist),
+			listened,
+		))
+	}
+	if len(m.plays) == 0 {
+		content.WriteString("Nothing played yet. Tracks appear here once half played, or after four minutes.\n")
+	}
+
+	skips := "s: Show skips"
+	if m.skips {
+		skips = "s: Hide skips"
+	}
+	content.WriteString("\n" + m.styles.help.Render("enter: Play from here • "+skips+" • esc: Back"))
+	if m.err != nil {
+		content.WriteString("\n" + m.styles.error.Render(m.err.Error()) + "\n")
+	}
+
+	m.viewport.SetContent(content.String())
+	return m.viewport.View()
+}
+
+// Playing reports whether tracks of the history were queued to play
+func (m HistoryModel) Playing() bool {
+	return m.play
+}
+
+func (m HistoryModel) Done() bool {
+	return m.done
+}
+
+// playCount tells how many times a track was played
+func playCount(count int) string {
+	if count == 1 {
+		return "played once"
+	}
+	return fmt.Sprintf("played %d times", count)
+}
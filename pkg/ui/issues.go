@@ -0,0 +1,146 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+	"github.com/llehouerou/pulsar/pkg/ui/common"
+)
+
+// IssuesModel lists the files of a source that the last scans could not
+// read properly
+type IssuesModel struct {
+	viewport viewport.Model
+	ready    bool
+	done     bool
+	err      error
+	manager  *media.SourceManager
+	sourceID string
+	name     string
+	report   media.ScanReport
+	styles   struct {
+		title   lipgloss.Style
+		kind    lipgloss.Style
+		path    lipgloss.Style
+		message lipgloss.Style
+		help    lipgloss.Style
+	}
+}
+
+func NewIssuesModel(manager *media.SourceManager) IssuesModel {
+	m := IssuesModel{
+		manager: manager,
+	}
+
+	m.styles.title = lipgloss.NewStyle().
+		Bold(true).
+		Underline(true).
+		MarginBottom(1)
+	m.styles.kind = lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+	m.styles.path = lipgloss.NewStyle().Foreground(lipgloss.Color("7"))
+	m.styles.message = lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+	m.styles.help = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+
+	return m
+}
+
+// Open shows the scan report of a source
+func (m *IssuesModel) Open(source media.SourceConfig) {
+	m.sourceID = source.ID
+	m.name = source.Name
+	m.done = false
+	m.viewport.YOffset = 0
+	m.load()
+}
+
+func (m *IssuesModel) load() {
+	m.report, m.err = m.manager.GetScanReport(m.sourceID)
+	m.render()
+}
+
+func (m *IssuesModel) Update(msg tea.Msg) (IssuesModel, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		if !m.ready {
+			m.viewport = viewport.New(msg.Width, msg.Height)
+			m.ready = true
+		} else {
+			m.viewport.Width = msg.Width
+			m.viewport.Height = msg.Height
+		}
+		m.render()
+
+	case common.ScanProgressMsg:
+		// A new scan of the source replaced the report
+		if msg.Done && msg.Err == nil && msg.SourceID == m.sourceID {
+			m.load()
+		}
+
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "esc", "backspace":
+			m.done = true
+		case "up":
+			m.viewport.LineUp(1)
+		case "down":
+			m.viewport.LineDown(1)
+		case "pgup":
+			m.viewport.HalfViewUp()
+		case "pgdown":
+			m.viewport.HalfViewDown()
+		}
+	}
+	return *m, nil
+}
+
+// render lays out the report in the viewport, so it can scroll through it
+func (m *IssuesModel) render() {
+	var content strings.Builder
+	content.WriteString(m.styles.title.Render("Scan Issues: "+m.name) + "\n\n")
+
+	switch {
+	case m.err != nil:
+		content.WriteString(fmt.Sprintf("Error: %v\n", m.err))
+	case m.report.ScannedAt.IsZero():
+		content.WriteString("This source was not scanned yet.\n")
+	case len(m.report.Issues) == 0:
+		content.WriteString(fmt.Sprintf(
+			"No issues found by the scan of %s.\n",
+			m.report.ScannedAt.Format("2006-01-02 15:04"),
+		))
+	default:
+		content.WriteString(m.styles.message.Render(fmt.Sprintf(
+			"%d issues, last scan %s",
+			len(m.report.Issues),
+			m.report.ScannedAt.Format("2006-01-02 15:04"),
+		)) + "\n\n")
+		for _, issue := range m.report.Issues {
+			content.WriteString(fmt.Sprintf(
+				"%s %s\n  %s\n",
+				m.styles.kind.Render(fmt.Sprintf("[%s]", issue.Kind)),
+				m.styles.path.Render(issue.Path),
+				m.styles.message.Render(issue.Message),
+			))
+		}
+	}
+
+	content.WriteString("\n" + m.styles.help.Render("↑/↓: Scroll • esc: Back"))
+
+	m.viewport.SetContent(content.String())
+}
+
+func (m IssuesModel) View() string {
+	if !m.ready {
+		return "\n  Initializing..."
+	}
+	return m.viewport.View()
+}
+
+func (m IssuesModel) Done() bool {
+	return m.done
+}
@@ -0,0 +1,224 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// isLibraryMode reports whether the browser shows the library of all sources
+// rather than a single source
+func (m BrowserModel) isLibraryMode() bool {
+	switch m.mode {
+	case ArtistsMode, AlbumsMode, AlbumTracksMode:
+		return true
+	}
+	return false
+}
+
+// toggleLibrary switches between the sources and the library
+func (m *BrowserModel) toggleLibrary() {
+	m.viewport.YOffset = 0
+	if m.isLibraryMode() {
+		m.mode = SourcesMode
+		m.currentSource = ""
+		return
+	}
+
+	m.mode = ArtistsMode
+	m.artistCursor = 0
+	if err := m.loadArtists(); err != nil {
+		m.err = err
+	}
+}
+
+// libraryOpen opens the artist or album under the cursor
+func (m *BrowserModel) libraryOpen() {
+	switch m.mode {
+	case ArtistsMode:
+		if m.artistCursor >= len(m.artists) {
+			return
+		}
+		m.mode = AlbumsMode
+		m.albumCursor = 0
+		m.viewport.YOffset = 0
+		if err := m.loadAlbums(); err != nil {
+			m.err = err
+		}
+	case AlbumsMode:
+		if m.albumCursor >= len(m.albums) {
+			return
+		}
+		m.mode = AlbumTracksMode
+		m.trackCursor = 0
+		m.viewport.YOffset = 0
+		if err := m.loadAlbumTracks(); err != nil {
+			m.err = err
+		}
+	}
+}
+
+// libraryBack goes back from an album to its artist, and from an artist to
+// the list of artists
+func (m *BrowserModel) libraryBack() {
+	m.viewport.YOffset = 0
+	switch m.mode {
+	case AlbumTracksMode:
+		m.mode = AlbumsMode
+	case AlbumsMode:
+		m.mode = ArtistsMode
+	}
+}
+
+func (m *BrowserModel) loadArtists() error {
+	artists, err := m.manager.GetArtists()
+	if err != nil {
+		return err
+	}
+	m.artists = artists
+	m.artistCursor = min(m.artistCursor, max(0, len(m.artists)-1))
+	return nil
+}
+
+func (m *BrowserModel) loadAlbums() error {
+	albums, err := m.manager.GetAlbums(m.artists[m.artistCursor].Name)
+	if err != nil {
+		return err
+	}
+	m.albums = albums
+	m.albumCursor = min(m.albumCursor, max(0, len(m.albums)-1))
+	return nil
+}
+
+func (m *BrowserModel) loadAlbumTracks() error {
+	tracks, err := m.manager.GetAlbumTracks(m.albums[m.albumCursor])
+	if err != nil {
+		return err
+	}
+	m.tracks = tracks
+	m.trackCursor = min(m.trackCursor, max(0, len(m.tracks)-1))
+	return nil
+}
+
+// refreshLibrary reloads the lists leading to the one shown, keeping the
+// cursors where they were. A list that became empty, such as an album whose
+// files were removed, goes back to the previous one.
+func (m *BrowserModel) refreshLibrary() {
+	if err := m.loadArtists(); err != nil {
+		m.err = err
+		return
+	}
+	if m.mode == ArtistsMode {
+		return
+	}
+	if len(m.artists) == 0 {
+		m.mode = ArtistsMode
+		return
+	}
+
+	if err := m.loadAlbums(); err != nil {
+		m.err = err
+		return
+	}
+	if m.mode == AlbumsMode {
+		return
+	}
+	if len(m.albums) == 0 {
+		m.mode = AlbumsMode
+		return
+	}
+
+	if err := m.loadAlbumTracks(); err != nil {
+		m.err = err
+	}
+}
+
+// viewLibrary renders the library list shown and returns its title
+func (m BrowserModel) viewLibrary() (string, string) {
+	var title string
+	var list strings.Builder
+	line := func(selected bool, name, details string) {
+		cursor := " "
+		if selected {
+			cursor = m.styles.cursor.Render(">")
+		}
+		list.WriteString(fmt.Sprintf("%s %s%s\n", cursor, name, m.styles.metadata.Render(details)))
+	}
+
+	switch m.mode {
+	case ArtistsMode:
+		title = "Library"
+		for i, artist := range m.artists {
+			line(
+				i == m.artistCursor,
+				m.styles.source.Render(artist.Name),
+				fmt.Sprintf(
+					" (%d albums, %d tracks, %s)",
+					artist.Albums,
+					artist.Tracks,
+					formatTotalDuration(artist.Duration),
+				),
+			)
+		}
+		if len(m.artists) == 0 {
+			list.WriteString("The library is empty. Press 'tab' to manage the sources.")
+		}
+
+	case AlbumsMode:
+		title = m.artists[m.artistCursor].Name
+		for i, album := range m.albums {
+			name := albumTitle(album.Title)
+			if album.Year > 0 {
+				name = fmt.Sprintf("%d %s", album.Year, name)
+			}
+			line(
+				i == m.albumCursor,
+				m.styles.source.Render(name),
+				fmt.Sprintf(
+					" (%d tracks, %s)",
+					album.Tracks,
+					formatTotalDuration(album.Duration),
+				),
+			)
+		}
+
+	case AlbumTracksMode:
+		album := m.albums[m.albumCursor]
+		title = album.Artist + " - " + albumTitle(album.Title)
+		for i, track := range m.tracks {
+			name := track.Title
+			if track.TrackNumber > 0 {
+				name = fmt.Sprintf("%02d. %s", track.TrackNumber, name)
+			}
+			if track.DiscNumber > 1 {
+				name = fmt.Sprintf("%d-%s", track.DiscNumber, name)
+			}
+			details := " " + formatDuration(track.Duration)
+			// Compilations and guest appearances name the track artist
+			if track.Artist != "" && track.Artist != album.Artist {
+				details = fmt.Sprintf(" - %s%s", track.Artist, details)
+			}
+			line(i == m.trackCursor, m.styles.track.Render(name), details)
+		}
+	}
+
+	list.WriteString(m.styles.status.Render("\ntab: Sources • /: Search • enter: Open • +: Add to playlist • esc: Back"))
+	return title, list.String()
+}
+
+// albumTitle returns the title shown for an album
+func albumTitle(title string) string {
+	if title == "" {
+		return "Unknown Album"
+	}
+	return title
+}
+
+// formatTotalDuration formats the playing time of a list of tracks
+func formatTotalDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	if d < time.Hour {
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+	return fmt.Sprintf("%dh%02dm", d/time.Hour, (d%time.Hour)/time.Minute)
+}
@@ -0,0 +1,128 @@
+package ui
+
+import (
+	"time"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+)
+
+// maxListenStep is the longest position change between two ticks counted as
+// listening, longer jumps are seeks
+const maxListenStep = 2 * time.Second
+
+// playStore records the listening history
+type playStore interface {
+	RecordPlay(play media.Play) (int64, error)
+	UpdatePlayListened(playID int64, listened time.Duration) error
+}
+
+// scrobbleQueue submits the plays to the scrobbling services
+type scrobbleQueue interface {
+	Queue(play media.Play) error
+}
+
+// listenTracker follows the track the player is on and records its listens.
+// A listen is saved as a play once it reaches the threshold, so it counts
+// even if pulsar stops, and as a skip when the player moves on to another
+// track before. A listen stopped before the threshold is dropped.
+type listenTracker struct {
+	store     playStore
+	scrobbles scrobbleQueue
+	// current is the listen in progress, nil when nothing plays
+	current *media.Play
+	// position is the last position seen in the current track
+	position time.Duration
+}
+
+func newListenTracker(store playStore, scrobbles scrobbleQueue) *listenTracker {
+	return &listenTracker{store: store, scrobbles: scrobbles}
+}
+
+// start begins a listen of the track the player just started, ending the
+// listen in progress even when it is of the same track
+func (l *listenTracker) start(track media.Track, position time.Duration, now time.Time) error {
+	if l.current != nil && l.current.Track.ID == track.ID {
+		if err := l.finish(false); err != nil {
+			return err
+		}
+	}
+	return l.observe(track, position, now)
+}
+
+// observe accounts for the track playing at a position, ending the listen of
+// the previous track when the player moved on or started the same track
+// again. An empty track means playback stopped.
+func (l *listenTracker) observe(track media.Track, position time.Duration, now time.Time) error {
+	if l.current != nil {
+		var err error
+		switch {
+		case l.current.Track.ID != track.ID:
+			err = l.finish(track.ID != "")
+		case l.restarted(position):
+			// Playing a track again is not skipping it
+			err = l.finish(false)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if track.ID == "" {
+		return nil
+	}
+	if l.current == nil {
+		l.current = &media.Play{Track: track, StartedAt: now}
+		l.position = position
+		return nil
+	}
+
+	// Pauses leave the position unchanged and seeks jump over it
+	if step := position - l.position; step > 0 && step <= maxListenStep {
+		l.current.Listened += step
+	}
+	l.position = position
+	if l.current.ID == 0 && l.current.Listened >= media.PlayThreshold(track.Duration) {
+		id, err := l.store.RecordPlay(*l.current)
+		if err != nil {
+			return err
+		}
+		l.current.ID = id
+		return l.scrobbles.Queue(*l.current)
+	}
+	return nil
+}
+
+// restarted tells whether the current track jumped back to its start, being
+// repeated or played again
+func (l *listenTracker) restarted(position time.Duration) bool {
+	return position <= maxListenStep && l.position-position > maxListenStep
+}
+
+// ended accounts for a track that played to its end
+func (l *listenTracker) ended(track media.Track, now time.Time) error {
+	if l.current == nil || l.current.Track.ID != track.ID {
+		return nil
+	}
+	if err := l.observe(track, track.Duration, now); err != nil {
+		return err
+	}
+	return l.finish(false)
+}
+
+// finish ends the current listen, saving how long a play was listened to.
+// A listen short of a play is saved as a skip when the player moved on to
+// another track, and dropped when playback stopped.
+func (l *listenTracker) finish(movedOn bool) error {
+	listen := l.current
+	l.current = nil
+	switch {
+	case listen == nil:
+		return nil
+	case listen.ID != 0:
+		return l.store.UpdatePlayListened(listen.ID, listen.Listened)
+	case movedOn && listen.Listened > 0:
+		listen.Skipped = true
+		_, err := l.store.RecordPlay(*listen)
+		return err
+	}
+	return nil
+}
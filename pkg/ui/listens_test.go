@@ -0,0 +1,221 @@
+package ui
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+)
+
+// memPlays keeps the recorded listens in memory
+type memPlays struct {
+	plays []media.Play
+}
+
+func (s *memPlays) RecordPlay(play media.Play) (int64, error) {
+	play.ID = int64(len(s.plays) + 1)
+	s.plays = append(s.plays, play)
+	return play.ID, nil
+}
+
+func (s *memPlays) UpdatePlayListened(playID int64, listened time.Duration) error {
+	if playID < 1 || playID > int64(len(s.plays)) {
+		return fmt.Errorf("play not found: %d", playID)
+	}
+	s.plays[playID-1].Listened = listened
+	return nil
+}
+
+// memScrobbles keeps the IDs of the queued plays
+type memScrobbles struct {
+	ids []int64
+}
+
+func (s *memScrobbles) Queue(play media.Play) error {
+	s.ids = append(s.ids, play.ID)
+	return nil
+}
+
+// listenEvent is what the player reports to the tracker: the track playing
+// at a position, the start or the end of a track or playback stopping
+type listenEvent struct {
+	track    media.Track
+	position time.Duration
+	started  bool
+	ended    bool
+	stopped  bool
+}
+
+// playing returns the ticks of a track playing from one position to another,
+// a second apart
+func playing(track media.Track, from, to time.Duration) []listenEvent {
+	var events []listenEvent
+	for position := from; position <= to; position += time.Second {
+		events = append(events, listenEvent{track: track, position: position})
+	}
+	return events
+}
+
+func TestListenTracker(t *testing.T) {
+	// Plays of a and b take 2m, the half of their length
+	a := media.Track{ID: "a", Duration: 4 * time.Minute}
+	b := media.Track{ID: "b", Duration: 4 * time.Minute}
+	ended := func(track media.Track) []listenEvent {
+		return []listenEvent{{track: track, ended: true}}
+	}
+	started := func(track media.Track) []listenEvent {
+		return []listenEvent{{track: track, started: true}}
+	}
+	stopped := []listenEvent{{stopped: true}}
+	// listened is a saved listen, its ID being its position in the list
+	type listened struct {
+		track    string
+		duration time.Duration
+		skipped  bool
+	}
+	tests := []struct {
+		name   string
+		events [][]listenEvent
+		want   []listened
+		// scrobbled counts the plays queued for scrobbling
+		scrobbled int
+	}{
+		{
+			"played to the end",
+			[][]listenEvent{playing(a, 0, 4*time.Minute), ended(a)},
+			[]listened{{"a", 4 * time.Minute, false}},
+			1,
+		},
+		{
+			"moved on after the threshold",
+			[][]listenEvent{playing(a, 0, 150*time.Second), playing(b, 0, 0)},
+			[]listened{{"a", 150 * time.Second, false}},
+			1,
+		},
+		{
+			"skipped to another track",
+			[][]listenEvent{playing(a, 0, 30*time.Second), playing(b, 0, 10*time.Second)},
+			[]listened{{"a", 30 * time.Second, true}},
+			0,
+		},
+		{
+			"stopped before the threshold",
+			[][]listenEvent{playing(a, 0, 30*time.Second), stopped},
+			nil,
+			0,
+		},
+		{
+			"player emptied before the threshold",
+			[][]listenEvent{playing(a, 0, 30*time.Second), {{}}},
+			nil,
+			0,
+		},
+		{
+			"quit after the threshold",
+			[][]listenEvent{playing(a, 0, 150*time.Second), stopped},
+			[]listened{{"a", 150 * time.Second, false}},
+			1,
+		},
+		{
+			"seeks and pauses are not listened",
+			[][]listenEvent{
+				playing(a, 0, 10*time.Second),
+				playing(a, 200*time.Second, 205*time.Second),
+				playing(a, 205*time.Second, 205*time.Second),
+				playing(a, 205*time.Second, 205*time.Second),
+				playing(b, 0, 0),
+			},
+			[]listened{{"a", 15 * time.Second, true}},
+			0,
+		},
+		{
+			"restarted track",
+			[][]listenEvent{
+				playing(a, 0, 90*time.Second),
+				playing(a, 0, 130*time.Second),
+				stopped,
+			},
+			[]listened{{"a", 130 * time.Second, false}},
+			1,
+		},
+		{
+			"played again after the threshold",
+			[][]listenEvent{
+				playing(a, 0, 150*time.Second),
+				playing(a, time.Second, 130*time.Second),
+				stopped,
+			},
+			[]listened{{"a", 150 * time.Second, false}, {"a", 129 * time.Second, false}},
+			2,
+		},
+		{
+			"repeated after the end",
+			[][]listenEvent{playing(a, 0, 4*time.Minute), ended(a), playing(a, 0, 2*time.Minute), stopped},
+			[]listened{{"a", 4 * time.Minute, false}, {"a", 2 * time.Minute, false}},
+			2,
+		},
+		{
+			"started again by the player",
+			[][]listenEvent{
+				playing(a, 0, 150*time.Second),
+				started(a),
+				playing(a, time.Second, 130*time.Second),
+				stopped,
+			},
+			[]listened{{"a", 150 * time.Second, false}, {"a", 130 * time.Second, false}},
+			2,
+		},
+		{
+			"ended after seeking to the end",
+			[][]listenEvent{playing(a, 0, 10*time.Second), playing(a, 235*time.Second, 240*time.Second), ended(a)},
+			nil,
+			0,
+		},
+		{
+			"stale end of a replaced track",
+			[][]listenEvent{playing(a, 0, 30*time.Second), playing(b, 0, 5*time.Second), ended(a), stopped},
+			[]listened{{"a", 30 * time.Second, true}},
+			0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &memPlays{}
+			scrobbles := &memScrobbles{}
+			tracker := newListenTracker(store, scrobbles)
+			now := time.Date(2024, 5, 1, 20, 0, 0, 0, time.UTC)
+			for _, events := range tt.events {
+				for _, event := range events {
+					now = now.Add(time.Second)
+					var err error
+					switch {
+					case event.started:
+						err = tracker.start(event.track, event.position, now)
+					case event.ended:
+						err = tracker.ended(event.track, now)
+					case event.stopped:
+						err = tracker.finish(false)
+					default:
+						err = tracker.observe(event.track, event.position, now)
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			var got []listened
+			for _, play := range store.plays {
+				got = append(got, listened{play.Track.ID, play.Listened, play.Skipped})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("listens = %v, want %v", got, tt.want)
+			}
+			if len(scrobbles.ids) != tt.scrobbled {
+				t.Errorf("scrobbled plays %v, want %d", scrobbles.ids, tt.scrobbled)
+			}
+		})
+	}
+}
@@ -1,7 +1,10 @@
 package ui
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"strconv"
 	"strings"
 	"time"
 
@@ -9,35 +12,70 @@ import (
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/gopxl/beep/v2"
 
+	"github.com/llehouerou/pulsar/pkg/media"
 	"github.com/llehouerou/pulsar/pkg/player"
+	"github.com/llehouerou/pulsar/pkg/queue"
 )
 
+const (
+	queueVisibleTracks = 10 // Number of queue entries shown below the player
+	seekStep           = 5 * time.Second
+	seekLongStep       = 30 * time.Second
+	crossfadeStep      = time.Second
+	crossfadeKey       = "crossfade" // Settings key, in seconds
+	volumeStep         = 0.05
+	volumeKey          = "volume" // Settings key, in percent
+	replayGainKey      = "replaygain"
+	// SampleRateKey is the settings key of the audio output rate, in Hz
+	SampleRateKey = "output.sample_rate"
+	// BufferSizeKey is the settings key of the audio output buffer, in ms
+	BufferSizeKey = "output.buffer_ms"
+)
+
+// settingsStore persists user preferences
+type settingsStore interface {
+	GetSetting(key string) (string, error)
+	SaveSetting(key, value string) error
+}
+
 type PlayerModel struct {
 	player       *player.Player
+	queue        *queue.Queue
+	settings     settingsStore
+	listens      *listenTracker
 	playing      bool
 	err          error
 	viewport     viewport.Model
 	ready        bool
 	progress     progress.Model
 	showTimeLeft bool
+	queueCursor  int
 	styles       struct {
 		status   lipgloss.Style
 		help     lipgloss.Style
 		metadata lipgloss.Style
 		time     lipgloss.Style
+		queue    lipgloss.Style
+		current  lipgloss.Style
+		cursor   lipgloss.Style
 	}
 }
 
 type tickMsg time.Time
 
-func NewPlayerModel() PlayerModel {
+func NewPlayerModel(q *queue.Queue, settings settingsStore, plays playStore, scrobbles scrobbleQueue) PlayerModel {
 	m := PlayerModel{
-		player:       player.New(),
+		player:       player.New(outputConfig(settings)),
+		queue:        q,
+		settings:     settings,
+		listens:      newListenTracker(plays, scrobbles),
 		playing:      false,
 		showTimeLeft: false,
 		progress: progress.New(
 			progress.WithScaledGradient("#FF7CCB", "#FDFF8C"),
+			progress.WithoutPercentage(),
 		),
 	}
 	m.styles.status = lipgloss.NewStyle().Bold(true).MarginBottom(1)
@@ -46,9 +84,43 @@ func NewPlayerModel() PlayerModel {
 		Bold(true).
 		Foreground(lipgloss.Color("86"))
 	m.styles.time = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+	m.styles.queue = lipgloss.NewStyle().Foreground(lipgloss.Color("7"))
+	m.styles.current = lipgloss.NewStyle().Foreground(lipgloss.Color("86"))
+	m.styles.cursor = lipgloss.NewStyle().Foreground(lipgloss.Color("11"))
+
+	if value, err := settings.GetSetting(crossfadeKey); err == nil && value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil {
+			m.player.SetCrossfade(time.Duration(seconds) * time.Second)
+		}
+	}
+	if value, err := settings.GetSetting(volumeKey); err == nil && value != "" {
+		if percent, err := strconv.Atoi(value); err == nil {
+			m.player.SetVolume(float64(percent) / 100)
+		}
+	}
+	if value, err := settings.GetSetting(replayGainKey); err == nil {
+		m.player.SetReplayGainMode(player.ParseReplayGainMode(value))
+	}
 	return m
 }
 
+// outputConfig reads the audio output configuration from the settings,
+// falling back to the player defaults
+func outputConfig(settings settingsStore) player.Config {
+	config := player.DefaultConfig()
+	if value, err := settings.GetSetting(SampleRateKey); err == nil && value != "" {
+		if rate, err := strconv.Atoi(value); err == nil {
+			config.SampleRate = beep.SampleRate(rate)
+		}
+	}
+	if value, err := settings.GetSetting(BufferSizeKey); err == nil && value != "" {
+		if ms, err := strconv.Atoi(value); err == nil {
+			config.BufferSize = time.Duration(ms) * time.Millisecond
+		}
+	}
+	return config
+}
+
 func formatDuration(d time.Duration) string {
 	d = d.Round(time.Second)
 	m := d / time.Minute
@@ -57,6 +129,11 @@ func formatDuration(d time.Duration) string {
 	return fmt.Sprintf("%02d:%02d", m, s)
 }
 
+// Init starts listening for tracks reaching their end
+func (m *PlayerModel) Init() tea.Cmd {
+	return m.waitForTrackEnd()
+}
+
 func (m *PlayerModel) Update(msg tea.Msg) (PlayerModel, tea.Cmd) {
 	var cmd tea.Cmd
 	switch msg := msg.(type) {
@@ -74,15 +151,49 @@ func (m *PlayerModel) Update(msg tea.Msg) (PlayerModel, tea.Cmd) {
 	case playerErrorMsg:
 		m.err = msg.error
 	case playerStartedMsg:
-		m.playing = true
-		return *m, tickCmd()
+		m.err = nil
+		// The track playing before was replaced, or started again
+		m.startListen()
+		if !m.playing {
+			m.playing = true
+			return *m, tea.Batch(tickCmd(), m.preloadNext())
+		}
+		return *m, m.preloadNext()
+	case trackEndedMsg:
+		if err := m.listens.ended(msg.Track, time.Now()); err != nil {
+			m.err = err
+		}
+		// Ignore stale events from a track that was replaced meanwhile
+		if current, ok := m.queue.Current(); !ok || current.ID != msg.Track.ID {
+			return *m, m.waitForTrackEnd()
+		}
+		if msg.Continued {
+			return *m, tea.Batch(m.waitForTrackEnd(), m.followPlayer())
+		}
+		return *m, tea.Batch(m.waitForTrackEnd(), m.next())
 	case tickMsg:
 		if m.playing {
+			m.trackListen()
 			return *m, tickCmd()
 		}
+	case tea.MouseMsg:
+		if msg.Action == tea.MouseActionPress && msg.Button == tea.MouseButtonLeft {
+			m.seekToColumn(msg.X, msg.Y)
+		}
 	case tea.KeyMsg:
 		switch msg.String() {
+		case "left":
+			m.seekBy(-seekStep)
+		case "right":
+			m.seekBy(seekStep)
+		case "shift+left":
+			m.seekBy(-seekLongStep)
+		case "shift+right":
+			m.seekBy(seekLongStep)
 		case " ": // Space key
+			if !m.player.Loaded() {
+				return *m, m.PlayCurrent()
+			}
 			m.player.Toggle()
 			m.playing = !m.playing
 			if m.playing {
@@ -91,6 +202,57 @@ func (m *PlayerModel) Update(msg tea.Msg) (PlayerModel, tea.Cmd) {
 			return *m, nil
 		case "t": // Toggle time display
 			m.showTimeLeft = !m.showTimeLeft
+		case "+", "=":
+			m.setVolume(m.player.Volume() + volumeStep)
+		case "-":
+			m.setVolume(m.player.Volume() - volumeStep)
+		case "m":
+			m.player.ToggleMute()
+		case "g":
+			m.cycleReplayGainMode()
+		case "[":
+			m.setCrossfade(m.player.Crossfade() - crossfadeStep)
+		case "]":
+			m.setCrossfade(m.player.Crossfade() + crossfadeStep)
+		case "n":
+			return *m, m.next()
+		case "p":
+			return *m, m.previous()
+		case "up":
+			if m.queueCursor > 0 {
+				m.queueCursor--
+			}
+		case "down":
+			if m.queueCursor < m.queue.Len()-1 {
+				m.queueCursor++
+			}
+		case "enter":
+			if _, err := m.queue.JumpTo(m.queueCursor); err != nil {
+				m.err = err
+				return *m, nil
+			}
+			return *m, m.PlayCurrent()
+		case "d", "delete":
+			m.removeFromQueue()
+			return *m, m.preloadNext()
+		case "K", "shift+up":
+			if m.queueCursor > 0 {
+				if err := m.queue.Move(m.queueCursor, m.queueCursor-1); err != nil {
+					m.err = err
+				} else {
+					m.queueCursor--
+				}
+			}
+			return *m, m.preloadNext()
+		case "J", "shift+down":
+			if m.queueCursor < m.queue.Len()-1 {
+				if err := m.queue.Move(m.queueCursor, m.queueCursor+1); err != nil {
+					m.err = err
+				} else {
+					m.queueCursor++
+				}
+			}
+			return *m, m.preloadNext()
 		case "esc":
 			return *m, nil
 		case "ctrl+c", "q":
@@ -100,6 +262,152 @@ func (m *PlayerModel) Update(msg tea.Msg) (PlayerModel, tea.Cmd) {
 	return *m, cmd
 }
 
+// setVolume changes the volume level and saves it
+func (m *PlayerModel) setVolume(level float64) {
+	m.player.SetVolume(level)
+	percent := int(math.Round(m.player.Volume() * 100))
+	if err := m.settings.SaveSetting(volumeKey, strconv.Itoa(percent)); err != nil {
+		m.err = err
+	}
+}
+
+// cycleReplayGainMode switches between off, track and album normalization
+func (m *PlayerModel) cycleReplayGainMode() {
+	mode := (m.player.ReplayGainMode() + 1) % (player.ReplayGainAlbum + 1)
+	m.player.SetReplayGainMode(mode)
+	if err := m.settings.SaveSetting(replayGainKey, mode.String()); err != nil {
+		m.err = err
+	}
+}
+
+// setCrossfade changes the crossfade duration and saves it
+func (m *PlayerModel) setCrossfade(d time.Duration) {
+	m.player.SetCrossfade(d)
+	seconds := int(m.player.Crossfade() / time.Second)
+	if err := m.settings.SaveSetting(crossfadeKey, strconv.Itoa(seconds)); err != nil {
+		m.err = err
+	}
+}
+
+func (m *PlayerModel) seekBy(delta time.Duration) {
+	if err := m.player.SeekBy(delta); err != nil {
+		m.err = err
+	}
+}
+
+// seekToColumn seeks to the position matching a click on the progress bar
+func (m *PlayerModel) seekToColumn(x, y int) {
+	if !m.ready || m.err != nil || y != m.progressBarRow() {
+		return
+	}
+	left := (m.viewport.Width - m.progress.Width) / 2
+	if x < left || x >= left+m.progress.Width || m.progress.Width <= 1 {
+		return
+	}
+	fraction := float64(x-left) / float64(m.progress.Width-1)
+	if err := m.player.SeekFraction(fraction); err != nil {
+		m.err = err
+	}
+}
+
+// removeFromQueue removes the entry under the cursor, stopping playback if it
+// was the current track
+func (m *PlayerModel) removeFromQueue() {
+	if m.queue.Len() == 0 {
+		return
+	}
+	wasCurrent := m.queueCursor == m.queue.CurrentIndex()
+	if err := m.queue.Remove(m.queueCursor); err != nil {
+		m.err = err
+		return
+	}
+	if wasCurrent {
+		m.Stop()
+	}
+	if m.queueCursor >= m.queue.Len() {
+		m.queueCursor = max(0, m.queue.Len()-1)
+	}
+}
+
+// removeSource drops the tracks of a removed source from the queue, stopping
+// playback if the track playing was one of them
+func (m *PlayerModel) removeSource(sourceID string) (tea.Cmd, error) {
+	playingRemoved := m.playing && m.player.Track().SourceID == sourceID
+	if err := m.queue.RemoveSource(sourceID); err != nil {
+		return nil, err
+	}
+	m.queueCursor = max(0, min(m.queueCursor, m.queue.Len()-1))
+	if playingRemoved {
+		m.Stop()
+		return nil, nil
+	}
+	if m.playing {
+		// The preloaded track may be gone too
+		return m.preloadNext(), nil
+	}
+	return nil, nil
+}
+
+func (m *PlayerModel) next() tea.Cmd {
+	if _, err := m.queue.Next(); err != nil {
+		if errors.Is(err, queue.ErrEndOfQueue) {
+			m.Stop()
+			return nil
+		}
+		m.err = err
+		return nil
+	}
+	return m.PlayCurrent()
+}
+
+// followPlayer moves the queue along after the player switched gaplessly to
+// the preloaded track
+func (m *PlayerModel) followPlayer() tea.Cmd {
+	next, err := m.queue.Next()
+	if err != nil {
+		m.Stop()
+		if !errors.Is(err, queue.ErrEndOfQueue) {
+			m.err = err
+		}
+		return nil
+	}
+	m.queueCursor = m.queue.CurrentIndex()
+
+	// The queue was edited since the preload, play the right track instead
+	if next.ID != m.player.Track().ID {
+		return m.PlayCurrent()
+	}
+	return m.preloadNext()
+}
+
+// preloadNext hands the upcoming queue entry to the player for gapless playback
+func (m *PlayerModel) preloadNext() tea.Cmd {
+	p := m.player
+	upcoming := m.queue.Upcoming()
+	if len(upcoming) == 0 {
+		return func() tea.Msg {
+			p.ClearNext()
+			return nil
+		}
+	}
+	next := upcoming[0]
+	return func() tea.Msg {
+		// A track that fails to preload is reported when the queue reaches it
+		_ = p.SetNext(next)
+		return nil
+	}
+}
+
+func (m *PlayerModel) previous() tea.Cmd {
+	if _, err := m.queue.Previous(); err != nil {
+		if !errors.Is(err, queue.ErrEndOfQueue) {
+			m.err = err
+		}
+		return nil
+	}
+	return m.PlayCurrent()
+}
+
 func (m *PlayerModel) View() string {
 	if !m.ready {
 		return "\n  Initializing..."
@@ -109,29 +417,11 @@ func (m *PlayerModel) View() string {
 	if m.err != nil {
 		content = fmt.Sprintf("\nError: %v\n", m.err)
 	} else {
-		metadata := m.player.GetMetadata()
-		status := " Paused"
-		if m.playing {
-			status = " Playing"
-		}
-
 		// Center each section
 		centerStyle := lipgloss.NewStyle().Width(m.viewport.Width).Align(lipgloss.Center)
 
-		// Status
-		content = centerStyle.Render(m.styles.status.Render(status)) + "\n"
-
-		// Metadata
-		if metadata.Artist != "" || metadata.Title != "" {
-			content += centerStyle.Render(
-				m.styles.metadata.Render(
-					fmt.Sprintf("%s - %s",
-						metadata.Artist,
-						metadata.Title,
-					),
-				),
-			) + "\n\n"
-		}
+		// Status and metadata
+		content = m.headerView()
 
 		// Progress bar
 		content += centerStyle.Render(m.progress.ViewAs(m.player.Position())) + "\n"
@@ -145,11 +435,30 @@ func (m *PlayerModel) View() string {
 		} else {
 			timeDisplay += " / " + formatDuration(duration)
 		}
-		content += centerStyle.Render(m.styles.time.Render(timeDisplay)) + "\n\n"
+		content += centerStyle.Render(m.styles.time.Render(timeDisplay)) + "\n"
+
+		// Volume and crossfade
+		crossfade := "off"
+		if d := m.player.Crossfade(); d > 0 {
+			crossfade = d.String()
+		}
+		content += centerStyle.Render(m.styles.time.Render(
+			m.volumeView()+" • Crossfade: "+crossfade+
+				" • ReplayGain: "+m.player.ReplayGainMode().String(),
+		)) + "\n\n"
+
+		// Queue
+		content += centerStyle.Render(m.queueView()) + "\n\n"
 
 		// Help text
 		helpText := m.styles.help.Render(strings.Join([]string{
 			"Space: Play/Pause",
+			"←/→: Seek 5s • Shift+←/→: Seek 30s • Click bar: Seek",
+			"n/p: Next/Previous track",
+			"↑/↓: Select in queue • Enter: Play selected",
+			"d: Remove from queue • K/J: Move up/down",
+			"+/-: Volume • m: Mute",
+			"[/]: Crossfade shorter/longer • g: ReplayGain mode",
 			"t: Toggle time display",
 			"Esc: Back to browser",
 			"q: Quit",
@@ -161,9 +470,102 @@ func (m *PlayerModel) View() string {
 	return m.viewport.View()
 }
 
-func (m *PlayerModel) StartPlayback(filepath string) tea.Cmd {
+// volumeView renders the volume level as a small gauge
+func (m *PlayerModel) volumeView() string {
+	const width = 10
+	level := m.player.Volume()
+	filled := int(math.Round(level * width))
+	gauge := strings.Repeat("█", filled) + strings.Repeat("░", width-filled)
+	if m.player.Muted() {
+		return fmt.Sprintf("Volume: %s muted", gauge)
+	}
+	return fmt.Sprintf("Volume: %s %3d%%", gauge, int(math.Round(level*100)))
+}
+
+// headerView renders the status and metadata shown above the progress bar
+func (m *PlayerModel) headerView() string {
+	metadata := m.player.GetMetadata()
+	status := " Paused"
+	if m.playing {
+		status = " Playing"
+	}
+
+	// Center each section
+	centerStyle := lipgloss.NewStyle().Width(m.viewport.Width).Align(lipgloss.Center)
+
+	// Status
+	header := centerStyle.Render(m.styles.status.Render(status)) + "\n"
+
+	// Metadata
+	if metadata.Artist != "" || metadata.Title != "" {
+		header += centerStyle.Render(
+			m.styles.metadata.Render(
+				fmt.Sprintf("%s - %s",
+					metadata.Artist,
+					metadata.Title,
+				),
+			),
+		) + "\n\n"
+	}
+	return header
+}
+
+// progressBarRow returns the screen row of the progress bar
+func (m *PlayerModel) progressBarRow() int {
+	return strings.Count(m.headerView(), "\n") - m.viewport.YOffset
+}
+
+// queueView renders a window of the queue around the cursor
+func (m *PlayerModel) queueView() string {
+	tracks := m.queue.Tracks()
+	if len(tracks) == 0 {
+		return m.styles.help.Render("Queue is empty")
+	}
+
+	current := m.queue.CurrentIndex()
+	start := max(0, min(m.queueCursor-queueVisibleTracks/2, len(tracks)-queueVisibleTracks))
+	end := min(len(tracks), start+queueVisibleTracks)
+
+	var list strings.Builder
+	list.WriteString(m.styles.help.Render(
+		fmt.Sprintf("Queue (%d/%d)", current+1, len(tracks)),
+	) + "\n")
+	for i := start; i < end; i++ {
+		cursor := " "
+		if i == m.queueCursor {
+			cursor = m.styles.cursor.Render(">")
+		}
+		line := queueEntryTitle(tracks[i])
+		if i == current {
+			line = m.styles.current.Render("♪ " + line)
+		} else {
+			line = m.styles.queue.Render("  " + line)
+		}
+		list.WriteString(fmt.Sprintf("%s %s\n", cursor, line))
+	}
+	return strings.TrimSuffix(list.String(), "\n")
+}
+
+func queueEntryTitle(track media.Track) string {
+	title := track.Title
+	if title == "" {
+		title = "Unknown Title"
+	}
+	if track.Artist == "" {
+		return title
+	}
+	return fmt.Sprintf("%s - %s", track.Artist, title)
+}
+
+// PlayCurrent starts playback of the queue's current track
+func (m *PlayerModel) PlayCurrent() tea.Cmd {
+	track, ok := m.queue.Current()
+	if !ok {
+		return nil
+	}
+	m.queueCursor = m.queue.CurrentIndex()
 	return func() tea.Msg {
-		err := m.player.Play(filepath)
+		err := m.player.Play(track)
 		if err != nil {
 			return playerErrorMsg{err}
 		}
@@ -172,6 +574,7 @@ func (m *PlayerModel) StartPlayback(filepath string) tea.Cmd {
 }
 
 func (m *PlayerModel) Stop() {
+	m.endListen()
 	if m.player != nil {
 		m.player.Stop()
 		m.player.Close()
@@ -179,6 +582,36 @@ func (m *PlayerModel) Stop() {
 	m.playing = false
 }
 
+// trackListen accounts for the listening of the track playing
+func (m *PlayerModel) trackListen() {
+	err := m.listens.observe(m.player.Track(), m.player.CurrentPosition(), time.Now())
+	if err != nil {
+		m.err = err
+	}
+}
+
+// startListen begins the listen of the track the player started
+func (m *PlayerModel) startListen() {
+	err := m.listens.start(m.player.Track(), m.player.CurrentPosition(), time.Now())
+	if err != nil {
+		m.err = err
+	}
+}
+
+// endListen saves the listen of the track playing until playback stops
+func (m *PlayerModel) endListen() {
+	if err := m.listens.finish(false); err != nil {
+		m.err = err
+	}
+}
+
+func (m *PlayerModel) waitForTrackEnd() tea.Cmd {
+	ended := m.player.Ended()
+	return func() tea.Msg {
+		return trackEndedMsg{<-ended}
+	}
+}
+
 func tickCmd() tea.Cmd {
 	return tea.Tick(time.Second/2, func(t time.Time) tea.Msg {
 		return tickMsg(t)
@@ -190,3 +623,7 @@ type playerErrorMsg struct {
 }
 
 type playerStartedMsg struct{}
+
+type trackEndedMsg struct {
+	player.TrackEnd
+}
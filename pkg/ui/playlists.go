@@ -0,0 +1,749 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/llehouerou/pulsar/pkg/media"
+	"github.com/llehouerou/pulsar/pkg/playlist"
+	"github.com/llehouerou/pulsar/pkg/queue"
+)
+
+// importedShown bounds the number of unresolved entries listed after an
+// import
+const importedShown = 10
+
+// promptKind tells what the text typed in the playlists screen is for
+type promptKind int
+
+const (
+	noPrompt promptKind = iota
+	createPrompt
+	renamePrompt
+	importPrompt
+	exportPrompt
+	// smartPrompt asks the name of a new smart playlist, then rulesPrompt
+	// its rules, or the new rules of the selected one
+	smartPrompt
+	rulesPrompt
+)
+
+// rulesPlaceholder shows the syntax of smart playlist rules
+const rulesPlaceholder = "genre = jazz and year < 1970, added within 30d sort added desc limit 50"
+
+// errSmartTarget is shown when picking a smart playlist to add tracks to
+var errSmartTarget = errors.New("smart playlists follow their rules, pick another playlist")
+
+// playlistStore persists the playlists
+type playlistStore interface {
+	playlist.Store
+	GetPlaylists() ([]media.Playlist, error)
+	RenamePlaylist(playlistID, name string) error
+	DeletePlaylist(playlistID string) error
+	SetPlaylistTracks(playlistID string, trackIDs []string) error
+	CreateSmartPlaylist(name string, rules media.SmartRules) (media.Playlist, error)
+	SetPlaylistRules(playlistID string, rules media.SmartRules) error
+}
+
+// PlaylistsModel lists the playlists and edits the tracks of the open one
+type PlaylistsModel struct {
+	store     playlistStore
+	queue     *queue.Queue
+	playlists []media.Playlist
+	cursor    int
+	// open is the playlist whose tracks are shown, nil on the list
+	open        *media.Playlist
+	tracks      []media.Track
+	trackCursor int
+	// target is the playlist receiving the tracks added from the browser,
+	// the last one opened or picked
+	target *media.Playlist
+	// pending holds the tracks waiting for a playlist to be picked
+	pending []media.Track
+	// input takes the name or the file path asked by prompt, selected is
+	// the playlist renamed or exported
+	input    textinput.Model
+	prompt   promptKind
+	selected *media.Playlist
+	// smartName is the name of the smart playlist whose rules are asked
+	smartName string
+	// imported reports the last import until the next key press
+	imported *playlist.ImportResult
+	// confirm asks before deleting the playlist in deleting, nil otherwise
+	confirm  *ConfirmModel
+	deleting media.Playlist
+	// status reports the tracks added to a picked playlist, shown by the
+	// browser, or the last export
+	status   string
+	play     bool
+	done     bool
+	err      error
+	viewport viewport.Model
+	ready    bool
+	styles   struct {
+		title    lipgloss.Style
+		playlist lipgloss.Style
+		smart    lipgloss.Style
+		track    lipgloss.Style
+		cursor   lipgloss.Style
+		metadata lipgloss.Style
+		label    lipgloss.Style
+		error    lipgloss.Style
+		help     lipgloss.Style
+	}
+}
+
+func NewPlaylistsModel(store playlistStore, q *queue.Queue) PlaylistsModel {
+	m := PlaylistsModel{
+		store: store,
+		queue: q,
+	}
+
+	m.styles.title = lipgloss.NewStyle().
+		Bold(true).
+		Underline(true).
+		MarginBottom(1)
+	m.styles.playlist = lipgloss.NewStyle().Foreground(lipgloss.Color("12"))
+	m.styles.smart = lipgloss.NewStyle().Foreground(lipgloss.Color("13"))
+	m.styles.track = lipgloss.NewStyle().Foreground(lipgloss.Color("7"))
+	m.styles.cursor = lipgloss.NewStyle().Foreground(lipgloss.Color("11"))
+	m.styles.metadata = lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+	m.styles.label = lipgloss.NewStyle().Foreground(lipgloss.Color("12"))
+	m.styles.error = lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+	m.styles.help = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+
+	return m
+}
+
+// Open shows the list of playlists
+func (m *PlaylistsModel) Open() {
+	m.open = nil
+	m.pending = nil
+	m.reset()
+	m.load()
+}
+
+// Pick shows the list of playlists to choose the one receiving tracks, it
+// becomes the target of the next additions
+func (m *PlaylistsModel) Pick(tracks []media.Track) {
+	m.Open()
+	m.pending = tracks
+}
+
+func (m *PlaylistsModel) reset() {
+	m.prompt = noPrompt
+	m.selected = nil
+	m.imported = nil
+	m.confirm = nil
+	m.status = ""
+	m.play = false
+	m.done = false
+	m.err = nil
+	m.viewport.YOffset = 0
+}
+
+func (m *PlaylistsModel) load() {
+	playlists, err := m.store.GetPlaylists()
+	if err != nil {
+		m.err = err
+		return
+	}
+	m.playlists = playlists
+	m.cursor = min(m.cursor, max(0, len(m.playlists)-1))
+
+	// Follow the renames and deletions of the target
+	if m.target != nil {
+		target := m.target
+		m.target = nil
+		for _, playlist := range m.playlists {
+			if playlist.ID == target.ID {
+				m.target = &playlist
+			}
+		}
+	}
+}
+
+func (m *PlaylistsModel) loadTracks() {
+	tracks, err := m.store.GetPlaylistTracks(m.open.ID)
+	if err != nil {
+		m.err = err
+		return
+	}
+	m.tracks = tracks
+	m.trackCursor = min(m.trackCursor, max(0, len(m.tracks)-1))
+}
+
+// Target returns the playlist receiving the tracks added from the browser
+func (m PlaylistsModel) Target() (media.Playlist, bool) {
+	if m.target == nil {
+		return media.Playlist{}, false
+	}
+	return *m.target, true
+}
+
+// AddTracks appends tracks to the target playlist
+func (m *PlaylistsModel) AddTracks(tracks []media.Track) error {
+	if m.target == nil {
+		return fmt.Errorf("no playlist to add the tracks to")
+	}
+	if err := m.store.AddPlaylistTracks(m.target.ID, trackIDs(tracks)); err != nil {
+		return err
+	}
+	m.load()
+	return nil
+}
+
+func (m *PlaylistsModel) Update(msg tea.Msg) (PlaylistsModel, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		if !m.ready {
+			m.viewport = viewport.New(msg.Width, msg.Height)
+			m.ready = true
+		} else {
+			m.viewport.Width = msg.Width
+			m.viewport.Height = msg.Height
+		}
+
+	case tea.KeyMsg:
+		m.err = nil
+		m.status = ""
+		m.imported = nil
+		if m.confirm != nil {
+			m.updateConfirm(msg)
+			return *m, nil
+		}
+		if m.prompt != noPrompt {
+			return *m, m.updatePrompt(msg)
+		}
+		if m.open != nil {
+			cmd := m.updateTracks(msg)
+			m.follow()
+			return *m, cmd
+		}
+
+		switch msg.String() {
+		case "up":
+			m.cursor = max(0, m.cursor-1)
+		case "down":
+			m.cursor = min(m.cursor+1, max(0, len(m.playlists)-1))
+		case "esc", "backspace":
+			m.pending = nil
+			m.done = true
+		case "n":
+			return *m, m.startPrompt(createPrompt, "My Playlist", "")
+		case "s":
+			if m.pending == nil {
+				return *m, m.startPrompt(smartPrompt, "Recently added", "")
+			}
+		case "e":
+			if m.cursor < len(m.playlists) && m.playlists[m.cursor].Smart() {
+				return *m, m.editRules(m.playlists[m.cursor])
+			}
+		case "r":
+			if m.cursor < len(m.playlists) {
+				m.selected = &m.playlists[m.cursor]
+				return *m, m.startPrompt(renamePrompt, "My Playlist", m.selected.Name)
+			}
+		case "i":
+			if m.pending == nil {
+				return *m, m.startPrompt(importPrompt, "/path/to/playlist.m3u", "")
+			}
+		case "x":
+			if m.cursor < len(m.playlists) && m.pending == nil {
+				m.selected = &m.playlists[m.cursor]
+				return *m, m.startPrompt(exportPrompt, "/path/to/playlist.m3u", exportPath(*m.selected))
+			}
+		case "d", "delete":
+			if m.cursor < len(m.playlists) {
+				m.deleting = m.playlists[m.cursor]
+				confirm := NewConfirmModel(fmt.Sprintf(
+					"Delete the playlist %q? Its tracks stay in the library.",
+					m.deleting.Name,
+				))
+				m.confirm = &confirm
+			}
+		case "enter":
+			if m.cursor >= len(m.playlists) {
+				break
+			}
+			playlist := m.playlists[m.cursor]
+			if playlist.Smart() {
+				if m.pending != nil {
+					m.err = errSmartTarget
+					break
+				}
+			} else {
+				m.target = &playlist
+			}
+			if m.pending != nil {
+				m.addPending()
+				break
+			}
+			m.open = &playlist
+			m.trackCursor = 0
+			m.viewport.YOffset = 0
+			m.loadTracks()
+		}
+	}
+	m.follow()
+	return *m, nil
+}
+
+// follow scrolls to keep the line under the cursor visible
+func (m *PlaylistsModel) follow() {
+	const header = 3 // Title and spacing
+	line := header + m.cursor
+	if m.open != nil {
+		line = header + m.trackCursor
+	}
+	if line-scrollMargin < m.viewport.YOffset {
+		m.viewport.YOffset = max(0, line-scrollMargin)
+	}
+	if line+scrollMargin >= m.viewport.YOffset+m.viewport.Height {
+		m.viewport.YOffset = max(0, line+scrollMargin-m.viewport.Height+1)
+	}
+}
+
+// updateTracks handles the keys on the tracks of the open playlist, smart
+// playlists following their rules instead of being edited
+func (m *PlaylistsModel) updateTracks(msg tea.KeyMsg) tea.Cmd {
+	if m.open.Smart() {
+		switch msg.String() {
+		case "e":
+			return m.editRules(*m.open)
+		case "d", "delete", "K", "shift+up", "J", "shift+down":
+			return nil
+		}
+	}
+
+	switch msg.String() {
+	case "up":
+		m.trackCursor = max(0, m.trackCursor-1)
+	case "down":
+		m.trackCursor = min(m.trackCursor+1, max(0, len(m.tracks)-1))
+	case "esc", "backspace":
+		m.open = nil
+		m.viewport.YOffset = 0
+		m.load()
+	case "enter":
+		if m.trackCursor < len(m.tracks) {
+			// Queue the whole playlist so playback continues after this track
+			if err := m.queue.Replace(m.tracks, m.trackCursor); err != nil {
+				m.err = err
+				return nil
+			}
+			m.play = true
+		}
+	case "d", "delete":
+		if m.trackCursor < len(m.tracks) {
+			tracks := append([]media.Track{}, m.tracks[:m.trackCursor]...)
+			m.saveTracks(append(tracks, m.tracks[m.trackCursor+1:]...))
+		}
+	case "K", "shift+up":
+		if m.trackCursor > 0 {
+			m.swapTracks(m.trackCursor, m.trackCursor-1)
+		}
+	case "J", "shift+down":
+		if m.trackCursor < len(m.tracks)-1 {
+			m.swapTracks(m.trackCursor, m.trackCursor+1)
+		}
+	}
+	return nil
+}
+
+// swapTracks moves the track under the cursor to another position
+func (m *PlaylistsModel) swapTracks(from, to int) {
+	tracks := append([]media.Track{}, m.tracks...)
+	tracks[from], tracks[to] = tracks[to], tracks[from]
+	if m.saveTracks(tracks) {
+		m.trackCursor = to
+	}
+}
+
+// saveTracks replaces the tracks of the open playlist, it reports whether
+// they were saved
+func (m *PlaylistsModel) saveTracks(tracks []media.Track) bool {
+	if err := m.store.SetPlaylistTracks(m.open.ID, trackIDs(tracks)); err != nil {
+		m.err = err
+		return false
+	}
+	m.tracks = tracks
+	m.trackCursor = min(m.trackCursor, max(0, len(m.tracks)-1))
+	return true
+}
+
+// addPending adds the tracks waiting for a playlist to the target and goes
+// back to the browser
+func (m *PlaylistsModel) addPending() {
+	tracks := m.pending
+	if err := m.AddTracks(tracks); err != nil {
+		m.err = err
+		return
+	}
+	m.pending = nil
+	m.status = addedStatus(len(tracks), m.target.Name)
+	m.done = true
+}
+
+// editRules asks the new rules of a smart playlist
+func (m *PlaylistsModel) editRules(playlist media.Playlist) tea.Cmd {
+	m.selected = &playlist
+	return m.startPrompt(rulesPrompt, rulesPlaceholder, playlist.Rules.String())
+}
+
+// startPrompt shows the input for the given prompt
+func (m *PlaylistsModel) startPrompt(prompt promptKind, placeholder, value string) tea.Cmd {
+	m.prompt = prompt
+	m.input = textinput.New()
+	m.input.Placeholder = placeholder
+	m.input.SetValue(value)
+	return m.input.Focus()
+}
+
+// updatePrompt handles the keys typed into the input
+func (m *PlaylistsModel) updatePrompt(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "esc":
+		m.prompt = noPrompt
+		return nil
+	case "enter":
+		value := strings.TrimSpace(m.input.Value())
+		if value == "" {
+			m.err = fmt.Errorf("a value is required")
+			return nil
+		}
+		prompt := m.prompt
+		m.prompt = noPrompt
+		switch prompt {
+		case createPrompt:
+			created, err := m.store.CreatePlaylist(value)
+			if err != nil {
+				m.err = err
+				return nil
+			}
+			m.load()
+			m.moveTo(created.ID)
+		case renamePrompt:
+			if err := m.store.RenamePlaylist(m.selected.ID, value); err != nil {
+				m.err = err
+			}
+			m.load()
+		case importPrompt:
+			result, err := playlist.Import(m.store, expandHome(value), "")
+			if err != nil {
+				m.err = err
+				return nil
+			}
+			m.imported = &result
+			m.load()
+			m.moveTo(result.Playlist.ID)
+		case exportPrompt:
+			if err := playlist.Export(m.store, m.selected.ID, expandHome(value)); err != nil {
+				m.err = err
+				return nil
+			}
+			m.status = fmt.Sprintf("Exported %s to %s", m.selected.Name, value)
+		case smartPrompt:
+			m.smartName = value
+			m.selected = nil
+			return m.startPrompt(rulesPrompt, rulesPlaceholder, "")
+		case rulesPrompt:
+			m.saveRules(value)
+		}
+		return nil
+	}
+
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+	return cmd
+}
+
+// saveRules creates the smart playlist named smartName with rules written as
+// text, or updates the rules of the selected one. Invalid rules stay in the
+// prompt to be fixed.
+func (m *PlaylistsModel) saveRules(text string) {
+	rules, err := media.ParseSmartRules(text)
+	if err != nil {
+		m.err = err
+		m.prompt = rulesPrompt
+		return
+	}
+	if m.selected == nil {
+		created, err := m.store.CreateSmartPlaylist(m.smartName, rules)
+		if err != nil {
+			m.err = err
+			return
+		}
+		m.load()
+		m.moveTo(created.ID)
+		return
+	}
+
+	if err := m.store.SetPlaylistRules(m.selected.ID, rules); err != nil {
+		m.err = err
+		return
+	}
+	m.load()
+	if m.open != nil && m.open.ID == m.selected.ID {
+		m.open.Rules = &rules
+		m.loadTracks()
+	}
+}
+
+// moveTo moves the cursor to a playlist
+func (m *PlaylistsModel) moveTo(playlistID string) {
+	for i := range m.playlists {
+		if m.playlists[i].ID == playlistID {
+			m.cursor = i
+		}
+	}
+}
+
+// updateConfirm handles the answer to the deletion prompt
+func (m *PlaylistsModel) updateConfirm(msg tea.Msg) {
+	confirm := m.confirm.Update(msg)
+	if !confirm.Answered() {
+		m.confirm = &confirm
+		return
+	}
+	m.confirm = nil
+	if !confirm.Confirmed() {
+		return
+	}
+	if err := m.store.DeletePlaylist(m.deleting.ID); err != nil {
+		m.err = err
+	}
+	m.load()
+}
+
+func (m PlaylistsModel) View() string {
+	if !m.ready {
+		return "\n  Initializing..."
+	}
+
+	var content strings.Builder
+	if m.open != nil {
+		m.viewTracks(&content)
+	} else {
+		m.viewPlaylists(&content)
+	}
+
+	if m.err != nil {
+		content.WriteString("\n" + m.styles.error.Render(m.err.Error()) + "\n")
+	}
+
+	m.viewport.SetContent(content.String())
+	return m.viewport.View()
+}
+
+func (m PlaylistsModel) viewPlaylists(content *strings.Builder) {
+	title := "Playlists"
+	if m.pending != nil {
+		title = fmt.Sprintf("Add %d tracks to playlist", len(m.pending))
+	}
+	content.WriteString(m.styles.title.Render(title) + "\n\n")
+
+	for i, playlist := range m.playlists {
+		cursor := " "
+		if i == m.cursor {
+			cursor = m.styles.cursor.Render(">")
+		}
+		marker := ""
+		if m.target != nil && m.target.ID == playlist.ID {
+			marker = " *"
+		}
+		if playlist.Smart() {
+			marker = m.styles.smart.Render(" (smart)")
+		}
+		content.WriteString(fmt.Sprintf(
+			"%s %s%s%s\n",
+			cursor,
+			m.styles.playlist.Render(playlist.Name),
+			marker,
+			m.styles.metadata.Render(fmt.Sprintf(
+				" - %d tracks, %s",
+				playlist.Tracks,
+				formatTotalDuration(playlist.Duration),
+			)),
+		))
+	}
+	if len(m.playlists) == 0 {
+		content.WriteString("No playlists. Press 'n' to create one.\n")
+	}
+
+	if m.imported != nil {
+		m.viewImported(content)
+	} else if m.status != "" {
+		content.WriteString("\n" + m.styles.metadata.Render(m.status) + "\n")
+	}
+
+	content.WriteString("\n")
+	switch {
+	case m.prompt != noPrompt:
+		m.viewPrompt(content)
+	case m.confirm != nil:
+		content.WriteString(m.confirm.View())
+	case m.pending != nil:
+		content.WriteString(m.styles.help.Render(
+			"enter: Add to playlist • n: New • esc: Cancel",
+		))
+	default:
+		content.WriteString(m.styles.help.Render(
+			"enter: Open • n: New • s: New smart • e: Edit rules • r: Rename • d: Delete • i: Import • x: Export • esc: Back",
+		))
+	}
+}
+
+// viewPrompt renders the input and what it is asked for
+func (m PlaylistsModel) viewPrompt(content *strings.Builder) {
+	var label string
+	switch m.prompt {
+	case createPrompt:
+		label = "New playlist name:"
+	case renamePrompt:
+		label = "Rename " + m.selected.Name + ":"
+	case importPrompt:
+		label = "Import the M3U, PLS or XSPF file:"
+	case exportPrompt:
+		label = "Export " + m.selected.Name + " to (.m3u, .m3u8, .pls or .xspf):"
+	case smartPrompt:
+		label = "New smart playlist name:"
+	case rulesPrompt:
+		label = "Rules of " + m.smartName + ":"
+		if m.selected != nil {
+			label = "Rules of " + m.selected.Name + ":"
+		}
+	}
+	content.WriteString(m.styles.label.Render(label) + "\n")
+	content.WriteString(m.input.View() + "\n")
+	content.WriteString(m.styles.help.Render("enter: Save • esc: Cancel"))
+}
+
+// viewImported reports the tracks of the last import and the entries it
+// could not find in the library
+func (m PlaylistsModel) viewImported(content *strings.Builder) {
+	content.WriteString("\n" + m.styles.metadata.Render(fmt.Sprintf(
+		"Imported %d tracks into %s",
+		m.imported.Tracks,
+		m.imported.Playlist.Name,
+	)) + "\n")
+	unresolved := m.imported.Unresolved
+	if len(unresolved) == 0 {
+		return
+	}
+	content.WriteString(m.styles.error.Render(fmt.Sprintf(
+		"%d entries not found in the library:", len(unresolved),
+	)) + "\n")
+	for _, entry := range unresolved[:min(len(unresolved), importedShown)] {
+		content.WriteString(m.styles.metadata.Render("  "+entry.String()) + "\n")
+	}
+	if len(unresolved) > importedShown {
+		content.WriteString(m.styles.metadata.Render(fmt.Sprintf(
+			"  and %d more", len(unresolved)-importedShown,
+		)) + "\n")
+	}
+}
+
+func (m PlaylistsModel) viewTracks(content *strings.Builder) {
+	content.WriteString(m.styles.title.Render(m.open.Name) + "\n\n")
+
+	for i, track := range m.tracks {
+		cursor := " "
+		if i == m.trackCursor {
+			cursor = m.styles.cursor.Render(">")
+		}
+		title := track.Title
+		if title == "" {
+			title = "Unknown Title"
+		}
+		artist := track.Artist
+		if artist == "" {
+			artist = "Unknown Artist"
+		}
+		content.WriteString(fmt.Sprintf(
+			"%s %s%s\n",
+			cursor,
+			m.styles.track.Render(title),
+			m.styles.metadata.Render(" - "+artist),
+		))
+	}
+	switch {
+	case len(m.tracks) > 0:
+	case m.open.Smart():
+		content.WriteString("No tracks match the rules of this playlist.\n")
+	default:
+		content.WriteString("This playlist is empty. Press '+' in the browser to add tracks.\n")
+	}
+
+	content.WriteString("\n")
+	switch {
+	case m.prompt != noPrompt:
+		m.viewPrompt(content)
+	case m.open.Smart():
+		content.WriteString(m.styles.smart.Render(m.open.Rules.String()) + "\n")
+		content.WriteString(m.styles.help.Render("enter: Play • e: Edit rules • esc: Back"))
+	default:
+		content.WriteString(m.styles.help.Render(
+			"enter: Play • K/J: Move • d: Remove • esc: Back",
+		))
+	}
+}
+
+// Playing reports whether a track of the playlist was queued to play
+func (m PlaylistsModel) Playing() bool {
+	return m.play
+}
+
+func (m PlaylistsModel) Done() bool {
+	return m.done
+}
+
+// Status returns the message to show in the browser once done
+func (m PlaylistsModel) Status() string {
+	return m.status
+}
+
+func trackIDs(tracks []media.Track) []string {
+	ids := make([]string, len(tracks))
+	for i, track := range tracks {
+		ids[i] = track.ID
+	}
+	return ids
+}
+
+// addedStatus reports tracks added to a playlist
+func addedStatus(count int, playlist string) string {
+	if count == 1 {
+		return fmt.Sprintf("Added 1 track to %s", playlist)
+	}
+	return fmt.Sprintf("Added %d tracks to %s", count, playlist)
+}
+
+// exportPath returns the default file a playlist is exported to
+func exportPath(p media.Playlist) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return p.Name + ".m3u8"
+	}
+	return filepath.Join(home, p.Name+".m3u8")
+}
+
+// expandHome replaces a leading ~ with the home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
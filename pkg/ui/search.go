@@ -0,0 +1,127 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// searchLimit bounds the number of search results shown
+const searchLimit = 200
+
+// startSearch opens the search over the library, coming back to the current
+// list when it is closed
+func (m *BrowserModel) startSearch() tea.Cmd {
+	m.searchReturn = m.mode
+	m.mode = SearchMode
+	m.viewport.YOffset = 0
+	m.searchInput = textinput.New()
+	m.searchInput.Placeholder = "karma pol, artist:radiohead year:1997"
+	m.results = nil
+	m.resultCursor = 0
+	return m.searchInput.Focus()
+}
+
+// Typing reports whether keys go to the search query rather than shortcuts
+func (m BrowserModel) Typing() bool {
+	return m.mode == SearchMode
+}
+
+// updateSearch handles the keys while searching, the results follow the
+// query as it is typed
+func (m *BrowserModel) updateSearch(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "esc":
+		m.mode = m.searchReturn
+		m.viewport.YOffset = 0
+		return nil
+	case "up":
+		m.moveCursor(-1)
+		return nil
+	case "down":
+		m.moveCursor(1)
+		return nil
+	case "enter":
+		if m.resultCursor < len(m.results) {
+			// Queue the results so playback continues after this track
+			if err := m.queue.Replace(m.results, m.resultCursor); err != nil {
+				m.err = err
+				return nil
+			}
+			m.selectedTrack = m.results[m.resultCursor].Path
+		}
+		return nil
+	case "ctrl+n":
+		if m.resultCursor < len(m.results) {
+			if err := m.queue.EnqueueNext(m.results[m.resultCursor]); err != nil {
+				m.err = err
+			}
+		}
+		return nil
+	case "ctrl+e":
+		if m.resultCursor < len(m.results) {
+			if err := m.queue.EnqueueLast(m.results[m.resultCursor]); err != nil {
+				m.err = err
+			}
+		}
+		return nil
+	}
+
+	query := m.searchInput.Value()
+	var cmd tea.Cmd
+	m.searchInput, cmd = m.searchInput.Update(msg)
+	if m.searchInput.Value() != query {
+		m.resultCursor = 0
+		m.viewport.YOffset = 0
+		m.runSearch()
+	}
+	return cmd
+}
+
+// runSearch updates the results for the current query
+func (m *BrowserModel) runSearch() {
+	results, err := m.manager.Search(m.searchInput.Value(), searchLimit)
+	if err != nil {
+		m.err = err
+		return
+	}
+	m.results = results
+	m.resultCursor = min(m.resultCursor, max(0, len(m.results)-1))
+}
+
+// viewSearch renders the query and its results
+func (m BrowserModel) viewSearch() string {
+	var list strings.Builder
+	list.WriteString(m.searchInput.View() + "\n\n")
+
+	for i, track := range m.results {
+		cursor := " "
+		if i == m.resultCursor {
+			cursor = m.styles.cursor.Render(">")
+		}
+		artist := track.Artist
+		if artist == "" {
+			artist = "Unknown Artist"
+		}
+		metadata := " - " + artist
+		if track.Album != "" {
+			metadata += " (" + track.Album + ")"
+		}
+		list.WriteString(fmt.Sprintf(
+			"%s %s%s\n",
+			cursor,
+			m.styles.track.Render(track.Title),
+			m.styles.metadata.Render(metadata),
+		))
+	}
+	if len(m.results) == 0 && strings.TrimSpace(m.searchInput.Value()) != "" {
+		list.WriteString("No tracks found.\n")
+	}
+
+	list.WriteString(m.styles.status.Render(
+		"\nenter: Play • ctrl+n: Play next • ctrl+e: Enqueue • esc: Close",
+	))
+	return list.String()
+}